@@ -2,16 +2,28 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 
 	"inmem-db/internal/app"
 	"inmem-db/internal/config"
+	"inmem-db/internal/storage/wal"
 )
 
 func main() {
 	cfg := config.MustLoad()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate-wal" {
+		err := migrateWAL(cfg, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	a, err := app.New(cfg)
 	if err != nil {
 		log.Fatal(err)
@@ -24,3 +36,40 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// migrateWAL переписывает журнал в текущий формат. Сервер должен быть остановлен.
+func migrateWAL(cfg config.Server, args []string) error {
+	walCfg := config.WAL{MaxSegmentSize: "10MB"}
+	if cfg.Wal != nil {
+		walCfg = *cfg.Wal
+	}
+
+	flags := flag.NewFlagSet("migrate-wal", flag.ExitOnError)
+	flags.StringVar(&walCfg.DataDir, "dir", walCfg.DataDir, "WAL data directory, by default from config")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if walCfg.DataDir == "" {
+		return fmt.Errorf("wal data directory is not set")
+	}
+
+	w, err := wal.New(walCfg)
+	if err != nil {
+		return fmt.Errorf("new wal: %w", err)
+	}
+	defer w.Close()
+
+	ctx := context.Background()
+	// старые файлы, замененные снимком от мастера, мигрировать не нужно
+	err = w.FinishInstall(ctx)
+	if err != nil {
+		return fmt.Errorf("finish snapshot install: %w", err)
+	}
+	migrated, err := w.Migrate(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", walCfg.DataDir, err)
+	}
+	fmt.Printf("migrated %d files in %s\n", migrated, walCfg.DataDir)
+	return nil
+}
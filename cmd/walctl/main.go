@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/engine"
+	"inmem-db/internal/storage/wal"
+)
+
+const usage = `usage: walctl <command> [flags]
+
+Commands work with a stopped server, the directory is taken from config or -dir.
+
+  list       segments with ID, command count and offset
+  dump       segments with commands, -format text|json
+  verify     check segments and snapshots, exit code 1 on problems
+  truncate   remove segments after -after ID
+  export     final key/value state, -format text|json
+  restore    copy snapshot and segments up to -to-id or -to-time into empty -out
+`
+
+var errProblems = errors.New("wal has problems")
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := config.MustLoad()
+	ctx := context.Background()
+
+	var err error
+	switch name, args := os.Args[1], os.Args[2:]; name {
+	case "list":
+		err = list(ctx, cfg, args)
+	case "dump":
+		err = dump(ctx, cfg, args)
+	case "verify":
+		err = verify(ctx, cfg, args)
+	case "truncate":
+		err = truncate(ctx, cfg, args)
+	case "export":
+		err = export(ctx, cfg, args)
+	case "restore":
+		err = restore(ctx, cfg, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, errProblems) {
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// flags - общие флаги команд: каталог журнала и формат вывода
+type flags struct {
+	*flag.FlagSet
+	cfg    config.WAL
+	format string
+}
+
+func newFlags(name string, cfg config.Server) *flags {
+	f := flags{
+		FlagSet: flag.NewFlagSet(name, flag.ExitOnError),
+		cfg:     config.WAL{MaxSegmentSize: "10MB"},
+	}
+	if cfg.Wal != nil {
+		f.cfg = *cfg.Wal
+	}
+	f.StringVar(&f.cfg.DataDir, "dir", f.cfg.DataDir, "WAL data directory, by default from config")
+	return &f
+}
+
+func (f *flags) withFormat() *flags {
+	f.StringVar(&f.format, "format", "text", "output format: text or json")
+	return f
+}
+
+// open разбирает флаги и открывает журнал, не создавая новых файлов
+func (f *flags) open(args []string) (*wal.WAL, error) {
+	err := f.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if f.format != "" && f.format != "text" && f.format != "json" {
+		return nil, fmt.Errorf("unknown format %q", f.format)
+	}
+	if f.cfg.DataDir == "" {
+		return nil, fmt.Errorf("wal data directory is not set")
+	}
+	_, err = os.Stat(f.cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := wal.New(f.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new wal: %w", err)
+	}
+	// walctl только читает каталог, установку снимка завершит сервер при запуске
+	pending, err := w.PendingInstall()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	for _, name := range pending {
+		fmt.Fprintf(os.Stderr, "pending snapshot install: %s, wal files and older snapshots are stale\n", name)
+	}
+	return w, nil
+}
+
+func list(ctx context.Context, cfg config.Server, args []string) error {
+	w, err := newFlags("list", cfg).open(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	fmt.Printf("%-20s %-10s %10s %10s %8s  %s\n", "FILE", "OFFSET", "SIZE", "ID", "COMMANDS", "TIME")
+	tail, err := w.Inspect(ctx, func(info wal.SegmentInfo) error {
+		fmt.Printf("%-20s %-10d %10d %10d %8d  %s\n",
+			info.File, info.Offset, info.Size, info.ID, len(info.Commands), formatTime(info.Time))
+		return nil
+	})
+	printTail(os.Stdout, tail)
+	return err
+}
+
+// segmentJSON - сегмент в выводе dump -format json
+type segmentJSON struct {
+	File     string   `json:"file"`
+	Offset   int64    `json:"offset"`
+	Size     int64    `json:"size"`
+	ID       wal.ID   `json:"id"`
+	Time     string   `json:"time,omitempty"`
+	Commands []string `json:"commands"`
+}
+
+func dump(ctx context.Context, cfg config.Server, args []string) error {
+	f := newFlags("dump", cfg).withFormat()
+	w, err := f.open(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	tail, err := w.Inspect(ctx, func(info wal.SegmentInfo) error {
+		cmds := make([]string, 0, len(info.Commands))
+		for _, cmd := range info.Commands {
+			cmds = append(cmds, formatCommand(cmd))
+		}
+
+		if f.format == "json" {
+			return enc.Encode(segmentJSON{
+				File:     info.File,
+				Offset:   info.Offset,
+				Size:     info.Size,
+				ID:       info.ID,
+				Time:     formatTime(info.Time),
+				Commands: cmds,
+			})
+		}
+		fmt.Printf("segment %d %s@%d size %d %s\n", info.ID, info.File, info.Offset, info.Size, formatTime(info.Time))
+		for _, cmd := range cmds {
+			fmt.Printf("  %s\n", cmd)
+		}
+		return nil
+	})
+	printTail(os.Stderr, tail)
+	return err
+}
+
+func verify(ctx context.Context, cfg config.Server, args []string) error {
+	w, err := newFlags("verify", cfg).open(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	report, err := w.Verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("files: %d, segments: %d, ids: %d..%d\n", report.Files, report.Segments, report.FirstID, report.LastID)
+	for _, s := range report.Snapshots {
+		status := "ok"
+		if s.Err != nil {
+			status = s.Err.Error()
+		}
+		fmt.Printf("snapshot %s (segment %d): %s\n", s.Name, s.ID, status)
+	}
+	printTail(os.Stdout, report.Tail)
+	for _, p := range report.Problems {
+		fmt.Printf("problem: %s\n", p)
+	}
+
+	if !report.OK() {
+		return errProblems
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func truncate(ctx context.Context, cfg config.Server, args []string) error {
+	f := newFlags("truncate", cfg)
+	after := f.Uint64("after", 0, "last segment ID to keep")
+	w, err := f.open(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if *after == 0 {
+		return fmt.Errorf("-after is required")
+	}
+	err = w.TruncateAfter(ctx, wal.ID(*after))
+	if err != nil {
+		return fmt.Errorf("truncate %s: %w", f.cfg.DataDir, err)
+	}
+	fmt.Printf("removed segments after %d in %s\n", *after, f.cfg.DataDir)
+	return nil
+}
+
+func restore(ctx context.Context, cfg config.Server, args []string) error {
+	f := newFlags("restore", cfg)
+	toID := f.Int64("to-id", 0, "last segment ID to restore")
+	toTime := f.String("to-time", "", "restore segments written up to this time, RFC 3339")
+	out := f.String("out", "", "empty directory for the restored WAL")
+	w, err := f.open(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	target, err := wal.ParseTarget(*toID, *toTime)
+	if err != nil {
+		return err
+	}
+	last, err := w.RestoreTo(ctx, *out, target)
+	if err != nil {
+		return fmt.Errorf("restore %s: %w", f.cfg.DataDir, err)
+	}
+	fmt.Printf("restored %s up to segment %d into %s\n", f.cfg.DataDir, last, *out)
+	return nil
+}
+
+// keyJSON - ключ в выводе export -format json
+type keyJSON struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Deadline - время истечения в unix ms, 0 - без времени жизни
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// export восстанавливает данные из снимка и журнала в памяти, как сервер при старте,
+// но не обрезает оборванный хвост
+func export(ctx context.Context, cfg config.Server, args []string) error {
+	f := newFlags("export", cfg).withFormat()
+	w, err := f.open(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	e := engine.New()
+	snapshotID, err := w.LoadSnapshot(ctx, func(cmd command.Command) error {
+		return e.Recover(ctx, cmd)
+	})
+	if err != nil {
+		return err
+	}
+	tail, err := w.Inspect(ctx, func(info wal.SegmentInfo) error {
+		if info.ID <= snapshotID {
+			return nil
+		}
+		for _, cmd := range info.Commands {
+			err := e.Recover(ctx, cmd)
+			if err != nil {
+				return fmt.Errorf("replay segment %d: %w", info.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	printTail(os.Stderr, tail)
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, cmd := range e.Dump(ctx) {
+		if f.format == "json" {
+			err = enc.Encode(keyJSON{Key: cmd.Name, Value: cmd.Set.Value, Deadline: cmd.Expire.Deadline})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(formatCommand(cmd))
+	}
+	return nil
+}
+
+func printTail(w io.Writer, tail *wal.TornTail) {
+	if tail == nil {
+		return
+	}
+	fmt.Fprintf(w, "torn tail: %s at offset %d: %s\n", tail.File, tail.Offset, tail.Err)
+}
+
+// formatCommand записывает команду в синтаксисе клиента, parser разбирает ее обратно
+func formatCommand(cmd command.Command) string {
+	parts := []string{string(cmd.Type)}
+	if cmd.IsMultiKey() {
+		for i, key := range cmd.Keys {
+			parts = append(parts, quote(key))
+			if i < len(cmd.Values) {
+				parts = append(parts, quote(cmd.Values[i]))
+			}
+		}
+	} else if cmd.Name != "" {
+		parts = append(parts, quote(cmd.Name))
+	}
+
+	switch cmd.Type {
+	case command.CommandSET:
+		parts = append(parts, quote(cmd.Set.Value))
+		switch cmd.Set.Condition {
+		case command.SetIfNotExists:
+			parts = append(parts, "NX")
+		case command.SetIfExists:
+			parts = append(parts, "XX")
+		}
+	case command.CommandCAS:
+		parts = append(parts, quote(cmd.Set.Expected), quote(cmd.Set.Value))
+	case command.CommandINCR, command.CommandDECR, command.CommandINCRBY, command.CommandDECRBY:
+		parts = append(parts, strconv.FormatInt(cmd.Incr.Delta, 10))
+	}
+	if cmd.Expire.Deadline != 0 {
+		deadline := strconv.FormatInt(cmd.Expire.Deadline, 10)
+		// в журнале EXPIRE хранит абсолютное время, клиент задает его через PEXPIREAT
+		if cmd.Type == command.CommandEXPIRE {
+			parts[0] = "PEXPIREAT"
+			parts = append(parts, deadline)
+		} else {
+			parts = append(parts, "PXAT", deadline)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatTime - время записи сегмента, у сегментов старого формата его нет
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -9,6 +9,7 @@ import (
 
 	"inmem-db/internal/compute/parser"
 	"inmem-db/internal/config"
+	"inmem-db/internal/pubsub"
 	"inmem-db/internal/server/cli"
 	"inmem-db/internal/server/tcp"
 	"inmem-db/internal/storage"
@@ -20,6 +21,7 @@ import (
 
 type App struct {
 	server  *tcp.Server
+	engine  *engine.Engine
 	storage *storage.Storage
 
 	beforeStart func(ctx context.Context) error
@@ -32,20 +34,54 @@ func New(cfg config.Server) (App, error) {
 		return App{}, err
 	}
 
-	a := App{}
 	p := parser.Parser{}
-	e := engine.New()
 
-	factory := cli.NewFactory(p, e)
+	// pub/sub не зависит от хранилища и работает и на мастере, и на реплике
+	broker, err := pubsub.New(cfg.PubSub)
+	if err != nil {
+		return App{}, fmt.Errorf("new pubsub: %w", err)
+	}
+	cliOptions := []cli.Option{cli.WithPubSub(broker)}
+	if cfg.Network.MaxMsgSize != "" {
+		size, err := config.ParseSize(cfg.Network.MaxMsgSize)
+		if err != nil {
+			return App{}, fmt.Errorf("max message size: %w", err)
+		}
+		cliOptions = append(cliOptions, cli.WithMaxMessageSize(int(size)))
+	}
+
+	engineOptions := []engine.Option{}
+	if len(cfg.Notifications.Events) > 0 {
+		notifier, err := pubsub.NewNotifier(broker, cfg.Notifications)
+		if err != nil {
+			return App{}, fmt.Errorf("new notifier: %w", err)
+		}
+		engineOptions = append(engineOptions, engine.WithNotifier(notifier))
+	}
+
+	e, err := newEngine(cfg.Engine, engineOptions...)
+	if err != nil {
+		return App{}, err
+	}
+	a := App{engine: e}
+
+	factory := cli.NewFactory(p, e, cliOptions...)
 
 	if cfg.Wal != nil {
-		w, err := wal.New(*cfg.Wal)
+		walCfg, err := restoreWAL(*cfg.Wal)
+		if err != nil {
+			return App{}, err
+		}
+		w, err := wal.New(walCfg)
 		if err != nil {
 			return App{}, fmt.Errorf("new wal: %w", err)
 		}
 
-		s := newStorage(e, w, cfg.Replication)
-		factory = cli.NewFactory(p, s)
+		s, err := newStorage(e, w, walCfg, cfg.Replication)
+		if err != nil {
+			return App{}, err
+		}
+		factory = cli.NewFactory(p, s, cliOptions...)
 
 		a.beforeStart = func(ctx context.Context) error {
 			return s.Restore(ctx)
@@ -81,6 +117,10 @@ func (a *App) Start(ctx context.Context) error {
 		grp.Go(func() error {
 			return a.storage.Start(ctx)
 		})
+	} else {
+		grp.Go(func() error {
+			return a.engine.Start(ctx)
+		})
 	}
 
 	return grp.Wait()
@@ -116,19 +156,126 @@ func factoryAdapter(f cli.Factory) tcp.HandlerFactory {
 	}
 }
 
-func newStorage(e *engine.Engine, w *wal.WAL, cfg *config.Replication) *storage.Storage {
+// newEngine создает engine по конфигу, extra применяются после выбора типа engine
+func newEngine(cfg config.Engine, extra ...engine.Option) (*engine.Engine, error) {
+	options := []engine.Option{}
+
+	switch cfg.Type {
+	case config.EngineTypeMem, "":
+	case config.EngineTypeOrdered:
+		options = append(options, engine.WithOrdered())
+	default:
+		return nil, fmt.Errorf("unknown engine type: %q", cfg.Type)
+	}
+
+	if cfg.MaxMemory != "" {
+		limit, err := config.ParseSize(cfg.MaxMemory)
+		if err != nil {
+			return nil, fmt.Errorf("max memory: %w", err)
+		}
+
+		switch cfg.EvictionPolicy {
+		case config.EvictionNo, config.EvictionAllKeysLRU, config.EvictionAllKeysLFU, config.EvictionVolatileTTL:
+		case "":
+			cfg.EvictionPolicy = config.EvictionNo
+		default:
+			return nil, fmt.Errorf("unknown eviction policy: %q", cfg.EvictionPolicy)
+		}
+		options = append(options, engine.WithMemoryLimit(limit, cfg.EvictionPolicy))
+	}
+
+	maxKey, maxValue := uint64(0), uint64(0)
+	if cfg.MaxKeySize != "" {
+		size, err := config.ParseSize(cfg.MaxKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("max key size: %w", err)
+		}
+		maxKey = size
+	}
+	if cfg.MaxValueSize != "" {
+		size, err := config.ParseSize(cfg.MaxValueSize)
+		if err != nil {
+			return nil, fmt.Errorf("max value size: %w", err)
+		}
+		maxValue = size
+	}
+	options = append(options, engine.WithSizeLimits(maxKey, maxValue))
+
+	options = append(options, extra...)
+	return engine.New(options...), nil
+}
+
+// restoreWAL копирует журнал на момент RecoveryTarget в новый каталог и возвращает
+// конфигурацию журнала в нем. Без RecoveryTarget конфигурация не меняется.
+// Восстановление делается один раз: уже восстановленный каталог используется как есть,
+// сервер мог дописать в него новые сегменты.
+func restoreWAL(cfg config.WAL) (config.WAL, error) {
+	rt := cfg.RecoveryTarget
+	if rt == nil {
+		return cfg, nil
+	}
+	if rt.DataDir == "" {
+		return cfg, fmt.Errorf("recovery target data directory is not set")
+	}
+	target, err := wal.ParseTarget(rt.SegmentID, rt.Time)
+	if err != nil {
+		return cfg, err
+	}
+
+	restored, err := wal.Restored(rt.DataDir)
+	if err != nil {
+		return cfg, err
+	}
+	if restored {
+		slog.Info("wal is already restored, recovery target is skipped", slog.String("dir", rt.DataDir))
+		cfg.DataDir = rt.DataDir
+		cfg.RecoveryTarget = nil
+		return cfg, nil
+	}
+
+	src, err := wal.New(cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("new wal: %w", err)
+	}
+	defer src.Close()
+
+	_, err = src.RestoreTo(context.Background(), rt.DataDir, target)
+	if err != nil {
+		return cfg, fmt.Errorf("restore wal to %s: %w", rt.DataDir, err)
+	}
+	cfg.DataDir = rt.DataDir
+	cfg.RecoveryTarget = nil
+	return cfg, nil
+}
+
+func newStorage(e *engine.Engine, w *wal.WAL, walCfg config.WAL, cfg *config.Replication) (*storage.Storage, error) {
+	options := []storage.Option{}
+
+	walSize := uint64(0)
+	if walCfg.SnapshotWALSize != "" {
+		size, err := config.ParseSize(walCfg.SnapshotWALSize)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot wal size: %w", err)
+		}
+		walSize = size
+	}
+	options = append(options, storage.WithSnapshotPolicy(walCfg.SnapshotInterval, walSize))
+
 	if cfg != nil {
 		switch cfg.ReplicaType {
 
 		case config.MasterReplica:
-			server := storage.NewMasterServer(cfg.MasterAddress, w)
-			return storage.New(e, w, storage.WithMasterServer(server))
+			server, err := storage.NewMasterServer(*cfg, w)
+			if err != nil {
+				return nil, fmt.Errorf("master server: %w", err)
+			}
+			options = append(options, storage.WithMasterServer(server))
 
 		case config.SlaveReplica:
 			client := storage.NewReplicationClient(*cfg, w, e)
-			return storage.New(e, w, storage.WithReplicationClient(client))
+			options = append(options, storage.WithReplicationClient(client))
 		}
 	}
 
-	return storage.New(e, w)
+	return storage.New(e, w, options...), nil
 }
@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"math"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"inmem-db/internal/domain/command"
 )
@@ -12,25 +16,64 @@ import (
 var (
 	ErrUnknownCommand = errors.New("unknown command")
 	ErrArgs           = errors.New("invalid number of args")
+	ErrExpire         = errors.New("invalid expire time")
+	ErrInteger        = errors.New("invalid integer argument")
+	ErrCount          = errors.New("invalid count")
+	ErrLimit          = errors.New("invalid limit")
+	ErrPort           = errors.New("invalid port")
 )
 
 const (
-	getArgsCnt = 1
-	delArgsCnt = 1
-	setArgsCnt = 2
+	getArgsCnt     = 1
+	delArgsCnt     = 1
+	setArgsCnt     = 2
+	casArgsCnt     = 3
+	expireArgsCnt  = 2
+	persistArgsCnt = 1
+	ttlArgsCnt     = 1
+	incrArgsCnt    = 1
+	incrByArgsCnt  = 2
+	keysArgsCnt    = 1
+	dbsizeArgsCnt  = 0
+	rangeArgsCnt   = 2
+	rangeLimitCnt  = 4
+	publishArgsCnt = 2
+	replicaArgsCnt = 2
 )
 
+// pexpireatCommand задает абсолютное время истечения в миллисекундах,
+// разбирается в EXPIRE с Deadline
+const pexpireatCommand = "PEXPIREAT"
+
+const (
+	exOption   = "EX"
+	pxatOption = "PXAT"
+	nxOption   = "NX"
+	xxOption   = "XX"
+
+	matchOption = "MATCH"
+	countOption = "COUNT"
+	limitOption = "LIMIT"
+
+	noOption  = "NO"
+	oneOption = "ONE"
+)
+
+// defaultScanCount - сколько ключей SCAN возвращает за раз без COUNT
+const defaultScanCount = 10
+
+const maxSeconds = int64(math.MaxInt64 / time.Second)
+
 type Parser struct{}
 
 func (p Parser) Parse(ctx context.Context, line string) (command.Command, error) {
-	const minWordsCnt = 2
-	const maxWordsCnt = 3
+	const minWordsCnt = 1
 
 	slog.DebugContext(ctx, "parse", slog.String("line", line))
 
 	words := strings.Fields(line)
 
-	if len(words) > maxWordsCnt || len(words) < minWordsCnt {
+	if len(words) < minWordsCnt {
 		return command.Command{}, ErrUnknownCommand
 	}
 
@@ -44,7 +87,64 @@ func (p Parser) Parse(ctx context.Context, line string) (command.Command, error)
 		return parseDEL(args)
 	case string(command.CommandSET):
 		return parseSET(args)
-
+	case string(command.CommandCAS):
+		return parseCAS(args)
+	case string(command.CommandMGET):
+		return parseKeys(command.Command{Type: command.CommandMGET}, args)
+	case string(command.CommandMDEL):
+		return parseKeys(command.Command{Type: command.CommandMDEL}, args)
+	case string(command.CommandMSET):
+		return parseMSET(args)
+	case string(command.CommandSCAN):
+		return parseSCAN(args)
+	case string(command.CommandKEYS):
+		return parseKEYS(args)
+	case string(command.CommandDBSIZE):
+		return parseDBSIZE(args)
+	case string(command.CommandRANGE):
+		return parseRANGE(command.Command{Type: command.CommandRANGE}, args)
+	case string(command.CommandREVRANGE):
+		return parseRANGE(command.Command{Type: command.CommandREVRANGE}, args)
+	case string(command.CommandEXPIRE):
+		return parseEXPIRE(args)
+	case pexpireatCommand:
+		return parsePEXPIREAT(args)
+	case string(command.CommandPERSIST):
+		return parsePERSIST(args)
+	case string(command.CommandTTL):
+		return parseTTL(args)
+	case string(command.CommandINCR):
+		return parseINCR(command.Command{Type: command.CommandINCR}, args, 1)
+	case string(command.CommandDECR):
+		return parseINCR(command.Command{Type: command.CommandDECR}, args, -1)
+	case string(command.CommandINCRBY):
+		return parseINCRBY(command.Command{Type: command.CommandINCRBY}, args, 1)
+	case string(command.CommandDECRBY):
+		return parseINCRBY(command.Command{Type: command.CommandDECRBY}, args, -1)
+	case string(command.CommandMULTI):
+		return parseNoArgs(command.Command{Type: command.CommandMULTI}, args)
+	case string(command.CommandEXEC):
+		return parseNoArgs(command.Command{Type: command.CommandEXEC}, args)
+	case string(command.CommandDISCARD):
+		return parseNoArgs(command.Command{Type: command.CommandDISCARD}, args)
+	case string(command.CommandUNWATCH):
+		return parseNoArgs(command.Command{Type: command.CommandUNWATCH}, args)
+	case string(command.CommandWATCH):
+		return parseNonEmptyKeys(command.Command{Type: command.CommandWATCH}, args)
+	case string(command.CommandSUBSCRIBE):
+		return parseNonEmptyKeys(command.Command{Type: command.CommandSUBSCRIBE}, args)
+	case string(command.CommandPSUBSCRIBE):
+		return parseNonEmptyKeys(command.Command{Type: command.CommandPSUBSCRIBE}, args)
+	case string(command.CommandUNSUBSCRIBE):
+		return parseKeys(command.Command{Type: command.CommandUNSUBSCRIBE}, args)
+	case string(command.CommandPUNSUBSCRIBE):
+		return parseKeys(command.Command{Type: command.CommandPUNSUBSCRIBE}, args)
+	case string(command.CommandPUBLISH):
+		return parsePUBLISH(args)
+	case string(command.CommandSNAPSHOT):
+		return parseNoArgs(command.Command{Type: command.CommandSNAPSHOT}, args)
+	case string(command.CommandREPLICAOF):
+		return parseREPLICAOF(args)
 	}
 	return command.Command{}, ErrUnknownCommand
 }
@@ -60,16 +160,78 @@ func parseGET(args []string) (command.Command, error) {
 }
 
 func parseSET(args []string) (command.Command, error) {
-	if len(args) != setArgsCnt {
+	if len(args) < setArgsCnt {
 		return command.Command{}, ErrArgs
 	}
-	return command.Command{
+	cmd := command.Command{
 		Type: command.CommandSET,
 		Name: args[0],
 
 		Set: command.SetArgs{
 			Value: args[1],
 		},
+	}
+
+	opts := args[setArgsCnt:]
+	for len(opts) > 0 {
+		switch opts[0] {
+		case exOption:
+			if len(opts) < 2 || cmd.Expire != (command.ExpireArgs{}) {
+				return command.Command{}, ErrArgs
+			}
+			ttl, err := parseSeconds(opts[1])
+			if err != nil {
+				return command.Command{}, err
+			}
+			cmd.Expire.TTL = ttl
+			opts = opts[2:]
+			continue
+
+		case pxatOption:
+			if len(opts) < 2 || cmd.Expire != (command.ExpireArgs{}) {
+				return command.Command{}, ErrArgs
+			}
+			deadline, err := parseDeadline(opts[1])
+			if err != nil {
+				return command.Command{}, err
+			}
+			cmd.Expire.Deadline = deadline
+			opts = opts[2:]
+			continue
+
+		case nxOption:
+			if cmd.Set.Condition != command.SetAlways {
+				return command.Command{}, ErrArgs
+			}
+			cmd.Set.Condition = command.SetIfNotExists
+
+		case xxOption:
+			if cmd.Set.Condition != command.SetAlways {
+				return command.Command{}, ErrArgs
+			}
+			cmd.Set.Condition = command.SetIfExists
+
+		default:
+			return command.Command{}, ErrArgs
+		}
+		opts = opts[1:]
+	}
+
+	return cmd, nil
+}
+
+func parseCAS(args []string) (command.Command, error) {
+	if len(args) != casArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	return command.Command{
+		Type: command.CommandCAS,
+		Name: args[0],
+
+		Set: command.SetArgs{
+			Expected: args[1],
+			Value:    args[2],
+		},
 	}, nil
 }
 
@@ -82,3 +244,244 @@ func parseDEL(args []string) (command.Command, error) {
 		Name: args[0],
 	}, nil
 }
+
+func parseKeys(cmd command.Command, args []string) (command.Command, error) {
+	cmd.Keys = args
+	return cmd, nil
+}
+
+func parseMSET(args []string) (command.Command, error) {
+	if len(args)%2 != 0 {
+		return command.Command{}, ErrArgs
+	}
+	cmd := command.Command{
+		Type:   command.CommandMSET,
+		Keys:   make([]string, 0, len(args)/2),
+		Values: make([]string, 0, len(args)/2),
+	}
+	for i := 0; i < len(args); i += 2 {
+		cmd.Keys = append(cmd.Keys, args[i])
+		cmd.Values = append(cmd.Values, args[i+1])
+	}
+	return cmd, nil
+}
+
+func parseSCAN(args []string) (command.Command, error) {
+	if len(args) == 0 {
+		return command.Command{}, ErrArgs
+	}
+	cmd := command.Command{
+		Type: command.CommandSCAN,
+		Scan: command.ScanArgs{
+			Cursor: args[0],
+			Count:  defaultScanCount,
+		},
+	}
+
+	opts := args[1:]
+	for len(opts) > 0 {
+		if len(opts) < 2 {
+			return command.Command{}, ErrArgs
+		}
+		switch opts[0] {
+		case matchOption:
+			cmd.Scan.Match = opts[1]
+		case countOption:
+			count, err := strconv.Atoi(opts[1])
+			if err != nil || count <= 0 {
+				return command.Command{}, ErrCount
+			}
+			cmd.Scan.Count = count
+		default:
+			return command.Command{}, ErrArgs
+		}
+		opts = opts[2:]
+	}
+
+	return cmd, nil
+}
+
+func parseNoArgs(cmd command.Command, args []string) (command.Command, error) {
+	if len(args) != 0 {
+		return command.Command{}, ErrArgs
+	}
+	return cmd, nil
+}
+
+// parseNonEmptyKeys - как parseKeys, но нужен хотя бы один аргумент
+func parseNonEmptyKeys(cmd command.Command, args []string) (command.Command, error) {
+	if len(args) == 0 {
+		return command.Command{}, ErrArgs
+	}
+	cmd.Keys = args
+	return cmd, nil
+}
+
+func parsePUBLISH(args []string) (command.Command, error) {
+	if len(args) != publishArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	return command.Command{
+		Type:    command.CommandPUBLISH,
+		Name:    args[0],
+		Message: args[1],
+	}, nil
+}
+
+// parseREPLICAOF разбирает REPLICAOF NO ONE и REPLICAOF host port
+func parseREPLICAOF(args []string) (command.Command, error) {
+	if len(args) != replicaArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	cmd := command.Command{Type: command.CommandREPLICAOF}
+	if args[0] == noOption && args[1] == oneOption {
+		return cmd, nil
+	}
+
+	port, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil || port == 0 {
+		return command.Command{}, ErrPort
+	}
+	cmd.MasterAddress = net.JoinHostPort(args[0], args[1])
+	return cmd, nil
+}
+
+func parseKEYS(args []string) (command.Command, error) {
+	if len(args) != keysArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	return command.Command{
+		Type: command.CommandKEYS,
+		Scan: command.ScanArgs{
+			Match: args[0],
+		},
+	}, nil
+}
+
+func parseDBSIZE(args []string) (command.Command, error) {
+	if len(args) != dbsizeArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	return command.Command{
+		Type: command.CommandDBSIZE,
+	}, nil
+}
+
+func parseRANGE(cmd command.Command, args []string) (command.Command, error) {
+	if len(args) != rangeArgsCnt && len(args) != rangeLimitCnt {
+		return command.Command{}, ErrArgs
+	}
+	cmd.Range = command.RangeArgs{
+		Start: args[0],
+		End:   args[1],
+	}
+	if len(args) == rangeArgsCnt {
+		return cmd, nil
+	}
+
+	if args[2] != limitOption {
+		return command.Command{}, ErrArgs
+	}
+	limit, err := strconv.Atoi(args[3])
+	if err != nil || limit <= 0 {
+		return command.Command{}, ErrLimit
+	}
+	cmd.Range.Limit = limit
+	return cmd, nil
+}
+
+func parseEXPIRE(args []string) (command.Command, error) {
+	if len(args) != expireArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	ttl, err := parseSeconds(args[1])
+	if err != nil {
+		return command.Command{}, err
+	}
+	return command.Command{
+		Type: command.CommandEXPIRE,
+		Name: args[0],
+
+		Expire: command.ExpireArgs{
+			TTL: ttl,
+		},
+	}, nil
+}
+
+func parsePEXPIREAT(args []string) (command.Command, error) {
+	if len(args) != expireArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	deadline, err := parseDeadline(args[1])
+	if err != nil {
+		return command.Command{}, err
+	}
+	return command.Command{
+		Type: command.CommandEXPIRE,
+		Name: args[0],
+
+		Expire: command.ExpireArgs{
+			Deadline: deadline,
+		},
+	}, nil
+}
+
+func parsePERSIST(args []string) (command.Command, error) {
+	if len(args) != persistArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	return command.Command{
+		Type: command.CommandPERSIST,
+		Name: args[0],
+	}, nil
+}
+
+func parseTTL(args []string) (command.Command, error) {
+	if len(args) != ttlArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	return command.Command{
+		Type: command.CommandTTL,
+		Name: args[0],
+	}, nil
+}
+
+func parseINCR(cmd command.Command, args []string, delta int64) (command.Command, error) {
+	if len(args) != incrArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	cmd.Name = args[0]
+	cmd.Incr.Delta = delta
+	return cmd, nil
+}
+
+func parseINCRBY(cmd command.Command, args []string, sign int64) (command.Command, error) {
+	if len(args) != incrByArgsCnt {
+		return command.Command{}, ErrArgs
+	}
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	// -MinInt64 не помещается в int64
+	if err != nil || (sign < 0 && delta == math.MinInt64) {
+		return command.Command{}, ErrInteger
+	}
+	cmd.Name = args[0]
+	cmd.Incr.Delta = sign * delta
+	return cmd, nil
+}
+
+func parseSeconds(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seconds <= 0 || seconds > maxSeconds {
+		return 0, ErrExpire
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// parseDeadline разбирает время истечения в миллисекундах Unix
+func parseDeadline(s string) (int64, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, ErrExpire
+	}
+	return ms, nil
+}
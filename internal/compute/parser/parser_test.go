@@ -4,6 +4,7 @@ import (
 	"context"
 	"inmem-db/internal/domain/command"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -70,6 +71,383 @@ func TestParser(t *testing.T) {
 			err: nil,
 		},
 
+		"SET with expire": {
+			input: "SET name value EX 10",
+			cmd: command.Command{
+				Type: command.CommandSET,
+				Name: "name",
+				Set: command.SetArgs{
+					Value: "value",
+				},
+				Expire: command.ExpireArgs{
+					TTL: 10 * time.Second,
+				},
+			},
+			err: nil,
+		},
+		"SET with unknown option": {
+			input: "SET name value PX 10",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"SET with expire at": {
+			input: "SET name value PXAT 1700000000000 XX",
+			cmd: command.Command{
+				Type: command.CommandSET,
+				Name: "name",
+				Set: command.SetArgs{
+					Value:     "value",
+					Condition: command.SetIfExists,
+				},
+				Expire: command.ExpireArgs{
+					Deadline: 1700000000000,
+				},
+			},
+			err: nil,
+		},
+		"SET with EX and PXAT": {
+			input: "SET name value EX 10 PXAT 1700000000000",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"SET with invalid expire at": {
+			input: "SET name value PXAT 0",
+			cmd:   command.Command{},
+			err:   ErrExpire,
+		},
+		"SET with negative expire": {
+			input: "SET name value EX -1",
+			cmd:   command.Command{},
+			err:   ErrExpire,
+		},
+
+		"SET NX with expire": {
+			input: "SET name value EX 10 NX",
+			cmd: command.Command{
+				Type: command.CommandSET,
+				Name: "name",
+				Set: command.SetArgs{
+					Value:     "value",
+					Condition: command.SetIfNotExists,
+				},
+				Expire: command.ExpireArgs{
+					TTL: 10 * time.Second,
+				},
+			},
+			err: nil,
+		},
+		"SET XX": {
+			input: "SET name value XX",
+			cmd: command.Command{
+				Type: command.CommandSET,
+				Name: "name",
+				Set: command.SetArgs{
+					Value:     "value",
+					Condition: command.SetIfExists,
+				},
+			},
+			err: nil,
+		},
+		"SET NX and XX": {
+			input: "SET name value NX XX",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"SET EX without seconds": {
+			input: "SET name value NX EX",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"CAS simple": {
+			input: "CAS name old new",
+			cmd: command.Command{
+				Type: command.CommandCAS,
+				Name: "name",
+				Set: command.SetArgs{
+					Expected: "old",
+					Value:    "new",
+				},
+			},
+			err: nil,
+		},
+		"CAS without new value": {
+			input: "CAS name old",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+
+		"EXPIRE simple": {
+			input: "EXPIRE name 5",
+			cmd: command.Command{
+				Type: command.CommandEXPIRE,
+				Name: "name",
+				Expire: command.ExpireArgs{
+					TTL: 5 * time.Second,
+				},
+			},
+			err: nil,
+		},
+		"EXPIRE without seconds": {
+			input: "EXPIRE name",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"EXPIRE with not numeric seconds": {
+			input: "EXPIRE name five",
+			cmd:   command.Command{},
+			err:   ErrExpire,
+		},
+		"PEXPIREAT simple": {
+			input: "PEXPIREAT name 1700000000000",
+			cmd: command.Command{
+				Type: command.CommandEXPIRE,
+				Name: "name",
+				Expire: command.ExpireArgs{
+					Deadline: 1700000000000,
+				},
+			},
+			err: nil,
+		},
+		"PEXPIREAT with not numeric time": {
+			input: "PEXPIREAT name soon",
+			cmd:   command.Command{},
+			err:   ErrExpire,
+		},
+		"PERSIST simple": {
+			input: "PERSIST name",
+			cmd: command.Command{
+				Type: command.CommandPERSIST,
+				Name: "name",
+			},
+			err: nil,
+		},
+		"TTL simple": {
+			input: "TTL name",
+			cmd: command.Command{
+				Type: command.CommandTTL,
+				Name: "name",
+			},
+			err: nil,
+		},
+
+		"INCR simple": {
+			input: "INCR counter",
+			cmd: command.Command{
+				Type: command.CommandINCR,
+				Name: "counter",
+				Incr: command.IncrArgs{Delta: 1},
+			},
+			err: nil,
+		},
+		"DECRBY simple": {
+			input: "DECRBY counter 5",
+			cmd: command.Command{
+				Type: command.CommandDECRBY,
+				Name: "counter",
+				Incr: command.IncrArgs{Delta: -5},
+			},
+			err: nil,
+		},
+		"INCRBY not integer": {
+			input: "INCRBY counter five",
+			cmd:   command.Command{},
+			err:   ErrInteger,
+		},
+		"DECR with many args": {
+			input: "DECR counter 5",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+
+		"MGET many keys": {
+			input: "MGET k1 k2 k3",
+			cmd: command.Command{
+				Type: command.CommandMGET,
+				Keys: []string{"k1", "k2", "k3"},
+			},
+			err: nil,
+		},
+		"MSET pairs": {
+			input: "MSET k1 v1 k2 v2",
+			cmd: command.Command{
+				Type:   command.CommandMSET,
+				Keys:   []string{"k1", "k2"},
+				Values: []string{"v1", "v2"},
+			},
+			err: nil,
+		},
+		"MSET without value": {
+			input: "MSET k1 v1 k2",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"MDEL many keys": {
+			input: "MDEL k1 k2",
+			cmd: command.Command{
+				Type: command.CommandMDEL,
+				Keys: []string{"k1", "k2"},
+			},
+			err: nil,
+		},
+
+		"SCAN with options": {
+			input: "SCAN 17 MATCH user:* COUNT 100",
+			cmd: command.Command{
+				Type: command.CommandSCAN,
+				Scan: command.ScanArgs{
+					Cursor: "17",
+					Match:  "user:*",
+					Count:  100,
+				},
+			},
+			err: nil,
+		},
+		"SCAN default count": {
+			input: "SCAN 0",
+			cmd: command.Command{
+				Type: command.CommandSCAN,
+				Scan: command.ScanArgs{
+					Cursor: "0",
+					Count:  defaultScanCount,
+				},
+			},
+			err: nil,
+		},
+		"SCAN with invalid count": {
+			input: "SCAN 0 COUNT 0",
+			cmd:   command.Command{},
+			err:   ErrCount,
+		},
+		"SCAN without option value": {
+			input: "SCAN 0 MATCH",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"KEYS pattern": {
+			input: "KEYS evt:*",
+			cmd: command.Command{
+				Type: command.CommandKEYS,
+				Scan: command.ScanArgs{
+					Match: "evt:*",
+				},
+			},
+			err: nil,
+		},
+		"DBSIZE": {
+			input: "DBSIZE",
+			cmd: command.Command{
+				Type: command.CommandDBSIZE,
+			},
+			err: nil,
+		},
+		"GET without name": {
+			input: "GET",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+
+		"RANGE with limit": {
+			input: "RANGE evt:a evt:z LIMIT 5",
+			cmd: command.Command{
+				Type: command.CommandRANGE,
+				Range: command.RangeArgs{
+					Start: "evt:a",
+					End:   "evt:z",
+					Limit: 5,
+				},
+			},
+			err: nil,
+		},
+		"REVRANGE": {
+			input: "REVRANGE a z",
+			cmd: command.Command{
+				Type: command.CommandREVRANGE,
+				Range: command.RangeArgs{
+					Start: "a",
+					End:   "z",
+				},
+			},
+			err: nil,
+		},
+		"RANGE with invalid limit": {
+			input: "RANGE a z LIMIT -1",
+			cmd:   command.Command{},
+			err:   ErrLimit,
+		},
+		"MULTI": {
+			input: "MULTI",
+			cmd:   command.Command{Type: command.CommandMULTI},
+			err:   nil,
+		},
+		"EXEC with args": {
+			input: "EXEC now",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"WATCH": {
+			input: "WATCH a b",
+			cmd: command.Command{
+				Type: command.CommandWATCH,
+				Keys: []string{"a", "b"},
+			},
+			err: nil,
+		},
+		"WATCH without keys": {
+			input: "WATCH",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"PSUBSCRIBE": {
+			input: "PSUBSCRIBE news.* sport",
+			cmd: command.Command{
+				Type: command.CommandPSUBSCRIBE,
+				Keys: []string{"news.*", "sport"},
+			},
+			err: nil,
+		},
+		"UNSUBSCRIBE from all": {
+			input: "UNSUBSCRIBE",
+			cmd:   command.Command{Type: command.CommandUNSUBSCRIBE, Keys: []string{}},
+			err:   nil,
+		},
+		"PUBLISH": {
+			input: "PUBLISH news hello",
+			cmd: command.Command{
+				Type:    command.CommandPUBLISH,
+				Name:    "news",
+				Message: "hello",
+			},
+			err: nil,
+		},
+		"REPLICAOF NO ONE": {
+			input: "REPLICAOF NO ONE",
+			cmd:   command.Command{Type: command.CommandREPLICAOF},
+			err:   nil,
+		},
+		"REPLICAOF host port": {
+			input: "REPLICAOF localhost 3232",
+			cmd: command.Command{
+				Type:          command.CommandREPLICAOF,
+				MasterAddress: "localhost:3232",
+			},
+			err: nil,
+		},
+		"REPLICAOF invalid port": {
+			input: "REPLICAOF localhost port",
+			cmd:   command.Command{},
+			err:   ErrPort,
+		},
+		"REPLICAOF without port": {
+			input: "REPLICAOF localhost",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+		"PUBLISH without message": {
+			input: "PUBLISH news",
+			cmd:   command.Command{},
+			err:   ErrArgs,
+		},
+
 		"DEL with many args": {
 			input: "DEL name value",
 			cmd:   command.Command{},
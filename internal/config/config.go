@@ -16,20 +16,45 @@ type Server struct {
 	Logging     Logging      `mapstructure:"logging"`
 	Wal         *WAL         `mapstructure:"wal"`
 	Replication *Replication `mapstructure:"replication"`
+	PubSub      PubSub       `mapstructure:"pubsub"`
+
+	Notifications Notifications `mapstructure:"notifications"`
 }
 
 type EngineType string
 
 const (
-	EngineTypeMem = "in_memory"
+	EngineTypeMem     = "in_memory"
+	EngineTypeOrdered = "ordered"
 )
 
 type Engine struct {
 	Type EngineType `mapstructure:"type"`
+
+	// MaxMemory - приблизительный предел объема ключей и значений, пусто - без ограничения
+	MaxMemory      string         `mapstructure:"max_memory"`
+	EvictionPolicy EvictionPolicy `mapstructure:"eviction_policy"`
+
+	// MaxKeySize и MaxValueSize - наибольшие размеры ключа и значения, пусто - 64KB и 512MB
+	MaxKeySize   string `mapstructure:"max_key_size"`
+	MaxValueSize string `mapstructure:"max_value_size"`
 }
 
+type EvictionPolicy string
+
+const (
+	EvictionNo          EvictionPolicy = "noeviction"
+	EvictionAllKeysLRU  EvictionPolicy = "allkeys-lru"
+	EvictionAllKeysLFU  EvictionPolicy = "allkeys-lfu"
+	EvictionVolatileTTL EvictionPolicy = "volatile-ttl"
+)
+
 type Network struct {
-	Address     string        `mapstructure:"address"`
+	Address string `mapstructure:"address"`
+
+	// MaxMsgSize - максимальная длина строки команды. Буфер чтения соединения растет
+	// до этого размера, поэтому он ограничивает память на соединение. Чтобы записывать
+	// значения до engine.max_value_size, его нужно поднять явно.
 	MaxMsgSize  string        `mapstructure:"max_message_size"`
 	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
 
@@ -48,6 +73,84 @@ type WAL struct {
 
 	MaxSegmentSize string `mapstructure:"max_segment_size"`
 	DataDir        string `mapstructure:"data_directory"`
+
+	// SnapshotInterval - период автоматических снимков, 0 - выключено
+	SnapshotInterval time.Duration `mapstructure:"snapshot_interval"`
+	// SnapshotWALSize - снимок делается, когда журнал после прошлого снимка вырос до этого размера
+	SnapshotWALSize string `mapstructure:"snapshot_wal_size"`
+
+	// SyncMode - когда записанное сбрасывается на диск, пусто - always
+	SyncMode SyncMode `mapstructure:"sync_mode"`
+	// SyncInterval - период сброса на диск в режиме interval
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+
+	// Compression - сжатие новых сегментов, пусто - без сжатия
+	Compression Compression `mapstructure:"compression"`
+
+	// EncryptionKeyFile или EncryptionKeyEnv - файл или переменная окружения с ключами AES-GCM
+	// вида "<id>:<hex>", новые записи шифруются ключом с наибольшим id. Пусто - без шифрования.
+	// Реплике нужны те же ключи: сегменты передаются зашифрованными.
+	EncryptionKeyFile string `mapstructure:"encryption_key_file"`
+	EncryptionKeyEnv  string `mapstructure:"encryption_key_env"`
+
+	// RecoveryTarget - восстановление на момент в прошлом при запуске, nil - журнал читается до конца
+	RecoveryTarget *RecoveryTarget `mapstructure:"recovery_target"`
+}
+
+// RecoveryTarget - сегмент и время, до которых восстанавливается журнал. Снимок и сегменты
+// до цели копируются в пустой DataDir, сервер работает с ним, исходный журнал не меняется.
+// Копирование делается при первом запуске, следующие запуски продолжают работу в DataDir.
+type RecoveryTarget struct {
+	SegmentID int64 `mapstructure:"segment_id"`
+	// Time - время в формате RFC 3339
+	Time    string `mapstructure:"time"`
+	DataDir string `mapstructure:"data_directory"`
+}
+
+type Compression string
+
+const (
+	CompressionNone   Compression = "none"
+	CompressionSnappy Compression = "snappy"
+	CompressionZstd   Compression = "zstd"
+)
+
+// SyncMode - гарантия сохранности записи на момент ответа клиенту
+type SyncMode string
+
+const (
+	// SyncModeAlways сбрасывает пачку на диск до ответа
+	SyncModeAlways SyncMode = "always"
+	// SyncModeInterval сбрасывает журнал в фоне раз в SyncInterval, ответ ждет ближайшего сброса
+	SyncModeInterval SyncMode = "interval"
+	// SyncModeNone оставляет сброс операционной системе
+	SyncModeNone SyncMode = "none"
+)
+
+type PubSub struct {
+	// BufferSize - сколько сообщений может ждать отправки одному подписчику
+	BufferSize int            `mapstructure:"buffer_size"`
+	Overflow   OverflowPolicy `mapstructure:"overflow"`
+}
+
+// OverflowPolicy - что делать с подписчиком, который не успевает читать сообщения
+type OverflowPolicy string
+
+const (
+	// OverflowDisconnect отключает подписчика
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowDrop пропускает сообщения, пока в буфере нет места
+	OverflowDrop OverflowPolicy = "drop"
+)
+
+// Notifications - уведомления об изменениях ключей в каналах pub/sub.
+// События порождают команды клиентов и изменения от мастера на реплике.
+// Восстановление из журнала при запуске и загрузка состояния мастера их не повторяют.
+type Notifications struct {
+	// Events - классы событий: set, del, expire, evict, пусто - уведомления выключены
+	Events []string `mapstructure:"events"`
+	// WithValues добавляет значение ключа в события set
+	WithValues bool `mapstructure:"with_values"`
 }
 
 type replicationType string
@@ -60,9 +163,49 @@ const (
 type Replication struct {
 	ReplicaType   replicationType `mapstructure:"replica_type"`
 	MasterAddress string          `mapstructure:"master_address"`
-	SyncInterval  time.Duration   `mapstructure:"sync_interval"`
+	// ListenAddress - адрес сервера репликации реплики после REPLICAOF NO ONE,
+	// пусто - повышенная реплика не отдает сегменты
+	ListenAddress string `mapstructure:"listen_address"`
+	// Mode - как реплика получает сегменты, пусто - stream
+	Mode ReplicationMode `mapstructure:"mode"`
+	// SyncInterval - период опроса мастера в режиме poll и пауза перед переподключением
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+	// HeartbeatInterval - как часто мастер пишет в простаивающий поток в режиме stream,
+	// должен быть меньше таймаута простоя сервера репликации (3s).
+	// Реплика переподключается, если не получила ничего за три интервала.
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	// VerifyInterval - как часто реплика сверяет контрольную сумму данных с мастером, 0 - выключено
+	VerifyInterval time.Duration `mapstructure:"verify_interval"`
+
+	// MinReplicaAcks - сколько реплик должны сохранить сегмент, прежде чем мастер ответит
+	// на запись, 0 - не ждать. Сегмент сохранен на реплике так, как обещает ее sync_mode.
+	// Подтверждают сегменты только реплики в режиме stream.
+	MinReplicaAcks int `mapstructure:"min_replica_acks"`
+	// AckTimeout - сколько запись ждет подтверждений реплик, пусто - 1s
+	AckTimeout time.Duration `mapstructure:"ack_timeout"`
+	// AckPolicy - что делать, если подключено меньше MinReplicaAcks реплик, пусто - fail
+	AckPolicy AckPolicy `mapstructure:"ack_policy"`
 }
 
+type ReplicationMode string
+
+const (
+	// ReplicationStream - реплика подписывается один раз, мастер сам присылает новые сегменты
+	ReplicationStream ReplicationMode = "stream"
+	// ReplicationPoll - реплика раз в SyncInterval запрашивает сегменты после последнего
+	ReplicationPoll ReplicationMode = "poll"
+)
+
+// AckPolicy - что делать с записью, если подключено слишком мало реплик
+type AckPolicy string
+
+const (
+	// AckFail возвращает ошибку записи, сама запись уже применена на мастере
+	AckFail AckPolicy = "fail"
+	// AckDegrade отвечает на запись, не дожидаясь реплик
+	AckDegrade AckPolicy = "degrade"
+)
+
 const (
 	LevelDebug LogLevel = "debug"
 	LevelInfo  LogLevel = "info"
@@ -1,4 +1,4 @@
-package fstore
+package config
 
 import (
 	"fmt"
@@ -15,7 +15,7 @@ var sizeMap = map[string]uint64{
 	"TB": 1 << 40,
 }
 
-func parseSize(size string) (uint64, error) {
+func ParseSize(size string) (uint64, error) {
 	var sizeScale string
 	nums := make([]rune, 0, len(size))
 	for i, r := range size {
@@ -1,4 +1,4 @@
-package fstore
+package config
 
 import (
 	"testing"
@@ -34,7 +34,7 @@ func TestParseSize(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			got, gotErr := parseSize(test.sizeStr)
+			got, gotErr := ParseSize(test.sizeStr)
 			if test.wantErr {
 				assert.Error(t, gotErr)
 				return
@@ -1,5 +1,7 @@
 package command
 
+import "time"
+
 type commandType string
 
 const (
@@ -7,16 +9,193 @@ const (
 	CommandSET commandType = "SET"
 	CommandDEL commandType = "DEL"
 
+	CommandEXPIRE  commandType = "EXPIRE"
+	CommandPERSIST commandType = "PERSIST"
+	CommandTTL     commandType = "TTL"
+
+	CommandINCR   commandType = "INCR"
+	CommandDECR   commandType = "DECR"
+	CommandINCRBY commandType = "INCRBY"
+	CommandDECRBY commandType = "DECRBY"
+
+	CommandCAS commandType = "CAS"
+
+	CommandMGET commandType = "MGET"
+	CommandMSET commandType = "MSET"
+	CommandMDEL commandType = "MDEL"
+
+	CommandSCAN   commandType = "SCAN"
+	CommandKEYS   commandType = "KEYS"
+	CommandDBSIZE commandType = "DBSIZE"
+
+	CommandRANGE    commandType = "RANGE"
+	CommandREVRANGE commandType = "REVRANGE"
+
+	CommandMULTI   commandType = "MULTI"
+	CommandEXEC    commandType = "EXEC"
+	CommandDISCARD commandType = "DISCARD"
+	CommandWATCH   commandType = "WATCH"
+	CommandUNWATCH commandType = "UNWATCH"
+
+	CommandSUBSCRIBE    commandType = "SUBSCRIBE"
+	CommandPSUBSCRIBE   commandType = "PSUBSCRIBE"
+	CommandUNSUBSCRIBE  commandType = "UNSUBSCRIBE"
+	CommandPUNSUBSCRIBE commandType = "PUNSUBSCRIBE"
+	CommandPUBLISH      commandType = "PUBLISH"
+
+	CommandSNAPSHOT  commandType = "SNAPSHOT"
+	CommandREPLICAOF commandType = "REPLICAOF"
+
 	CommandUnknown commandType = "Unknown"
 )
 
+var readOnly = map[commandType]bool{
+	CommandGET:  true,
+	CommandTTL:  true,
+	CommandMGET: true,
+
+	CommandSCAN:   true,
+	CommandKEYS:   true,
+	CommandDBSIZE: true,
+
+	CommandRANGE:    true,
+	CommandREVRANGE: true,
+}
+
+// keyless - команды, которые работают со всем пространством ключей
+var keyless = map[commandType]bool{
+	CommandSCAN:   true,
+	CommandKEYS:   true,
+	CommandDBSIZE: true,
+
+	CommandRANGE:    true,
+	CommandREVRANGE: true,
+}
+
+var multiKey = map[commandType]bool{
+	CommandMGET: true,
+	CommandMSET: true,
+	CommandMDEL: true,
+}
+
 type Command struct {
 	Type commandType
 
 	Name string
-	Set  SetArgs
+	// Keys - ключи команд MGET, MSET и MDEL, для MSET Values[i] - значение Keys[i].
+	// Для команд подписки - каналы или шаблоны.
+	Keys   []string
+	Values []string
+
+	Set    SetArgs
+	Expire ExpireArgs
+	Incr   IncrArgs
+	Scan   ScanArgs
+	Range  RangeArgs
+
+	// Message - сообщение PUBLISH, Name - канал
+	Message string
+	// MasterAddress - адрес мастера REPLICAOF host port, пусто - REPLICAOF NO ONE
+	MasterAddress string
 }
 
 type SetArgs struct {
 	Value string
+
+	Condition SetCondition
+	// Expected - текущее значение, при котором CAS выполняет запись
+	Expected string
+}
+
+// SetCondition - условие, при котором SET выполняет запись
+type SetCondition uint8
+
+const (
+	SetAlways SetCondition = iota
+	// SetIfNotExists - SET NX
+	SetIfNotExists
+	// SetIfExists - SET XX
+	SetIfExists
+)
+
+// IncrArgs - изменение числового значения, для DECR и DECRBY уже с отрицательным знаком
+type IncrArgs struct {
+	Delta int64
+}
+
+// ScanArgs - параметры обхода ключей SCAN и KEYS
+type ScanArgs struct {
+	Cursor string
+	Match  string
+	Count  int
+}
+
+// RangeArgs - отрезок ключей [Start, End] для RANGE и REVRANGE, Limit == 0 - без ограничения
+type RangeArgs struct {
+	Start string
+	End   string
+	Limit int
+}
+
+// ExpireArgs - время жизни ключа.
+// TTL задается клиентом, Deadline - абсолютное время истечения в unix ms,
+// именно оно пишется в WAL, чтобы восстановление и реплики получили тот же результат.
+type ExpireArgs struct {
+	TTL      time.Duration
+	Deadline int64
+}
+
+// IsReadOnly сообщает, что команда не изменяет данные
+func (c Command) IsReadOnly() bool {
+	return readOnly[c.Type]
+}
+
+// IsMultiKey сообщает, что ключи команды переданы в Keys, а не в Name
+func (c Command) IsMultiKey() bool {
+	return multiKey[c.Type]
+}
+
+// IsKeyless сообщает, что команда не принимает ключей
+func (c Command) IsKeyless() bool {
+	return keyless[c.Type]
+}
+
+// WithDeadline переводит относительный TTL в абсолютный Deadline
+func (c Command) WithDeadline(now time.Time) Command {
+	if c.Expire.Deadline != 0 || c.Expire.TTL <= 0 {
+		return c
+	}
+	c.Expire.Deadline = now.Add(c.Expire.TTL).UnixMilli()
+	c.Expire.TTL = 0
+	return c
+}
+
+// Tx - команды, накопленные между MULTI и EXEC, и ключи из WATCH
+type Tx struct {
+	Commands []Command
+	Watched  map[string]WatchedKey
+}
+
+// WatchedKey - состояние ключа на момент WATCH
+type WatchedKey struct {
+	// Version - версия ключа, 0 - ключа не было
+	Version uint64
+	// Since - версия хранилища на момент WATCH
+	Since uint64
+}
+
+// IsReadOnly сообщает, что ни одна команда транзакции не изменяет данные
+func (tx Tx) IsReadOnly() bool {
+	for _, cmd := range tx.Commands {
+		if !cmd.IsReadOnly() {
+			return false
+		}
+	}
+	return true
+}
+
+// Result - результат одной команды транзакции
+type Result struct {
+	Out string
+	Err error
 }
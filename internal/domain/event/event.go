@@ -0,0 +1,25 @@
+package event
+
+// Type - класс события пространства ключей
+type Type string
+
+const (
+	// Set - ключ записан: SET, CAS, MSET, INCR и их варианты
+	Set Type = "set"
+	// Del - ключ удален командой DEL или MDEL
+	Del Type = "del"
+	// Expire - истекло время жизни ключа, и он удален
+	Expire Type = "expire"
+	// Evict - ключ вытеснен при превышении max_memory
+	Evict Type = "evict"
+)
+
+// Types - все классы событий
+var Types = []Type{Set, Del, Expire, Evict}
+
+// Event - изменение ключа, Value заполнено только для Set
+type Event struct {
+	Type  Type
+	Key   string
+	Value string
+}
@@ -0,0 +1,59 @@
+package pubsub
+
+import (
+	"fmt"
+	"slices"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/event"
+)
+
+// Каналы уведомлений в стиле redis:
+// в __keyspace__:<ключ> приходит класс события, в __keyevent__:<класс> - ключ.
+// Для set со значениями значение добавляется через пробел.
+const (
+	KeyspacePrefix = "__keyspace__:"
+	KeyeventPrefix = "__keyevent__:"
+)
+
+// Notifier публикует события изменения ключей в брокер
+type Notifier struct {
+	b *Broker
+
+	events     map[event.Type]bool
+	withValues bool
+}
+
+func NewNotifier(b *Broker, cfg config.Notifications) (*Notifier, error) {
+	events := make(map[event.Type]bool, len(cfg.Events))
+	for _, name := range cfg.Events {
+		typ := event.Type(name)
+		if !slices.Contains(event.Types, typ) {
+			return nil, fmt.Errorf("unknown event class: %q", name)
+		}
+		events[typ] = true
+	}
+
+	return &Notifier{
+		b:          b,
+		events:     events,
+		withValues: cfg.WithValues,
+	}, nil
+}
+
+// Notify не блокируется, медленные подписчики обрабатываются политикой брокера
+func (n *Notifier) Notify(e event.Event) {
+	if !n.events[e.Type] {
+		return
+	}
+
+	keyspace := string(e.Type)
+	keyevent := e.Key
+	if n.withValues && e.Type == event.Set {
+		keyspace += " " + e.Value
+		keyevent += " " + e.Value
+	}
+
+	n.b.Publish(KeyspacePrefix+e.Key, keyspace)
+	n.b.Publish(KeyeventPrefix+string(e.Type), keyevent)
+}
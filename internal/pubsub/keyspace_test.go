@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"testing"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/event"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	b, err := New(config.PubSub{})
+	require.NoError(t, err)
+	n, err := NewNotifier(b, config.Notifications{
+		Events:     []string{"set", "expire"},
+		WithValues: true,
+	})
+	require.NoError(t, err)
+
+	s := b.NewSubscriber()
+	defer s.Close()
+	s.PSubscribe(KeyspacePrefix + "user:*")
+	s.Subscribe(KeyeventPrefix + "set")
+
+	n.Notify(event.Event{Type: event.Set, Key: "user:1", Value: "alice"})
+	n.Notify(event.Event{Type: event.Del, Key: "user:1"})
+	n.Notify(event.Event{Type: event.Expire, Key: "user:2"})
+
+	want := []Message{
+		{Pattern: KeyspacePrefix + "user:*", Channel: KeyspacePrefix + "user:1", Payload: "set alice"},
+		{Channel: KeyeventPrefix + "set", Payload: "user:1 alice"},
+		{Pattern: KeyspacePrefix + "user:*", Channel: KeyspacePrefix + "user:2", Payload: "expire"},
+	}
+	require.Len(t, s.Messages(), len(want))
+	for _, m := range want {
+		assert.Equal(t, m, <-s.Messages())
+	}
+}
+
+func TestNewNotifier_unknownEvent(t *testing.T) {
+	t.Parallel()
+
+	b, err := New(config.PubSub{})
+	require.NoError(t, err)
+
+	_, err = NewNotifier(b, config.Notifications{Events: []string{"rename"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,287 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"inmem-db/internal/config"
+	"inmem-db/pkg/glob"
+)
+
+var (
+	ErrSlowSubscriber = errors.New("subscriber is too slow, disconnected")
+	ErrClosed         = errors.New("subscriber closed")
+)
+
+// DefaultBufferSize - размер буфера подписчика, если он не задан в конфиге
+const DefaultBufferSize = 128
+
+type Message struct {
+	// Pattern - шаблон PSUBSCRIBE, по которому пришло сообщение, пусто для SUBSCRIBE
+	Pattern string
+	Channel string
+	Payload string
+}
+
+type pattern struct {
+	match glob.Matcher
+	subs  map[*Subscriber]struct{}
+}
+
+// Broker рассылает сообщения подписчикам каналов и шаблонов.
+// Publish не ждет подписчиков: у каждого свой ограниченный буфер,
+// при его переполнении срабатывает config.OverflowPolicy.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]*pattern
+
+	bufferSize int
+	overflow   config.OverflowPolicy
+}
+
+func New(cfg config.PubSub) (*Broker, error) {
+	if cfg.BufferSize < 0 {
+		return nil, fmt.Errorf("invalid buffer size: %d", cfg.BufferSize)
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+
+	switch cfg.Overflow {
+	case config.OverflowDisconnect, config.OverflowDrop:
+	case "":
+		cfg.Overflow = config.OverflowDisconnect
+	default:
+		return nil, fmt.Errorf("unknown overflow policy: %q", cfg.Overflow)
+	}
+
+	return &Broker{
+		channels:   make(map[string]map[*Subscriber]struct{}),
+		patterns:   make(map[string]*pattern),
+		bufferSize: cfg.BufferSize,
+		overflow:   cfg.Overflow,
+	}, nil
+}
+
+// NewSubscriber создает подписчика без подписок, после использования его нужно закрыть
+func (b *Broker) NewSubscriber() *Subscriber {
+	return &Subscriber{
+		b:        b,
+		messages: make(chan Message, b.bufferSize),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// Publish отправляет сообщение в канал и возвращает, скольким подписчикам оно доставлено
+func (b *Broker) Publish(channel string, payload string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	received := 0
+	for s := range b.channels[channel] {
+		if s.deliver(Message{Channel: channel, Payload: payload}) {
+			received++
+		}
+	}
+	for name, p := range b.patterns {
+		if !p.match(channel) {
+			continue
+		}
+		for s := range p.subs {
+			if s.deliver(Message{Pattern: name, Channel: channel, Payload: payload}) {
+				received++
+			}
+		}
+	}
+	return received
+}
+
+// Subscriber - подписки одного соединения
+type Subscriber struct {
+	b *Broker
+
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+	err      error
+
+	// channels и patterns защищены b.mu
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// Messages - сообщения для отправки клиенту
+func (s *Subscriber) Messages() <-chan Message {
+	return s.messages
+}
+
+// Done закрывается, когда подписчик закрыт или отключен из-за переполнения буфера
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err возвращает причину закрытия подписчика
+func (s *Subscriber) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Subscribe подписывает на канал и возвращает общее количество подписок
+func (s *Subscriber) Subscribe(channel string) int {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	subs, ok := s.b.channels[channel]
+	if !ok {
+		subs = make(map[*Subscriber]struct{})
+		s.b.channels[channel] = subs
+	}
+	subs[s] = struct{}{}
+	s.channels[channel] = struct{}{}
+	return s.count()
+}
+
+// PSubscribe подписывает на каналы, подходящие под glob шаблон
+func (s *Subscriber) PSubscribe(name string) int {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	p, ok := s.b.patterns[name]
+	if !ok {
+		p = &pattern{
+			match: glob.New(name),
+			subs:  make(map[*Subscriber]struct{}),
+		}
+		s.b.patterns[name] = p
+	}
+	p.subs[s] = struct{}{}
+	s.patterns[name] = struct{}{}
+	return s.count()
+}
+
+// Unsubscribe отписывает от канала и возвращает оставшееся количество подписок
+func (s *Subscriber) Unsubscribe(channel string) int {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	s.unsubscribe(channel)
+	return s.count()
+}
+
+// PUnsubscribe отписывает от шаблона
+func (s *Subscriber) PUnsubscribe(name string) int {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+
+	s.punsubscribe(name)
+	return s.count()
+}
+
+// Count возвращает количество подписок на каналы и шаблоны
+func (s *Subscriber) Count() int {
+	s.b.mu.RLock()
+	defer s.b.mu.RUnlock()
+
+	return s.count()
+}
+
+// Channels возвращает каналы подписчика по порядку
+func (s *Subscriber) Channels() []string {
+	s.b.mu.RLock()
+	defer s.b.mu.RUnlock()
+
+	return sortedKeys(s.channels)
+}
+
+// Patterns возвращает шаблоны подписчика по порядку
+func (s *Subscriber) Patterns() []string {
+	s.b.mu.RLock()
+	defer s.b.mu.RUnlock()
+
+	return sortedKeys(s.patterns)
+}
+
+// Close снимает все подписки, неотправленные сообщения теряются
+func (s *Subscriber) Close() {
+	s.b.mu.Lock()
+	for channel := range s.channels {
+		s.unsubscribe(channel)
+	}
+	for name := range s.patterns {
+		s.punsubscribe(name)
+	}
+	s.b.mu.Unlock()
+
+	s.close(ErrClosed)
+}
+
+func (s *Subscriber) unsubscribe(channel string) {
+	delete(s.channels, channel)
+	subs := s.b.channels[channel]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(s.b.channels, channel)
+	}
+}
+
+func (s *Subscriber) punsubscribe(name string) {
+	delete(s.patterns, name)
+	p, ok := s.b.patterns[name]
+	if !ok {
+		return
+	}
+	delete(p.subs, s)
+	if len(p.subs) == 0 {
+		delete(s.b.patterns, name)
+	}
+}
+
+func (s *Subscriber) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// deliver не блокируется: если буфер полон, сообщение пропускается
+// или подписчик отключается в зависимости от политики брокера
+func (s *Subscriber) deliver(m Message) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	select {
+	case s.messages <- m:
+		return true
+	default:
+	}
+
+	if s.b.overflow == config.OverflowDrop {
+		slog.Debug("drop message for slow subscriber", slog.String("channel", m.Channel))
+		return false
+	}
+	slog.Info("disconnect slow subscriber", slog.String("channel", m.Channel))
+	s.close(ErrSlowSubscriber)
+	return false
+}
+
+func (s *Subscriber) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package pubsub
+
+import (
+	"testing"
+
+	"inmem-db/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	b, err := New(config.PubSub{})
+	require.NoError(t, err)
+
+	channel := b.NewSubscriber()
+	defer channel.Close()
+	pattern := b.NewSubscriber()
+	defer pattern.Close()
+
+	assert.Equal(t, 1, channel.Subscribe("news"))
+	assert.Equal(t, 1, pattern.PSubscribe("n*"))
+
+	assert.Equal(t, 2, b.Publish("news", "hello"))
+	assert.Equal(t, 0, b.Publish("sport", "goal"))
+	assert.Equal(t, 1, b.Publish("nba", "score"))
+
+	assert.Equal(t, Message{Channel: "news", Payload: "hello"}, <-channel.Messages())
+	assert.Equal(t, Message{Pattern: "n*", Channel: "news", Payload: "hello"}, <-pattern.Messages())
+	assert.Equal(t, Message{Pattern: "n*", Channel: "nba", Payload: "score"}, <-pattern.Messages())
+
+	assert.Equal(t, 0, channel.Unsubscribe("news"))
+	assert.Equal(t, 1, b.Publish("news", "again"))
+}
+
+func TestPublish_overflow(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		policy config.OverflowPolicy
+
+		received int
+		err      error
+	}
+
+	tests := map[string]test{
+		"drop": {
+			policy:   config.OverflowDrop,
+			received: 2,
+		},
+		"disconnect": {
+			policy:   config.OverflowDisconnect,
+			received: 2,
+			err:      ErrSlowSubscriber,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := New(config.PubSub{
+				BufferSize: 2,
+				Overflow:   tc.policy,
+			})
+			require.NoError(t, err)
+
+			s := b.NewSubscriber()
+			defer s.Close()
+			s.Subscribe("news")
+
+			received := 0
+			for range 5 {
+				received += b.Publish("news", "message")
+			}
+			assert.Equal(t, tc.received, received)
+			assert.Len(t, s.Messages(), 2)
+
+			if tc.err == nil {
+				select {
+				case <-s.Done():
+					t.Fatal("subscriber is closed")
+				default:
+				}
+				return
+			}
+			assert.ErrorIs(t, s.Err(), tc.err)
+		})
+	}
+}
+
+func TestNew_invalidConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(config.PubSub{Overflow: "block"})
+	assert.Error(t, err)
+
+	_, err = New(config.PubSub{BufferSize: -1})
+	assert.Error(t, err)
+}
@@ -3,55 +3,119 @@ package cli
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"inmem-db/internal/domain/command"
+	"inmem-db/internal/pubsub"
 )
 
 const prompt = "-> "
 
+var (
+	ErrNestedMulti = errors.New("MULTI calls can not be nested")
+	ErrNoMulti     = errors.New("command without MULTI")
+	ErrWatchInTx   = errors.New("WATCH inside MULTI is not allowed")
+	ErrExecAbort   = errors.New("transaction discarded because of previous errors")
+
+	ErrSnapshotInTx  = errors.New("SNAPSHOT inside MULTI is not allowed")
+	ErrReplicaOfInTx = errors.New("REPLICAOF inside MULTI is not allowed")
+
+	ErrPubSubDisabled = errors.New("pub/sub is disabled")
+	ErrPubSubInTx     = errors.New("pub/sub commands are not allowed inside MULTI")
+	ErrSubscribed     = errors.New("only (P)SUBSCRIBE and (P)UNSUBSCRIBE are allowed in subscribed mode")
+)
+
+const (
+	okReply     = "OK"
+	queuedReply = "QUEUED"
+)
+
 type Parser interface {
 	Parse(ctx context.Context, line string) (command.Command, error)
 }
 type Storage interface {
 	Do(ctx context.Context, cmd command.Command) (string, error)
+	Watch(ctx context.Context, keys []string) (map[string]command.WatchedKey, error)
+	DoTx(ctx context.Context, tx command.Tx) ([]command.Result, error)
+	Snapshot(ctx context.Context) error
+	// ReplicaOf делает узел репликой мастера addr, пустой addr - мастером
+	ReplicaOf(ctx context.Context, addr string) error
+}
+type Broker interface {
+	NewSubscriber() *pubsub.Subscriber
+	Publish(channel string, payload string) int
 }
 
 type Cli struct {
 	s *bufio.Scanner
 	w io.Writer
+	// mu упорядочивает ответы на команды и сообщения подписки
+	mu sync.Mutex
 
 	p       Parser
 	storage Storage
+	broker  Broker
+
+	// состояние транзакции соединения
+	inMulti bool
+	failed  bool
+	queued  []command.Command
+	watched map[string]command.WatchedKey
+
+	// состояние подписки соединения
+	sub    *pubsub.Subscriber
+	pushed chan struct{}
+	// dropped - соединение закрыто из-за медленного чтения сообщений
+	dropped atomic.Bool
+	closer  io.Closer
 }
 
 type Factory func(r io.Reader, w io.Writer) *Cli
 
-func NewFactory(p Parser, storage Storage) Factory {
+func NewFactory(p Parser, storage Storage, options ...Option) Factory {
 	return func(r io.Reader, w io.Writer) *Cli {
-		return New(r, w, p, storage)
+		return New(r, w, p, storage, options...)
 	}
 }
 
-func New(r io.Reader, w io.Writer, p Parser, storage Storage) *Cli {
+func New(r io.Reader, w io.Writer, p Parser, storage Storage, options ...Option) *Cli {
 	s := bufio.NewScanner(r)
 	s.Split(bufio.ScanLines)
 
-	return &Cli{
+	c := Cli{
 		s:       s,
 		w:       w,
 		p:       p,
 		storage: storage,
 	}
+	if closer, ok := r.(io.Closer); ok {
+		c.closer = closer
+	}
+
+	for _, o := range options {
+		o(&c)
+	}
+
+	return &c
 }
 
 func (c *Cli) Start(ctx context.Context) error {
+	defer c.unsubscribeAll()
+
 	for {
-		fmt.Fprint(c.w, prompt)
+		c.mu.Lock()
+		if c.sub == nil {
+			fmt.Fprint(c.w, prompt)
+		}
+		c.mu.Unlock()
 
-		if !c.s.Scan() {
+		if !c.s.Scan() || c.dropped.Load() {
 			break
 		}
 
@@ -59,21 +123,165 @@ func (c *Cli) Start(ctx context.Context) error {
 		slog.DebugContext(ctx, "read line", slog.String("line", line))
 
 		cmd, err := c.p.Parse(ctx, line)
+
+		c.mu.Lock()
 		if err != nil {
+			// ошибка в очереди MULTI отменяет всю транзакцию
+			c.failed = c.inMulti
 			printErr(c.w, err)
+			c.mu.Unlock()
 			continue
 		}
 
-		out, err := c.storage.Do(ctx, cmd)
+		out, err := c.do(ctx, cmd)
 		if err != nil {
 			printErr(c.w, err)
+		} else {
+			fmt.Fprint(c.w, out, "\n")
+		}
+		c.mu.Unlock()
+	}
+
+	if c.dropped.Load() {
+		return nil
+	}
+	err := c.s.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		c.mu.Lock()
+		printErr(c.w, err)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// do выполняет команду или ставит ее в очередь транзакции
+func (c *Cli) do(ctx context.Context, cmd command.Command) (string, error) {
+	if c.sub != nil && !isSubscription(cmd) {
+		return "", ErrSubscribed
+	}
+
+	switch cmd.Type {
+	case command.CommandMULTI:
+		if c.inMulti {
+			return "", ErrNestedMulti
+		}
+		c.inMulti = true
+		return okReply, nil
+
+	case command.CommandEXEC:
+		if !c.inMulti {
+			return "", ErrNoMulti
+		}
+		return c.exec(ctx)
+
+	case command.CommandDISCARD:
+		if !c.inMulti {
+			return "", ErrNoMulti
+		}
+		c.reset()
+		return okReply, nil
+
+	case command.CommandWATCH:
+		if c.inMulti {
+			return "", ErrWatchInTx
+		}
+		return c.watch(ctx, cmd.Keys)
+
+	case command.CommandUNWATCH:
+		if !c.inMulti {
+			c.watched = nil
+		}
+		return okReply, nil
+
+	case command.CommandSNAPSHOT:
+		if c.inMulti {
+			return "", ErrSnapshotInTx
+		}
+		err := c.storage.Snapshot(ctx)
+		if err != nil {
+			return "", err
+		}
+		return okReply, nil
+
+	case command.CommandREPLICAOF:
+		if c.inMulti {
+			return "", ErrReplicaOfInTx
+		}
+		err := c.storage.ReplicaOf(ctx, cmd.MasterAddress)
+		if err != nil {
+			return "", err
+		}
+		return okReply, nil
+
+	case command.CommandSUBSCRIBE, command.CommandPSUBSCRIBE,
+		command.CommandUNSUBSCRIBE, command.CommandPUNSUBSCRIBE,
+		command.CommandPUBLISH:
+		if c.inMulti {
+			return "", ErrPubSubInTx
+		}
+		if c.broker == nil {
+			return "", ErrPubSubDisabled
+		}
+		return c.pubsub(ctx, cmd), nil
+	}
+
+	if c.inMulti {
+		c.queued = append(c.queued, cmd)
+		return queuedReply, nil
+	}
+	return c.storage.Do(ctx, cmd)
+}
+
+func (c *Cli) exec(ctx context.Context) (string, error) {
+	tx := command.Tx{
+		Commands: c.queued,
+		Watched:  c.watched,
+	}
+	failed := c.failed
+	c.reset()
+	if failed {
+		return "", ErrExecAbort
+	}
+
+	results, err := c.storage.DoTx(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, len(results))
+	for i, r := range results {
+		if r.Err != nil {
+			lines[i] = fmt.Sprintf("Error: %s", r.Err)
 			continue
 		}
+		lines[i] = r.Out
+	}
+	return strings.Join(lines, "\n"), nil
+}
 
-		fmt.Fprint(c.w, out, "\n")
+// watch запоминает версии ключей, для уже наблюдаемого ключа остается первая версия
+func (c *Cli) watch(ctx context.Context, keys []string) (string, error) {
+	watched, err := c.storage.Watch(ctx, keys)
+	if err != nil {
+		return "", err
+	}
+	if c.watched == nil {
+		c.watched = make(map[string]command.WatchedKey, len(watched))
 	}
+	for key, w := range watched {
+		if _, ok := c.watched[key]; !ok {
+			c.watched[key] = w
+		}
+	}
+	return okReply, nil
+}
 
-	return c.s.Err()
+// reset завершает транзакцию, после EXEC и DISCARD ключи перестают наблюдаться
+func (c *Cli) reset() {
+	c.inMulti = false
+	c.failed = false
+	c.queued = nil
+	c.watched = nil
 }
 
 func printErr(w io.Writer, err error) {
@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"inmem-db/internal/compute/parser"
+	"inmem-db/internal/config"
+	"inmem-db/internal/pubsub"
+	"inmem-db/internal/storage/engine"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCli_maxMessageSize(t *testing.T) {
+	t.Parallel()
+
+	value := strings.Repeat("v", 100<<10)
+
+	type test struct {
+		size int
+		err  error
+		want string
+	}
+
+	tests := map[string]test{
+		"longer than default": {size: 1 << 20, want: value},
+		"too long":            {size: 1 << 10, err: bufio.ErrTooLong},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			in := strings.NewReader("SET name " + value + "\nGET name\n")
+			out := bytes.Buffer{}
+			c := New(in, &out, parser.Parser{}, engine.New(), WithMaxMessageSize(tc.size))
+
+			err := c.Start(ctx)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				assert.Contains(t, out.String(), "Error: "+tc.err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, out.String(), prompt+tc.want+"\n")
+		})
+	}
+}
+
+// hookReader выполняет fn, когда cli обработал все строки перед ним, и ничего не читает
+type hookReader func()
+
+func (h hookReader) Read([]byte) (int, error) {
+	h()
+	return 0, io.EOF
+}
+
+func TestCli_multi(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		input string
+		// other выполняется другим соединением перед строками after
+		other string
+		after string
+
+		want string
+	}
+
+	tests := map[string]test{
+		"queued commands": {
+			input: "MULTI\nSET name 1\nINCR name\nGET name\nEXEC\nGET name\n",
+			want:  "-> OK\n-> QUEUED\n-> QUEUED\n-> QUEUED\n-> \n2\n2\n-> 2\n-> ",
+		},
+		"discard": {
+			input: "MULTI\nSET name 1\nDISCARD\nGET name\n",
+			want:  "-> OK\n-> QUEUED\n-> OK\n-> \nError: " + engine.ErrNotFound.Error() + "\n-> ",
+		},
+		"error in queue aborts exec": {
+			input: "MULTI\nSET name 1\nUNKNOWN\nEXEC\nGET name\n",
+			want: "-> OK\n-> QUEUED\n-> \nError: " + parser.ErrUnknownCommand.Error() +
+				"\n-> \nError: " + ErrExecAbort.Error() +
+				"\n-> \nError: " + engine.ErrNotFound.Error() + "\n-> ",
+		},
+		"watched key changed": {
+			input: "WATCH name\nMULTI\nSET name tx\n",
+			other: "SET name other\n",
+			after: "EXEC\nGET name\n",
+			want:  "-> OK\n-> OK\n-> QUEUED\n-> \nError: " + engine.ErrTxAborted.Error() + "\n-> other\n-> ",
+		},
+		"watched key not changed": {
+			input: "WATCH name\nMULTI\nSET name tx\n",
+			other: "GET name\n",
+			after: "EXEC\nGET name\n",
+			want:  "-> OK\n-> OK\n-> QUEUED\n-> \n-> tx\n-> ",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			e := engine.New()
+			other := hookReader(func() {
+				if tc.other == "" {
+					return
+				}
+				c := New(strings.NewReader(tc.other), io.Discard, parser.Parser{}, e)
+				require.NoError(t, c.Start(ctx))
+			})
+			in := io.MultiReader(strings.NewReader(tc.input), other, strings.NewReader(tc.after))
+			out := bytes.Buffer{}
+			c := New(in, &out, parser.Parser{}, e)
+
+			require.NoError(t, c.Start(ctx))
+			assert.Equal(t, tc.want, out.String())
+		})
+	}
+}
+
+func TestCli_pubsub(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	b, err := pubsub.New(config.PubSub{})
+	require.NoError(t, err)
+	e := engine.New()
+
+	// publish отправляет PUBLISH отдельным соединением и возвращает его ответ
+	publish := func(channel, payload string) string {
+		out := bytes.Buffer{}
+		c := New(strings.NewReader("PUBLISH "+channel+" "+payload+"\n"), &out, parser.Parser{}, e, WithPubSub(b))
+		require.NoError(t, c.Start(ctx))
+		return out.String()
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := New(inR, outW, parser.Parser{}, e, WithPubSub(b))
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Start(ctx)
+		outW.Close()
+	}()
+
+	// ответы и сообщения читаются постоянно, иначе запись cli в pipe блокируется
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		out := bufio.NewScanner(outR)
+		for out.Scan() {
+			lines <- out.Text()
+		}
+	}()
+
+	send := func(line string) {
+		_, err := io.WriteString(inW, line+"\n")
+		require.NoError(t, err)
+	}
+	// readLine пропускает приглашение, которое в режиме подписки не пишется
+	readLine := func() string {
+		select {
+		case line := <-lines:
+			return strings.TrimPrefix(line, prompt)
+		case <-ctx.Done():
+			t.Fatal("no reply")
+			return ""
+		}
+	}
+
+	send("SUBSCRIBE news")
+	assert.Equal(t, "subscribe news 1", readLine())
+	send("PSUBSCRIBE evt:*")
+	assert.Equal(t, "psubscribe evt:* 2", readLine())
+
+	// сообщения приходят без запроса клиента
+	assert.Equal(t, "-> 1\n-> ", publish("news", "hello"))
+	assert.Equal(t, "message news hello", readLine())
+	assert.Equal(t, "-> 1\n-> ", publish("evt:1", "created"))
+	assert.Equal(t, "pmessage evt:* evt:1 created", readLine())
+
+	// в режиме подписки остальные команды запрещены
+	send("GET name")
+	assert.Empty(t, readLine())
+	assert.Equal(t, "Error: "+ErrSubscribed.Error(), readLine())
+
+	send("UNSUBSCRIBE")
+	assert.Equal(t, "unsubscribe news 1", readLine())
+	assert.Equal(t, "-> 0\n-> ", publish("news", "lost"))
+	send("PUNSUBSCRIBE evt:*")
+	assert.Equal(t, "punsubscribe evt:* 0", readLine())
+
+	// после отписки от всего соединение снова принимает команды
+	send("SET name value")
+	assert.Empty(t, readLine())
+	require.NoError(t, inW.Close())
+	assert.Equal(t, prompt, <-lines)
+	require.NoError(t, <-done)
+}
+
+func TestCli_slowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	b, err := pubsub.New(config.PubSub{BufferSize: 1})
+	require.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := New(server, server, parser.Parser{}, engine.New(), WithPubSub(b))
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Start(ctx)
+	}()
+
+	// net.Pipe не буферизует, приглашение читается до отправки команды
+	out := bufio.NewReader(client)
+	_, err = io.ReadFull(out, make([]byte, len(prompt)))
+	require.NoError(t, err)
+	_, err = io.WriteString(client, "SUBSCRIBE news\n")
+	require.NoError(t, err)
+	line, err := out.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "subscribe news 1\n", line)
+
+	// клиент больше не читает, первое сообщение повисает на записи, остальные переполняют буфер
+	for i := 0; ; i++ {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			_, err = out.ReadString('\n')
+			assert.ErrorIs(t, err, io.EOF)
+			return
+		case <-ctx.Done():
+			t.Fatal("slow subscriber is not disconnected")
+		default:
+		}
+		b.Publish("news", fmt.Sprintf("message %d", i))
+		time.Sleep(time.Millisecond)
+	}
+}
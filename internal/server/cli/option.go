@@ -0,0 +1,20 @@
+package cli
+
+import "bufio"
+
+type Option func(*Cli)
+
+// WithPubSub включает команды SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE, PUNSUBSCRIBE и PUBLISH
+func WithPubSub(b Broker) Option {
+	return func(c *Cli) {
+		c.broker = b
+	}
+}
+
+// WithMaxMessageSize ограничивает длину строки команды, по умолчанию действует
+// ограничение bufio.Scanner в 64KB. Строка должна вмещать ключ и значение целиком.
+func WithMaxMessageSize(size int) Option {
+	return func(c *Cli) {
+		c.s.Buffer(make([]byte, 0, min(size, bufio.MaxScanTokenSize)), size)
+	}
+}
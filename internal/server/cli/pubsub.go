@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/pubsub"
+)
+
+// idlePauser - соединение с таймаутом простоя, подписчик может долго ничего не отправлять
+type idlePauser interface {
+	PauseIdle(paused bool)
+}
+
+func isSubscription(cmd command.Command) bool {
+	switch cmd.Type {
+	case command.CommandSUBSCRIBE, command.CommandPSUBSCRIBE,
+		command.CommandUNSUBSCRIBE, command.CommandPUNSUBSCRIBE:
+		return true
+	}
+	return false
+}
+
+// pubsub выполняет команды подписки, вызывается под c.mu.
+// Ответ на каждый канал - строка "<команда> <канал> <количество подписок>".
+func (c *Cli) pubsub(ctx context.Context, cmd command.Command) string {
+	if cmd.Type == command.CommandPUBLISH {
+		return strconv.Itoa(c.broker.Publish(cmd.Name, cmd.Message))
+	}
+
+	reply := strings.ToLower(string(cmd.Type))
+	channels := cmd.Keys
+	if len(channels) == 0 && c.sub != nil {
+		// UNSUBSCRIBE и PUNSUBSCRIBE без аргументов отписывают от всего
+		if cmd.Type == command.CommandUNSUBSCRIBE {
+			channels = c.sub.Channels()
+		} else {
+			channels = c.sub.Patterns()
+		}
+	}
+	if len(channels) == 0 {
+		return fmt.Sprintf("%s %d", reply, c.count())
+	}
+
+	if c.sub == nil && !isUnsubscribe(cmd) {
+		c.subscribe(ctx)
+	}
+
+	lines := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		count := 0
+		if c.sub != nil {
+			switch cmd.Type {
+			case command.CommandSUBSCRIBE:
+				count = c.sub.Subscribe(channel)
+			case command.CommandPSUBSCRIBE:
+				count = c.sub.PSubscribe(channel)
+			case command.CommandUNSUBSCRIBE:
+				count = c.sub.Unsubscribe(channel)
+			case command.CommandPUNSUBSCRIBE:
+				count = c.sub.PUnsubscribe(channel)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %d", reply, channel, count))
+	}
+
+	if c.sub != nil && c.count() == 0 {
+		c.unsubscribe()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isUnsubscribe(cmd command.Command) bool {
+	return cmd.Type == command.CommandUNSUBSCRIBE || cmd.Type == command.CommandPUNSUBSCRIBE
+}
+
+func (c *Cli) count() int {
+	if c.sub == nil {
+		return 0
+	}
+	return c.sub.Count()
+}
+
+// subscribe переводит соединение в режим подписки: сообщения отправляются,
+// как только приходят, а клиент может только менять подписки
+func (c *Cli) subscribe(ctx context.Context) {
+	c.sub = c.broker.NewSubscriber()
+	c.pushed = make(chan struct{})
+	if p, ok := c.w.(idlePauser); ok {
+		p.PauseIdle(true)
+	}
+
+	go c.push(ctx, c.sub, c.pushed)
+	go c.watchSlow(ctx, c.sub)
+}
+
+// watchSlow отключает подписчика, переполнившего буфер. Push в это время может висеть
+// на записи под c.mu, поэтому ждать отключения должна отдельная горутина.
+func (c *Cli) watchSlow(ctx context.Context, sub *pubsub.Subscriber) {
+	select {
+	case <-ctx.Done():
+	case <-sub.Done():
+		if errors.Is(sub.Err(), pubsub.ErrSlowSubscriber) {
+			c.disconnect(ctx, sub.Err())
+		}
+	}
+}
+
+// unsubscribe выходит из режима подписки, вызывается под c.mu
+func (c *Cli) unsubscribe() {
+	c.sub.Close()
+	c.sub = nil
+	if p, ok := c.w.(idlePauser); ok {
+		p.PauseIdle(false)
+	}
+}
+
+// unsubscribeAll закрывает подписку при завершении соединения
+func (c *Cli) unsubscribeAll() {
+	c.mu.Lock()
+	pushed := c.pushed
+	if c.sub != nil {
+		c.unsubscribe()
+	}
+	c.mu.Unlock()
+
+	if pushed != nil {
+		<-pushed
+	}
+}
+
+func (c *Cli) push(ctx context.Context, sub *pubsub.Subscriber, pushed chan struct{}) {
+	defer close(pushed)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-sub.Done():
+			return
+
+		case m := <-sub.Messages():
+			c.mu.Lock()
+			// после UNSUBSCRIBE сообщения из буфера не отправляются
+			select {
+			case <-sub.Done():
+			default:
+				fmt.Fprint(c.w, formatMessage(m), "\n")
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// disconnect закрывает соединение медленного подписчика. Клиент не читает ответы,
+// поэтому ошибка пишется только в лог, а закрытие прерывает зависшую запись.
+func (c *Cli) disconnect(ctx context.Context, err error) {
+	c.dropped.Store(true)
+	slog.InfoContext(ctx, "drop subscriber connection", slog.String("reason", err.Error()))
+
+	if c.closer == nil {
+		c.mu.Lock()
+		printErr(c.w, err)
+		c.mu.Unlock()
+		return
+	}
+	if err := c.closer.Close(); err != nil {
+		slog.ErrorContext(ctx, "close slow subscriber", slog.String("error", err.Error()))
+	}
+}
+
+func formatMessage(m pubsub.Message) string {
+	if m.Pattern != "" {
+		return fmt.Sprintf("pmessage %s %s %s", m.Pattern, m.Channel, m.Payload)
+	}
+	return fmt.Sprintf("message %s %s", m.Channel, m.Payload)
+}
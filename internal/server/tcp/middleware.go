@@ -2,24 +2,40 @@ package tcp
 
 import (
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 type idleRW struct {
 	net.Conn
 	idle time.Duration
+
+	// paused отключает таймаут, например, пока соединение ждет сообщения подписки
+	paused atomic.Bool
 }
 
 func (i *idleRW) Read(p []byte) (n int, err error) {
-	i.SetDeadline(time.Now().Add(i.idle))
+	i.SetDeadline(i.deadline())
 	return i.Conn.Read(p)
 }
 
 func (i *idleRW) Write(p []byte) (n int, err error) {
-	i.SetDeadline(time.Now().Add(i.idle))
+	i.SetDeadline(i.deadline())
 	return i.Conn.Write(p)
 }
 
+// PauseIdle включает и выключает таймаут простоя соединения
+func (i *idleRW) PauseIdle(paused bool) {
+	i.paused.Store(paused)
+}
+
+func (i *idleRW) deadline() time.Time {
+	if i.paused.Load() {
+		return time.Time{}
+	}
+	return time.Now().Add(i.idle)
+}
+
 func withIdle(conn net.Conn, idle time.Duration) net.Conn {
 	return &idleRW{
 		Conn: conn,
@@ -18,6 +18,8 @@ type Server struct {
 	cfg config.Network
 
 	newHandler HandlerFactory
+	// l - адрес, занятый Listen до Start
+	l net.Listener
 }
 
 type HandlerFactory func(r io.Reader, w io.Writer) Starter
@@ -39,11 +41,28 @@ func NewServer(cfg config.Network, newHandler HandlerFactory) *Server {
 	}
 }
 
-func (s *Server) Start(ctx context.Context) error {
+// Listen занимает адрес сервера заранее, чтобы ошибка была видна до Start.
+// Без Listen адрес занимает Start.
+func (s *Server) Listen() error {
+	if s.l != nil {
+		return nil
+	}
 	l, err := net.Listen("tcp", s.cfg.Address)
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
+	s.l = l
+	return nil
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	err := s.Listen()
+	if err != nil {
+		return err
+	}
+	// после остановки сервер можно запустить снова, адрес займется заново
+	l := s.l
+	s.l = nil
 	defer l.Close()
 	slog.InfoContext(ctx, "start server", slog.String("addr", s.cfg.Address))
 
@@ -17,9 +17,9 @@ func encodeSegments(w io.Writer, segments []wal.Segment) error {
 	return nil
 }
 
-func decodeSegments(r io.Reader, segments []wal.Segment) error {
+func decodeSegments(r io.Reader, decode func(r io.Reader) (wal.Segment, error), segments []wal.Segment) error {
 	for i := range len(segments) {
-		segment, err := wal.DecodeSegment(r)
+		segment, err := decode(r)
 		if err != nil {
 			return fmt.Errorf("decode segment: %w", err)
 		}
@@ -3,52 +3,607 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	"inmem-db/internal/domain/command"
+	"inmem-db/internal/domain/event"
+	"inmem-db/pkg/glob"
 	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	ErrUnknownCmd = errors.New("unknown command")
 	ErrInvalidCmd = errors.New("invalid command")
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+
+	ErrNoPersistence = errors.New("snapshots require wal")
+	ErrNoReplication = errors.New("replication requires wal")
+
+	ErrKeyTooLarge     = errors.New("key is too large")
+	ErrValueTooLarge   = errors.New("value is too large")
+	ErrCommandTooLarge = errors.New("command is too large")
+)
+
+const (
+	// DefaultMaxKeySize и DefaultMaxValueSize - ограничения размера по умолчанию
+	DefaultMaxKeySize   = 64 << 10
+	DefaultMaxValueSize = 512 << 20
+
+	// MaxCommandSize - наибольший объем команды или транзакции вместе с кодированием
+	// каждого ключа, с ним изменения помещаются в сегмент журнала (1GB)
+	MaxCommandSize = 768 << 20
+	// keyOverhead - сколько байт журнала занимает команда на ключ помимо ключа и значения
+	keyOverhead = 32
+)
+
+const (
+	// SweepInterval - период активного удаления истекших ключей
+	SweepInterval = 100 * time.Millisecond
+	// sweepSample - сколько ключей со временем жизни проверяется за один проход
+	sweepSample = 20
+)
+
+// nilValue - отсутствующий ключ в ответе MGET
+const nilValue = "(nil)"
+
+// ответы TTL в стиле redis
+const (
+	ttlNotFound = -2
+	ttlNoExpire = -1
 )
 
+type Notifier interface {
+	Notify(e event.Event)
+}
+
 type Output struct {
 	Msg   string
 	Error error
 }
 
 type Engine struct {
-	s *storage
+	s      *storage
+	limits sizeLimits
 }
 
-func New() *Engine {
-	return &Engine{
-		s: newStorage(),
+// sizeLimits - наибольшие размеры ключа, значения и всей команды в новых командах
+type sizeLimits struct {
+	key     int
+	value   int
+	command int
+}
+
+func New(options ...Option) *Engine {
+	e := Engine{
+		s:      newStorage(newHashKeyspace()),
+		limits: sizeLimits{key: DefaultMaxKeySize, value: DefaultMaxValueSize, command: MaxCommandSize},
+	}
+
+	for _, o := range options {
+		o(&e)
 	}
+
+	return &e
 }
 
+// Do выполняет команду без журналирования изменений
 func (e *Engine) Do(ctx context.Context, cmd command.Command) (string, error) {
+	out, _, err := e.Exec(ctx, cmd)
+	return out, err
+}
+
+// Exec выполняет команду и возвращает изменения для записи в wal.
+// Изменения содержат только то, что действительно произошло: удаление истекших ключей,
+// нормализованные команды и т.д., их повторное применение через Replay дает тот же результат.
+func (e *Engine) Exec(ctx context.Context, cmd command.Command) (string, []command.Command, error) {
 	slog.DebugContext(ctx, "do command", slog.String("cmd", string(cmd.Type)))
 
-	name := cmd.Name
-	if len(name) == 0 {
-		return "", ErrInvalidCmd
+	err := e.validate(cmd)
+	if err != nil {
+		return "", nil, err
+	}
+	cmd = cmd.WithDeadline(e.s.now())
+
+	if cmd.IsReadOnly() {
+		// блокировка на чтение держится одну команду, для SCAN - одну порцию ключей
+		e.s.mu.RLock()
+		defer e.s.mu.RUnlock()
+
+		out, err := e.read(ctx, cmd)
+		return out, nil, err
 	}
+
+	var out string
+	changes, err := e.s.update(false, false, func(t *tx) error {
+		var err error
+		out, err = write(t, cmd)
+		return err
+	})
+	return out, changes, err
+}
+
+// write освобождает память, если команда может увеличить объем данных, и применяет ее
+func write(t *tx, cmd command.Command) (string, error) {
+	if grows(cmd) {
+		err := t.freeMemory()
+		if err != nil {
+			return "", err
+		}
+	}
+	return apply(t, cmd)
+}
+
+// read выполняет команду чтения, вызывается под блокировкой
+func (e *Engine) read(ctx context.Context, cmd command.Command) (string, error) {
 	switch cmd.Type {
 	case command.CommandGET:
-		return e.s.Get(ctx, name)
+		return e.s.Get(ctx, cmd.Name)
+
+	case command.CommandMGET:
+		return e.mget(ctx, cmd.Keys), nil
+
+	case command.CommandSCAN:
+		return e.scan(ctx, cmd.Scan)
+
+	case command.CommandKEYS:
+		keys := e.s.Keys(ctx, glob.New(cmd.Scan.Match))
+		return strings.Join(keys, "\n"), nil
+
+	case command.CommandRANGE, command.CommandREVRANGE:
+		return e.rangeKeys(ctx, cmd)
+
+	case command.CommandDBSIZE:
+		return strconv.Itoa(e.s.Len(ctx)), nil
+
+	case command.CommandTTL:
+		return e.ttl(ctx, cmd.Name)
+	}
+	return "", ErrUnknownCmd
+}
+
+// Replay применяет команду от мастера
+func (e *Engine) Replay(ctx context.Context, cmd command.Command) error {
+	return e.replay(ctx, cmd, false)
+}
+
+// Recover применяет команду из wal при запуске, без событий об изменениях
+func (e *Engine) Recover(ctx context.Context, cmd command.Command) error {
+	return e.replay(ctx, cmd, true)
+}
+
+func (e *Engine) replay(ctx context.Context, cmd command.Command, silent bool) error {
+	slog.DebugContext(ctx, "replay command", slog.String("cmd", string(cmd.Type)))
+
+	err := validate(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.IsReadOnly() {
+		return nil
+	}
+
+	_, err = e.s.update(true, silent, func(t *tx) error {
+		_, err := apply(t, cmd)
+		return err
+	})
+	return err
+}
 
+func apply(t *tx, cmd command.Command) (string, error) {
+	name := cmd.Name
+
+	switch cmd.Type {
 	case command.CommandSET:
-		value := cmd.Set.Value
-		if len(value) == 0 {
+		if len(cmd.Set.Value) == 0 {
 			return "", ErrInvalidCmd
 		}
+		return set(t, cmd)
 
-		return "", e.s.Set(ctx, name, value)
+	case command.CommandCAS:
+		if len(cmd.Set.Value) == 0 {
+			return "", ErrInvalidCmd
+		}
+		return cas(t, cmd)
 
 	case command.CommandDEL:
-		return "", e.s.Del(ctx, name)
+		t.del(name)
+		t.record(cmd)
+		return "", nil
+
+	case command.CommandEXPIRE:
+		if cmd.Expire.Deadline == 0 {
+			return "", ErrInvalidCmd
+		}
+		if _, ok := t.lookup(name); !ok {
+			return formatBool(false), nil
+		}
+		t.expire(name, cmd.Expire.Deadline)
+		t.record(cmd)
+		return formatBool(true), nil
+
+	case command.CommandPERSIST:
+		if _, ok := t.lookup(name); !ok {
+			return formatBool(false), nil
+		}
+		if !t.persist(name) {
+			return formatBool(false), nil
+		}
+		t.record(cmd)
+		return formatBool(true), nil
+
+	case command.CommandINCR, command.CommandDECR, command.CommandINCRBY, command.CommandDECRBY:
+		return incr(t, cmd)
+
+	case command.CommandMSET:
+		for i, key := range cmd.Keys {
+			t.set(key, cmd.Values[i], 0)
+		}
+		t.record(cmd)
+		return "", nil
+
+	case command.CommandMDEL:
+		return mdel(t, cmd)
 
 	}
 	return "", ErrUnknownCmd
 }
+
+// set записывает значение, для SET NX и SET XX сообщает, произошла ли запись.
+// Условие проверяется здесь, в журнал попадает безусловный SET.
+func set(t *tx, cmd command.Command) (string, error) {
+	name := cmd.Name
+	condition := cmd.Set.Condition
+
+	if condition != command.SetAlways {
+		_, ok := t.lookup(name)
+		if ok != (condition == command.SetIfExists) {
+			return formatBool(false), nil
+		}
+	}
+
+	t.set(name, cmd.Set.Value, cmd.Expire.Deadline)
+	cmd.Set.Condition = command.SetAlways
+	t.record(cmd)
+
+	if condition != command.SetAlways {
+		return formatBool(true), nil
+	}
+	return "", nil
+}
+
+// cas записывает новое значение, только если текущее совпадает с ожидаемым.
+// Время жизни ключа сохраняется.
+func cas(t *tx, cmd command.Command) (string, error) {
+	name := cmd.Name
+
+	v, ok := t.lookup(name)
+	if !ok || v != cmd.Set.Expected {
+		return formatBool(false), nil
+	}
+
+	deadline := t.deadline(name)
+	t.set(name, cmd.Set.Value, deadline)
+	t.record(command.Command{
+		Type:   command.CommandSET,
+		Name:   name,
+		Set:    command.SetArgs{Value: cmd.Set.Value},
+		Expire: command.ExpireArgs{Deadline: deadline},
+	})
+	return formatBool(true), nil
+}
+
+// mdel удаляет ключи и возвращает количество удаленных,
+// в журнал попадают только существовавшие ключи
+func mdel(t *tx, cmd command.Command) (string, error) {
+	deleted := make([]string, 0, len(cmd.Keys))
+	for _, key := range cmd.Keys {
+		if _, ok := t.lookup(key); !ok {
+			continue
+		}
+		t.del(key)
+		deleted = append(deleted, key)
+	}
+
+	if len(deleted) > 0 {
+		t.record(command.Command{
+			Type: command.CommandMDEL,
+			Keys: deleted,
+		})
+	}
+	return strconv.Itoa(len(deleted)), nil
+}
+
+// incr изменяет число под блокировкой хранилища, время жизни ключа сохраняется.
+// В журнал все варианты попадают как INCRBY.
+func incr(t *tx, cmd command.Command) (string, error) {
+	name := cmd.Name
+	delta := cmd.Incr.Delta
+
+	current := int64(0)
+	v, ok := t.lookup(name)
+	if ok {
+		var err error
+		current, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", ErrNotInteger
+		}
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) ||
+		(delta < 0 && current < math.MinInt64-delta) {
+		return "", ErrOverflow
+	}
+	current += delta
+
+	out := strconv.FormatInt(current, 10)
+	t.set(name, out, t.deadline(name))
+	t.record(command.Command{
+		Type: command.CommandINCRBY,
+		Name: name,
+		Incr: command.IncrArgs{Delta: delta},
+	})
+	return out, nil
+}
+
+// Start запускает активное удаление истекших ключей без журналирования,
+// используется, когда движок работает без wal
+func (e *Engine) Start(ctx context.Context) error {
+	t := time.NewTicker(SweepInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			e.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep удаляет истекшие ключи и возвращает их как команды DEL.
+// Выборка повторяется, пока среди проверенных ключей больше четверти истекших.
+func (e *Engine) Sweep(ctx context.Context) []command.Command {
+	var changes []command.Command
+	for {
+		checked, deleted := e.s.deleteExpired(sweepSample)
+		changes = append(changes, deleted...)
+		if checked == 0 || len(deleted)*4 <= checked {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(changes) > 0 {
+		slog.DebugContext(ctx, "expired keys deleted", slog.Int("cnt", len(changes)))
+	}
+	return changes
+}
+
+// Dump возвращает состояние как команды SET, Replay которых восстанавливает его
+func (e *Engine) Dump(ctx context.Context) []command.Command {
+	e.s.mu.RLock()
+	defer e.s.mu.RUnlock()
+
+	return e.s.Dump(ctx)
+}
+
+// Load заменяет все данные состоянием из cmds, полученным от Dump. Замена атомарна:
+// команды видят либо прежние данные, либо новые целиком.
+func (e *Engine) Load(ctx context.Context, cmds []command.Command) error {
+	keep := make(map[string]struct{}, len(cmds))
+	for _, cmd := range cmds {
+		err := validate(cmd)
+		if err != nil {
+			return err
+		}
+		keep[cmd.Name] = struct{}{}
+	}
+
+	_, err := e.s.update(true, true, func(t *tx) error {
+		stale := []string{}
+		t.s.data.ForEach(func(key string, _ string) bool {
+			if _, ok := keep[key]; !ok {
+				stale = append(stale, key)
+			}
+			return true
+		})
+		for _, key := range stale {
+			t.del(key)
+		}
+
+		for _, cmd := range cmds {
+			_, err := apply(t, cmd)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "engine loaded", slog.Int("keys", len(cmds)))
+	return nil
+}
+
+// Checksum возвращает контрольную сумму ключей, значений и времени истечения, не зависящую
+// от порядка ключей. Истекшие ключи не учитываются, как и в Dump: полная синхронизация
+// реплики передает состояние без них.
+func (e *Engine) Checksum(ctx context.Context) uint64 {
+	e.s.mu.RLock()
+	defer e.s.mu.RUnlock()
+
+	return e.s.Checksum(ctx)
+}
+
+// Snapshot без wal сохранить некуда
+func (e *Engine) Snapshot(ctx context.Context) error {
+	return ErrNoPersistence
+}
+
+// ReplicaOf без wal реплике нечего получать от мастера
+func (e *Engine) ReplicaOf(ctx context.Context, addr string) error {
+	return ErrNoReplication
+}
+
+// mget читает все ключи под одной блокировкой, по одному значению на строку
+func (e *Engine) mget(ctx context.Context, keys []string) string {
+	values, found := e.s.MGet(ctx, keys)
+	for i := range values {
+		if !found[i] {
+			values[i] = nilValue
+		}
+	}
+	return strings.Join(values, "\n")
+}
+
+// scan возвращает курсор продолжения первой строкой и найденные ключи следующими,
+// курсор "0" означает конец обхода
+func (e *Engine) scan(ctx context.Context, args command.ScanArgs) (string, error) {
+	if args.Count <= 0 {
+		return "", ErrInvalidCmd
+	}
+	keys, next, err := e.s.Scan(ctx, args.Cursor, args.Count, glob.New(args.Match))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{next}, keys...), "\n"), nil
+}
+
+// rangeKeys возвращает по паре "ключ значение" на строку
+func (e *Engine) rangeKeys(ctx context.Context, cmd command.Command) (string, error) {
+	args := cmd.Range
+	reverse := cmd.Type == command.CommandREVRANGE
+
+	pairs, err := e.s.Range(ctx, args.Start, args.End, reverse, args.Limit)
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, len(pairs))
+	for i, pair := range pairs {
+		lines[i] = pair[0] + " " + pair[1]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (e *Engine) ttl(ctx context.Context, name string) (string, error) {
+	ttl, err := e.s.TTL(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return strconv.Itoa(ttlNotFound), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if ttl < 0 {
+		return strconv.Itoa(ttlNoExpire), nil
+	}
+	seconds := (ttl + time.Second/2) / time.Second
+	return strconv.FormatInt(int64(seconds), 10), nil
+}
+
+// grows сообщает, что команда может увеличить объем данных
+func grows(cmd command.Command) bool {
+	switch cmd.Type {
+	case command.CommandSET, command.CommandCAS, command.CommandMSET,
+		command.CommandINCR, command.CommandDECR, command.CommandINCRBY, command.CommandDECRBY:
+		return true
+	}
+	return false
+}
+
+func validate(cmd command.Command) error {
+	if cmd.IsKeyless() {
+		return nil
+	}
+	if !cmd.IsMultiKey() {
+		if len(cmd.Name) == 0 {
+			return ErrInvalidCmd
+		}
+		return nil
+	}
+
+	if len(cmd.Keys) == 0 {
+		return ErrInvalidCmd
+	}
+	for _, key := range cmd.Keys {
+		if len(key) == 0 {
+			return ErrInvalidCmd
+		}
+	}
+
+	if cmd.Type != command.CommandMSET {
+		return nil
+	}
+	if len(cmd.Values) != len(cmd.Keys) {
+		return ErrInvalidCmd
+	}
+	for _, value := range cmd.Values {
+		if len(value) == 0 {
+			return ErrInvalidCmd
+		}
+	}
+	return nil
+}
+
+// validate проверяет новую команду. Размеры не проверяются в Replay: записанное
+// в журнал до изменения ограничений должно восстанавливаться.
+func (e *Engine) validate(cmd command.Command) error {
+	err := validate(cmd)
+	if err != nil {
+		return err
+	}
+	return e.limits.check(cmd)
+}
+
+func (l sizeLimits) check(cmd command.Command) error {
+	if size := commandSize(cmd); size > l.command {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrCommandTooLarge, size, l.command)
+	}
+
+	keys := cmd.Keys
+	if !cmd.IsMultiKey() {
+		keys = []string{cmd.Name}
+	}
+	for _, key := range keys {
+		if len(key) > l.key {
+			return fmt.Errorf("%w: %d bytes, max %d", ErrKeyTooLarge, len(key), l.key)
+		}
+	}
+
+	values := cmd.Values
+	if cmd.Type == command.CommandSET || cmd.Type == command.CommandCAS {
+		values = []string{cmd.Set.Value}
+	}
+	for _, value := range values {
+		if len(value) > l.value {
+			return fmt.Errorf("%w: %d bytes, max %d", ErrValueTooLarge, len(value), l.value)
+		}
+	}
+	return nil
+}
+
+// commandSize оценивает объем изменений команды в журнале сверху
+func commandSize(cmd command.Command) int {
+	size := len(cmd.Name) + len(cmd.Set.Value) + keyOverhead
+	for _, key := range cmd.Keys {
+		size += len(key) + keyOverhead
+	}
+	for _, value := range cmd.Values {
+		size += len(value)
+	}
+	return size
+}
+
+func formatBool(ok bool) string {
+	if ok {
+		return "1"
+	}
+	return "0"
+}
@@ -2,7 +2,11 @@ package engine
 
 import (
 	"context"
+	"fmt"
+	"inmem-db/internal/config"
 	"inmem-db/internal/domain/command"
+	"inmem-db/internal/domain/event"
+	"strings"
 	"testing"
 	"time"
 
@@ -80,6 +84,63 @@ func TestDo_checkErr(t *testing.T) {
 	}
 }
 
+func TestDo_sizeLimits(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		cmd command.Command
+		err error
+	}
+
+	set := func(name, value string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: value}}
+	}
+
+	tests := map[string]test{
+		"fits":       {cmd: set("name", "12345678")},
+		"long key":   {cmd: set("names", "v"), err: ErrKeyTooLarge},
+		"long value": {cmd: set("name", "123456789"), err: ErrValueTooLarge},
+		"long get key": {
+			cmd: command.Command{Type: command.CommandGET, Name: "names"},
+			err: ErrKeyTooLarge,
+		},
+		"long mset value": {
+			cmd: command.Command{Type: command.CommandMSET, Keys: []string{"a", "b"}, Values: []string{"1", "123456789"}},
+			err: ErrValueTooLarge,
+		},
+		"long cas value": {
+			cmd: command.Command{Type: command.CommandCAS, Name: "a", Set: command.SetArgs{Expected: "1", Value: "123456789"}},
+			err: ErrValueTooLarge,
+		},
+		"large mset": {
+			cmd: command.Command{Type: command.CommandMSET, Keys: []string{"a", "b", "c"}, Values: []string{"1", "2", "3"}},
+			err: ErrCommandTooLarge,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			e := New(WithSizeLimits(4, 8))
+			e.limits.command = 4 * keyOverhead
+			_, err := e.Do(ctx, tc.cmd)
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+
+			// записанное до уменьшения ограничений восстанавливается
+			if !tc.cmd.IsReadOnly() {
+				require.NoError(t, e.Replay(ctx, tc.cmd))
+			}
+		})
+	}
+}
+
 func TestDo_getAfterSet(t *testing.T) {
 	t.Parallel()
 
@@ -192,3 +253,728 @@ func TestDo_getAfterDel(t *testing.T) {
 	_, err = s.Do(ctx, cmd)
 	assert.ErrorIs(t, ErrNotFound, err)
 }
+
+func TestDo_expire(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s := New()
+
+	past := time.Now().Add(-time.Second).UnixMilli()
+
+	// ключ с истекшим временем жизни не виден
+	_, err := s.Do(ctx, command.Command{
+		Type:   command.CommandSET,
+		Name:   "expired",
+		Set:    command.SetArgs{Value: "value"},
+		Expire: command.ExpireArgs{Deadline: past},
+	})
+	require.NoError(t, err)
+	_, err = s.Do(ctx, command.Command{Type: command.CommandGET, Name: "expired"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	ttl, err := s.Do(ctx, command.Command{Type: command.CommandTTL, Name: "expired"})
+	require.NoError(t, err)
+	assert.Equal(t, "-2", ttl)
+
+	_, err = s.Do(ctx, command.Command{
+		Type: command.CommandSET,
+		Name: "name",
+		Set:  command.SetArgs{Value: "value"},
+	})
+	require.NoError(t, err)
+
+	ttl, err = s.Do(ctx, command.Command{Type: command.CommandTTL, Name: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, "-1", ttl)
+
+	ok, err := s.Do(ctx, command.Command{
+		Type:   command.CommandEXPIRE,
+		Name:   "name",
+		Expire: command.ExpireArgs{TTL: 10 * time.Second},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1", ok)
+
+	ttl, err = s.Do(ctx, command.Command{Type: command.CommandTTL, Name: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, "10", ttl)
+
+	ok, err = s.Do(ctx, command.Command{Type: command.CommandPERSIST, Name: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", ok)
+
+	ttl, err = s.Do(ctx, command.Command{Type: command.CommandTTL, Name: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, "-1", ttl)
+
+	ok, err = s.Do(ctx, command.Command{
+		Type:   command.CommandEXPIRE,
+		Name:   "unknown",
+		Expire: command.ExpireArgs{TTL: 10 * time.Second},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0", ok)
+}
+
+func TestSweep(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := New()
+
+	past := time.Now().Add(-time.Second).UnixMilli()
+	const keys = 100
+	for i := range keys {
+		_, err := e.Do(ctx, command.Command{
+			Type:   command.CommandSET,
+			Name:   fmt.Sprintf("name%d", i),
+			Set:    command.SetArgs{Value: "value"},
+			Expire: command.ExpireArgs{Deadline: past},
+		})
+		require.NoError(t, err)
+	}
+
+	deleted := e.Sweep(ctx)
+	assert.Len(t, deleted, keys)
+
+	e.s.mu.RLock()
+	defer e.s.mu.RUnlock()
+	assert.Zero(t, e.s.data.Len())
+	assert.Empty(t, e.s.expires)
+}
+
+func TestDo_incr(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		value string
+		cmd   command.Command
+
+		want string
+		err  error
+	}
+
+	tests := map[string]test{
+		"incr missing key": {
+			cmd:  command.Command{Type: command.CommandINCR, Name: "name", Incr: command.IncrArgs{Delta: 1}},
+			want: "1",
+		},
+		"decrby existing key": {
+			value: "10",
+			cmd:   command.Command{Type: command.CommandDECRBY, Name: "name", Incr: command.IncrArgs{Delta: -15}},
+			want:  "-5",
+		},
+		"incr not integer": {
+			value: "ten",
+			cmd:   command.Command{Type: command.CommandINCR, Name: "name", Incr: command.IncrArgs{Delta: 1}},
+			err:   ErrNotInteger,
+		},
+		"incr overflow": {
+			value: "9223372036854775807",
+			cmd:   command.Command{Type: command.CommandINCR, Name: "name", Incr: command.IncrArgs{Delta: 1}},
+			err:   ErrOverflow,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			e := New()
+			if tc.value != "" {
+				_, err := e.Do(ctx, command.Command{
+					Type: command.CommandSET,
+					Name: tc.cmd.Name,
+					Set:  command.SetArgs{Value: tc.value},
+				})
+				require.NoError(t, err)
+			}
+
+			got, changes, err := e.Exec(ctx, tc.cmd)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Empty(t, changes)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, []command.Command{{
+				Type: command.CommandINCRBY,
+				Name: tc.cmd.Name,
+				Incr: tc.cmd.Incr,
+			}}, changes)
+		})
+	}
+}
+
+func TestExec_replayChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	master := New()
+	replica := New()
+	past := time.Now().Add(-time.Second).UnixMilli()
+
+	cmds := []command.Command{
+		{Type: command.CommandSET, Name: "counter", Set: command.SetArgs{Value: "5"}, Expire: command.ExpireArgs{Deadline: past}},
+		// ключ уже истек, счетчик начинается заново
+		{Type: command.CommandINCR, Name: "counter", Incr: command.IncrArgs{Delta: 1}},
+		{Type: command.CommandPERSIST, Name: "missing"},
+	}
+
+	var changes []command.Command
+	for _, cmd := range cmds {
+		_, c, err := master.Exec(ctx, cmd)
+		require.NoError(t, err)
+		changes = append(changes, c...)
+	}
+
+	for _, cmd := range changes {
+		require.NoError(t, replica.Replay(ctx, cmd))
+	}
+
+	got, err := replica.Do(ctx, command.Command{Type: command.CommandGET, Name: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", got)
+
+	ttl, err := replica.Do(ctx, command.Command{Type: command.CommandTTL, Name: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, "-1", ttl)
+}
+
+func TestChecksum(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	set := func(e *Engine, name, value string, deadline int64) {
+		require.NoError(t, e.Replay(ctx, command.Command{
+			Type:   command.CommandSET,
+			Name:   name,
+			Set:    command.SetArgs{Value: value},
+			Expire: command.ExpireArgs{Deadline: deadline},
+		}))
+	}
+	deadline := time.Now().Add(time.Hour).UnixMilli()
+
+	a, b := New(), New()
+	assert.Equal(t, a.Checksum(ctx), b.Checksum(ctx))
+
+	// порядок записи не важен
+	set(a, "a", "1", 0)
+	set(a, "b", "2", deadline)
+	set(b, "b", "2", deadline)
+	set(b, "a", "1", 0)
+	assert.Equal(t, a.Checksum(ctx), b.Checksum(ctx))
+
+	set(b, "a", "3", 0)
+	assert.NotEqual(t, a.Checksum(ctx), b.Checksum(ctx))
+	set(b, "a", "1", deadline)
+	assert.NotEqual(t, a.Checksum(ctx), b.Checksum(ctx))
+	set(b, "a", "1", 0)
+	assert.Equal(t, a.Checksum(ctx), b.Checksum(ctx))
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	master, replica := New(), New()
+	deadline := time.Now().Add(time.Hour).UnixMilli()
+	for _, cmd := range []command.Command{
+		{Type: command.CommandSET, Name: "a", Set: command.SetArgs{Value: "1"}, Expire: command.ExpireArgs{Deadline: deadline}},
+		{Type: command.CommandSET, Name: "b", Set: command.SetArgs{Value: "2"}},
+	} {
+		require.NoError(t, master.Replay(ctx, cmd))
+	}
+	for _, cmd := range []command.Command{
+		{Type: command.CommandSET, Name: "a", Set: command.SetArgs{Value: "old"}},
+		{Type: command.CommandSET, Name: "stale", Set: command.SetArgs{Value: "old"}},
+	} {
+		require.NoError(t, replica.Replay(ctx, cmd))
+	}
+
+	require.NoError(t, replica.Load(ctx, master.Dump(ctx)))
+	assert.Equal(t, master.Checksum(ctx), replica.Checksum(ctx))
+	_, err := replica.Do(ctx, command.Command{Type: command.CommandGET, Name: "stale"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// некорректная команда не меняет данные
+	err = replica.Load(ctx, []command.Command{{Type: command.CommandSET}})
+	require.Error(t, err)
+	assert.Equal(t, master.Checksum(ctx), replica.Checksum(ctx))
+}
+
+func TestDo_conditionalSet(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		value string
+		cmd   command.Command
+
+		want      string
+		wantValue string
+		written   bool
+	}
+
+	tests := map[string]test{
+		"set nx for missing key": {
+			cmd: command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{
+				Value: "new", Condition: command.SetIfNotExists,
+			}},
+			want:      "1",
+			wantValue: "new",
+			written:   true,
+		},
+		"set nx for existing key": {
+			value: "old",
+			cmd: command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{
+				Value: "new", Condition: command.SetIfNotExists,
+			}},
+			want:      "0",
+			wantValue: "old",
+		},
+		"set xx for missing key": {
+			cmd: command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{
+				Value: "new", Condition: command.SetIfExists,
+			}},
+			want: "0",
+		},
+		"set xx for existing key": {
+			value: "old",
+			cmd: command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{
+				Value: "new", Condition: command.SetIfExists,
+			}},
+			want:      "1",
+			wantValue: "new",
+			written:   true,
+		},
+		"cas with expected value": {
+			value: "old",
+			cmd: command.Command{Type: command.CommandCAS, Name: "name", Set: command.SetArgs{
+				Value: "new", Expected: "old",
+			}},
+			want:      "1",
+			wantValue: "new",
+			written:   true,
+		},
+		"cas with other value": {
+			value: "other",
+			cmd: command.Command{Type: command.CommandCAS, Name: "name", Set: command.SetArgs{
+				Value: "new", Expected: "old",
+			}},
+			want:      "0",
+			wantValue: "other",
+		},
+		"cas for missing key": {
+			cmd: command.Command{Type: command.CommandCAS, Name: "name", Set: command.SetArgs{
+				Value: "new", Expected: "old",
+			}},
+			want: "0",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			e := New()
+			if tc.value != "" {
+				_, err := e.Do(ctx, command.Command{
+					Type: command.CommandSET,
+					Name: tc.cmd.Name,
+					Set:  command.SetArgs{Value: tc.value},
+				})
+				require.NoError(t, err)
+			}
+
+			got, changes, err := e.Exec(ctx, tc.cmd)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+
+			if !tc.written {
+				assert.Empty(t, changes)
+			} else {
+				assert.Equal(t, []command.Command{{
+					Type: command.CommandSET,
+					Name: tc.cmd.Name,
+					Set:  command.SetArgs{Value: tc.cmd.Set.Value},
+				}}, changes)
+			}
+
+			value, err := e.Do(ctx, command.Command{Type: command.CommandGET, Name: tc.cmd.Name})
+			if tc.wantValue == "" {
+				assert.ErrorIs(t, err, ErrNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantValue, value)
+		})
+	}
+}
+
+func TestDo_multiKey(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := New()
+
+	_, changes, err := e.Exec(ctx, command.Command{
+		Type:   command.CommandMSET,
+		Keys:   []string{"k1", "k2", "k3"},
+		Values: []string{"v1", "v2", "v3"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	got, err := e.Do(ctx, command.Command{
+		Type: command.CommandMGET,
+		Keys: []string{"k1", "missing", "k3"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "v1\n(nil)\nv3", got)
+
+	got, changes, err = e.Exec(ctx, command.Command{
+		Type: command.CommandMDEL,
+		Keys: []string{"k1", "missing", "k2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "2", got)
+	assert.Equal(t, []command.Command{{
+		Type: command.CommandMDEL,
+		Keys: []string{"k1", "k2"},
+	}}, changes)
+
+	_, err = e.Do(ctx, command.Command{
+		Type:   command.CommandMSET,
+		Keys:   []string{"k1", "k2"},
+		Values: []string{"v1"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidCmd)
+
+	_, err = e.Do(ctx, command.Command{Type: command.CommandMGET})
+	assert.ErrorIs(t, err, ErrInvalidCmd)
+}
+
+func TestScan_concurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	e := New()
+
+	const stable = 1000
+	for i := range stable {
+		_, err := e.Do(ctx, command.Command{
+			Type: command.CommandSET,
+			Name: fmt.Sprintf("stable%d", i),
+			Set:  command.SetArgs{Value: "value"},
+		})
+		require.NoError(t, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ctx.Err() == nil; i++ {
+			name := fmt.Sprintf("temp%d", i%500)
+			_, _ = e.Do(ctx, command.Command{
+				Type: command.CommandSET,
+				Name: name,
+				Set:  command.SetArgs{Value: "value"},
+			})
+			_, _ = e.Do(ctx, command.Command{Type: command.CommandDEL, Name: name})
+			if i == 20000 {
+				return
+			}
+		}
+	}()
+
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		out, err := e.Do(ctx, command.Command{
+			Type: command.CommandSCAN,
+			Scan: command.ScanArgs{Cursor: cursor, Match: "stable*", Count: 50},
+		})
+		require.NoError(t, err)
+
+		lines := strings.Split(out, "\n")
+		cursor = lines[0]
+		for _, key := range lines[1:] {
+			seen[key] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	<-done
+
+	assert.Len(t, seen, stable)
+
+	size, err := e.Do(ctx, command.Command{Type: command.CommandDBSIZE})
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(stable), size)
+
+	keys, err := e.Do(ctx, command.Command{
+		Type: command.CommandKEYS,
+		Scan: command.ScanArgs{Match: "stable99?"},
+	})
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(keys, "\n"), 10)
+
+	_, err = e.Do(ctx, command.Command{
+		Type: command.CommandSCAN,
+		Scan: command.ScanArgs{Cursor: "bad", Count: 10},
+	})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestDo_range(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rangeCmd := command.Command{
+		Type:  command.CommandREVRANGE,
+		Range: command.RangeArgs{Start: "evt:", End: "evt:~", Limit: 2},
+	}
+
+	_, err := New().Do(ctx, rangeCmd)
+	assert.ErrorIs(t, err, ErrRangeNotSupported)
+
+	e := New(WithOrdered())
+	_, err = e.Do(ctx, command.Command{
+		Type:   command.CommandMSET,
+		Keys:   []string{"evt:1", "evt:2", "evt:3", "user:1"},
+		Values: []string{"a", "b", "c", "d"},
+	})
+	require.NoError(t, err)
+
+	got, err := e.Do(ctx, rangeCmd)
+	require.NoError(t, err)
+	assert.Equal(t, "evt:3 c\nevt:2 b", got)
+}
+
+func TestExecTx(t *testing.T) {
+	t.Parallel()
+
+	set := func(name, value string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: value}}
+	}
+	get := func(name string) command.Command {
+		return command.Command{Type: command.CommandGET, Name: name}
+	}
+	incr := command.Command{Type: command.CommandINCR, Name: "name", Incr: command.IncrArgs{Delta: 1}}
+
+	type test struct {
+		// before выполняется после WATCH, но до EXEC
+		before []command.Command
+		watch  []string
+		cmds   []command.Command
+
+		want    []command.Result
+		changes int
+		err     error
+	}
+
+	tests := map[string]test{
+		"commands see each other": {
+			cmds: []command.Command{set("a", "1"), get("a"), set("b", "2")},
+			want: []command.Result{
+				{}, {Out: "1"}, {},
+			},
+			changes: 2,
+		},
+		"error does not abort other commands": {
+			before: []command.Command{set("name", "text")},
+			cmds:   []command.Command{incr, set("a", "1")},
+			want: []command.Result{
+				{Err: ErrNotInteger}, {},
+			},
+			changes: 1,
+		},
+		"watched key changed": {
+			watch:  []string{"a"},
+			before: []command.Command{set("a", "other")},
+			cmds:   []command.Command{set("a", "1")},
+			err:    ErrTxAborted,
+		},
+		"watched missing key created": {
+			watch:  []string{"missing"},
+			before: []command.Command{set("missing", "1"), {Type: command.CommandDEL, Name: "missing"}},
+			cmds:   []command.Command{set("a", "1")},
+			err:    ErrTxAborted,
+		},
+		"other key changed": {
+			watch:   []string{"a"},
+			before:  []command.Command{set("b", "other")},
+			cmds:    []command.Command{set("a", "1")},
+			want:    []command.Result{{}},
+			changes: 1,
+		},
+		"too large": {
+			cmds: []command.Command{set("a", "1"), set("b", "2"), set("c", "3")},
+			err:  ErrCommandTooLarge,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			e := New()
+			e.limits.command = 100
+
+			watched, err := e.Watch(ctx, tc.watch)
+			require.NoError(t, err)
+			for _, cmd := range tc.before {
+				_, err := e.Do(ctx, cmd)
+				require.NoError(t, err)
+			}
+
+			results, changes, err := e.ExecTx(ctx, command.Tx{
+				Commands: tc.cmds,
+				Watched:  watched,
+			})
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				assert.Empty(t, changes)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, results, len(tc.want))
+			for i := range tc.want {
+				assert.Equal(t, tc.want[i].Out, results[i].Out)
+				assert.ErrorIs(t, results[i].Err, tc.want[i].Err)
+			}
+			assert.Len(t, changes, tc.changes)
+		})
+	}
+}
+
+type eventRecorder struct {
+	events []event.Event
+}
+
+func (r *eventRecorder) Notify(e event.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r := &eventRecorder{}
+	e := New(WithNotifier(r))
+
+	past := time.Now().Add(-time.Second).UnixMilli()
+	cmds := []command.Command{
+		setCmd("k1"),
+		{Type: command.CommandDEL, Name: "k1"},
+		// удаление отсутствующего ключа не порождает событие
+		{Type: command.CommandDEL, Name: "k1"},
+		{Type: command.CommandSET, Name: "old", Set: command.SetArgs{Value: "v"}, Expire: command.ExpireArgs{Deadline: past}},
+	}
+	for _, cmd := range cmds {
+		_, err := e.Do(ctx, cmd)
+		require.NoError(t, err)
+	}
+	e.Sweep(ctx)
+
+	want := []event.Event{
+		{Type: event.Set, Key: "k1", Value: "v"},
+		{Type: event.Del, Key: "k1"},
+		{Type: event.Set, Key: "old", Value: "v"},
+		{Type: event.Expire, Key: "old"},
+	}
+	assert.Equal(t, want, r.events)
+}
+
+func TestNotifier_evict(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r := &eventRecorder{}
+	e := New(WithMemoryLimit(limitFor(1), config.EvictionAllKeysLRU), WithNotifier(r))
+
+	_, err := e.Do(ctx, setCmd("k1"))
+	require.NoError(t, err)
+	_, err = e.Do(ctx, setCmd("k2"))
+	require.NoError(t, err)
+
+	want := []event.Event{
+		{Type: event.Set, Key: "k1", Value: "v"},
+		{Type: event.Evict, Key: "k1"},
+		{Type: event.Set, Key: "k2", Value: "v"},
+	}
+	assert.Equal(t, want, r.events)
+}
+
+func TestNotifier_replay(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r := &eventRecorder{}
+	e := New(WithNotifier(r))
+
+	// восстановление из журнала и загрузка состояния не порождают событий
+	require.NoError(t, e.Recover(ctx, setCmd("k1")))
+	require.NoError(t, e.Recover(ctx, command.Command{Type: command.CommandDEL, Name: "k1"}))
+	require.NoError(t, e.Load(ctx, []command.Command{setCmd("k2")}))
+	require.NoError(t, e.Load(ctx, []command.Command{setCmd("k3")}))
+	assert.Empty(t, r.events)
+
+	// изменения от мастера видны подписчикам реплики, как команды клиентов
+	require.NoError(t, e.Replay(ctx, setCmd("k4")))
+	_, err := e.Do(ctx, command.Command{Type: command.CommandDEL, Name: "k3"})
+	require.NoError(t, err)
+	want := []event.Event{
+		{Type: event.Set, Key: "k4", Value: "v"},
+		{Type: event.Del, Key: "k3"},
+	}
+	assert.Equal(t, want, r.events)
+}
+
+func TestNotifier_ordered(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r := &eventRecorder{}
+	// опции до WithOrdered не теряются
+	e := New(WithMemoryLimit(limitFor(1), config.EvictionAllKeysLRU), WithNotifier(r), WithOrdered())
+
+	_, err := e.Do(ctx, setCmd("k1"))
+	require.NoError(t, err)
+	_, err = e.Do(ctx, setCmd("k2"))
+	require.NoError(t, err)
+
+	want := []event.Event{
+		{Type: event.Set, Key: "k1", Value: "v"},
+		{Type: event.Evict, Key: "k1"},
+		{Type: event.Set, Key: "k2", Value: "v"},
+	}
+	assert.Equal(t, want, r.events)
+
+	got, err := e.Do(ctx, command.Command{Type: command.CommandRANGE, Range: command.RangeArgs{Start: "k", End: "l"}})
+	require.NoError(t, err)
+	assert.Equal(t, "k2 v", got)
+}
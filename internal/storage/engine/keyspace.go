@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+)
+
+var (
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrRangeNotSupported = errors.New("range queries require the ordered engine")
+)
+
+// scanDone - курсор начала и конца обхода
+const scanDone = "0"
+
+// keyspace - структура, в которой движок хранит значения ключей.
+// Все методы вызываются под блокировкой storage.
+type keyspace interface {
+	Get(key string) (string, bool)
+	Set(key string, value string)
+	Delete(key string)
+	Len() int
+
+	// Scan передает в fn ключи, начиная с cursor, пока не наберется хотя бы count ключей,
+	// и возвращает курсор для продолжения. Ключ, который существует все время обхода,
+	// будет передан хотя бы один раз.
+	Scan(cursor string, count int, fn func(key string)) (string, error)
+	// ForEach обходит все ключи, пока fn возвращает true
+	ForEach(fn func(key string, value string) bool)
+}
+
+// orderedKeyspace - пространство ключей с обходом по порядку ключей
+type orderedKeyspace interface {
+	keyspace
+
+	// Range обходит ключи из отрезка [start, end] по возрастанию или по убыванию,
+	// пока fn возвращает true
+	Range(start string, end string, reverse bool, fn func(key string, value string) bool)
+}
+
+// hashShards - количество шардов, должно быть степенью двойки
+const hashShards = 256
+
+// hashKeyspace разбивает ключи на шарды по хэшу.
+// Шард ключа не меняется, поэтому курсором служит номер шарда.
+type hashKeyspace struct {
+	shards [hashShards]map[string]string
+	size   int
+}
+
+func newHashKeyspace() *hashKeyspace {
+	h := hashKeyspace{}
+	for i := range h.shards {
+		h.shards[i] = make(map[string]string)
+	}
+	return &h
+}
+
+func (h *hashKeyspace) shard(key string) map[string]string {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(key))
+	return h.shards[f.Sum32()&(hashShards-1)]
+}
+
+func (h *hashKeyspace) Get(key string) (string, bool) {
+	v, ok := h.shard(key)[key]
+	return v, ok
+}
+
+func (h *hashKeyspace) Set(key string, value string) {
+	shard := h.shard(key)
+	if _, ok := shard[key]; !ok {
+		h.size++
+	}
+	shard[key] = value
+}
+
+func (h *hashKeyspace) Delete(key string) {
+	shard := h.shard(key)
+	if _, ok := shard[key]; ok {
+		h.size--
+		delete(shard, key)
+	}
+}
+
+func (h *hashKeyspace) Len() int {
+	return h.size
+}
+
+func (h *hashKeyspace) Scan(cursor string, count int, fn func(key string)) (string, error) {
+	i, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil || i >= hashShards {
+		return "", ErrInvalidCursor
+	}
+
+	visited := 0
+	for ; i < hashShards && visited < count; i++ {
+		for key := range h.shards[i] {
+			fn(key)
+		}
+		visited += len(h.shards[i])
+	}
+
+	if i == hashShards {
+		return scanDone, nil
+	}
+	return strconv.FormatUint(i, 10), nil
+}
+
+func (h *hashKeyspace) ForEach(fn func(key string, value string) bool) {
+	for _, shard := range h.shards {
+		for key, value := range shard {
+			if !fn(key, value) {
+				return
+			}
+		}
+	}
+}
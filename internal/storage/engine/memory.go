@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/domain/event"
+)
+
+var ErrOutOfMemory = errors.New("out of memory: max_memory reached")
+
+const (
+	// entryOverhead - приблизительные накладные расходы на хранение одного ключа
+	entryOverhead = 48
+	// evictionSample - из скольких случайных ключей выбирается вытесняемый
+	evictionSample = 5
+)
+
+// memory - учет объема ключей и значений и вытеснение при превышении предела.
+// Учет приблизительный: длина ключа и значения плюс entryOverhead.
+type memory struct {
+	used   int64
+	limit  int64
+	policy config.EvictionPolicy
+
+	// stats ведется только для allkeys-lru и allkeys-lfu
+	stats map[string]*keyStats
+	clock atomic.Int64
+}
+
+// keyStats обновляется при чтении под RLock, поэтому поля атомарные
+type keyStats struct {
+	access atomic.Int64
+	hits   atomic.Uint32
+}
+
+func entrySize(key string, value string) int64 {
+	return int64(len(key) + len(value) + entryOverhead)
+}
+
+func (m *memory) trackStats() bool {
+	return m.policy == config.EvictionAllKeysLRU || m.policy == config.EvictionAllKeysLFU
+}
+
+func (s *storage) setValue(name string, value string) {
+	old, ok := s.data.Get(name)
+	if ok {
+		s.mem.used -= entrySize(name, old)
+	}
+	s.mem.used += entrySize(name, value)
+	s.data.Set(name, value)
+
+	if !s.mem.trackStats() {
+		return
+	}
+	if !ok {
+		s.mem.stats[name] = &keyStats{}
+	}
+	s.touch(name)
+}
+
+func (s *storage) removeValue(name string) {
+	old, ok := s.data.Get(name)
+	if !ok {
+		return
+	}
+	s.mem.used -= entrySize(name, old)
+	s.data.Delete(name)
+
+	if s.mem.trackStats() {
+		delete(s.mem.stats, name)
+	}
+}
+
+// touch отмечает обращение к ключу для allkeys-lru и allkeys-lfu
+func (s *storage) touch(name string) {
+	st, ok := s.mem.stats[name]
+	if !ok {
+		return
+	}
+	st.access.Store(s.mem.clock.Add(1))
+	if st.hits.Load() < math.MaxUint32 {
+		st.hits.Add(1)
+	}
+}
+
+// freeMemory вытесняет ключи, пока объем не станет меньше предела.
+// Вытесненные ключи попадают в журнал как DEL, чтобы реплики удалили те же ключи.
+func (t *tx) freeMemory() error {
+	m := &t.s.mem
+	if m.limit == 0 || t.replay {
+		return nil
+	}
+
+	for m.used >= m.limit {
+		if m.policy == config.EvictionNo {
+			return ErrOutOfMemory
+		}
+
+		name, ok := t.s.victim()
+		if !ok {
+			return ErrOutOfMemory
+		}
+		t.s.remove(name)
+		t.notify(event.Evict, name, "")
+		t.record(command.Command{
+			Type: command.CommandDEL,
+			Name: name,
+		})
+	}
+	return nil
+}
+
+// victim выбирает ключ для вытеснения среди случайной выборки
+func (s *storage) victim() (string, bool) {
+	victim := ""
+	found := false
+	sampled := 0
+
+	switch s.mem.policy {
+	case config.EvictionAllKeysLRU, config.EvictionAllKeysLFU:
+		lfu := s.mem.policy == config.EvictionAllKeysLFU
+		var best *keyStats
+		for name, st := range s.mem.stats {
+			if sampled == evictionSample {
+				break
+			}
+			sampled++
+			if best == nil || colder(st, best, lfu) {
+				best, victim, found = st, name, true
+			}
+		}
+
+	case config.EvictionVolatileTTL:
+		best := int64(0)
+		for name, deadline := range s.expires {
+			if sampled == evictionSample {
+				break
+			}
+			sampled++
+			if !found || deadline < best {
+				best, victim, found = deadline, name, true
+			}
+		}
+	}
+
+	return victim, found
+}
+
+func colder(a *keyStats, b *keyStats, lfu bool) bool {
+	if lfu {
+		ah, bh := a.hits.Load(), b.hits.Load()
+		if ah != bh {
+			return ah < bh
+		}
+	}
+	return a.access.Load() < b.access.Load()
+}
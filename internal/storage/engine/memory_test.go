@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitFor - предел, в который помещается cnt ключей вида kN со значением из одного символа
+func limitFor(cnt int) uint64 {
+	return uint64(cnt * int(entrySize("k1", "v")))
+}
+
+func setCmd(name string) command.Command {
+	return command.Command{
+		Type: command.CommandSET,
+		Name: name,
+		Set:  command.SetArgs{Value: "v"},
+	}
+}
+
+func TestMemory_noEviction(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := New(WithMemoryLimit(limitFor(2), config.EvictionNo))
+
+	_, err := e.Do(ctx, setCmd("k1"))
+	require.NoError(t, err)
+	_, err = e.Do(ctx, setCmd("k2"))
+	require.NoError(t, err)
+
+	_, changes, err := e.Exec(ctx, setCmd("k3"))
+	assert.ErrorIs(t, err, ErrOutOfMemory)
+	assert.Empty(t, changes)
+
+	// удаление разрешено и освобождает место
+	_, err = e.Do(ctx, command.Command{Type: command.CommandDEL, Name: "k1"})
+	require.NoError(t, err)
+	_, err = e.Do(ctx, setCmd("k3"))
+	require.NoError(t, err)
+}
+
+func TestMemory_allKeysLRU(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := New(WithMemoryLimit(limitFor(3), config.EvictionAllKeysLRU))
+
+	for _, name := range []string{"k1", "k2", "k3"} {
+		_, err := e.Do(ctx, setCmd(name))
+		require.NoError(t, err)
+	}
+
+	// k1 становится самым свежим, вытесняется k2
+	_, err := e.Do(ctx, command.Command{Type: command.CommandGET, Name: "k1"})
+	require.NoError(t, err)
+
+	_, changes, err := e.Exec(ctx, setCmd("k4"))
+	require.NoError(t, err)
+	assert.Equal(t, []command.Command{
+		{Type: command.CommandDEL, Name: "k2"},
+		setCmd("k4"),
+	}, changes)
+
+	_, err = e.Do(ctx, command.Command{Type: command.CommandGET, Name: "k2"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemory_allKeysLFU(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := New(WithMemoryLimit(limitFor(3), config.EvictionAllKeysLFU))
+
+	for _, name := range []string{"k1", "k2", "k3"} {
+		_, err := e.Do(ctx, setCmd(name))
+		require.NoError(t, err)
+	}
+	for range 3 {
+		for _, name := range []string{"k1", "k2"} {
+			_, err := e.Do(ctx, command.Command{Type: command.CommandGET, Name: name})
+			require.NoError(t, err)
+		}
+	}
+
+	_, changes, err := e.Exec(ctx, setCmd("k4"))
+	require.NoError(t, err)
+	assert.Equal(t, command.Command{Type: command.CommandDEL, Name: "k3"}, changes[0])
+}
+
+func TestMemory_volatileTTL(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	e := New(WithMemoryLimit(limitFor(3), config.EvictionVolatileTTL))
+
+	soon := setCmd("k1")
+	soon.Expire.TTL = time.Minute
+	later := setCmd("k2")
+	later.Expire.TTL = time.Hour
+
+	for _, cmd := range []command.Command{soon, later, setCmd("k3")} {
+		_, err := e.Do(ctx, cmd)
+		require.NoError(t, err)
+	}
+
+	_, changes, err := e.Exec(ctx, setCmd("k4"))
+	require.NoError(t, err)
+	assert.Equal(t, command.Command{Type: command.CommandDEL, Name: "k1"}, changes[0])
+
+	_, _, err = e.Exec(ctx, setCmd("k5"))
+	require.NoError(t, err)
+
+	// ключей со временем жизни не осталось
+	_, _, err = e.Exec(ctx, setCmd("k6"))
+	assert.ErrorIs(t, err, ErrOutOfMemory)
+}
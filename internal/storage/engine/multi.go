@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"inmem-db/internal/domain/command"
+)
+
+var ErrTxAborted = errors.New("transaction aborted: watched key changed")
+
+// Watch возвращает версии ключей, по которым ExecTx проверит, что ключи не менялись
+func (e *Engine) Watch(ctx context.Context, keys []string) (map[string]command.WatchedKey, error) {
+	e.s.mu.RLock()
+	defer e.s.mu.RUnlock()
+
+	return e.s.Watch(ctx, keys), nil
+}
+
+// DoTx выполняет транзакцию без журналирования изменений
+func (e *Engine) DoTx(ctx context.Context, multi command.Tx) ([]command.Result, error) {
+	results, _, err := e.ExecTx(ctx, multi)
+	return results, err
+}
+
+// ExecTx выполняет команды транзакции под одной блокировкой и возвращает изменения всех команд.
+// Если ключ из WATCH изменился, ни одна команда не выполняется.
+// Ошибка отдельной команды, как в redis, не отменяет остальные и возвращается в ее результате.
+func (e *Engine) ExecTx(ctx context.Context, multi command.Tx) ([]command.Result, []command.Command, error) {
+	slog.DebugContext(ctx, "exec transaction", slog.Int("commands", len(multi.Commands)))
+
+	// изменения всех команд транзакции пишутся в один сегмент журнала
+	size := 0
+	for _, cmd := range multi.Commands {
+		if !cmd.IsReadOnly() {
+			size += commandSize(cmd)
+		}
+	}
+	if size > e.limits.command {
+		return nil, nil, fmt.Errorf("%w: transaction of %d bytes, max %d", ErrCommandTooLarge, size, e.limits.command)
+	}
+
+	results := make([]command.Result, len(multi.Commands))
+	changes, err := e.s.update(false, false, func(t *tx) error {
+		if t.s.changed(multi.Watched) {
+			return ErrTxAborted
+		}
+
+		now := t.s.now()
+		for i, cmd := range multi.Commands {
+			err := e.validate(cmd)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			cmd = cmd.WithDeadline(now)
+
+			if cmd.IsReadOnly() {
+				results[i].Out, results[i].Err = e.read(ctx, cmd)
+				continue
+			}
+			results[i].Out, results[i].Err = write(t, cmd)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, changes, err
+	}
+	return results, changes, nil
+}
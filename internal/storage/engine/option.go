@@ -0,0 +1,45 @@
+package engine
+
+import "inmem-db/internal/config"
+
+type Option func(*Engine)
+
+// WithOrdered хранит ключи в skiplist, что позволяет выполнять RANGE и REVRANGE.
+// Заменяется только пустой keyspace, остальные опции сохраняются в любом порядке.
+func WithOrdered() Option {
+	return func(e *Engine) {
+		e.s.data = newSkipList()
+	}
+}
+
+// WithMemoryLimit ограничивает объем ключей и значений, при превышении
+// ключи вытесняются по policy или запись отклоняется для noeviction
+func WithMemoryLimit(limit uint64, policy config.EvictionPolicy) Option {
+	return func(e *Engine) {
+		e.s.mem.limit = int64(limit)
+		e.s.mem.policy = policy
+		if e.s.mem.trackStats() {
+			e.s.mem.stats = make(map[string]*keyStats)
+		}
+	}
+}
+
+// WithNotifier передает события изменения ключей в notifier, кроме изменений из Replay и Load.
+// Notify вызывается под блокировкой хранилища и не должен блокироваться.
+func WithNotifier(n Notifier) Option {
+	return func(e *Engine) {
+		e.s.notifier = n
+	}
+}
+
+// WithSizeLimits ограничивает размер ключа и значения в командах записи, 0 - ограничение по умолчанию
+func WithSizeLimits(maxKey, maxValue uint64) Option {
+	return func(e *Engine) {
+		if maxKey > 0 {
+			e.limits.key = int(maxKey)
+		}
+		if maxValue > 0 {
+			e.limits.value = int(maxValue)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"encoding/hex"
+	"math/rand/v2"
+)
+
+const (
+	skipMaxLevel = 32
+	// skipP - вероятность перехода узла на следующий уровень
+	skipP = 0.25
+)
+
+type skipNode struct {
+	key   string
+	value string
+	next  []*skipNode
+}
+
+// skipList - упорядоченное пространство ключей для движка ordered.
+// Курсор SCAN - hex следующего ключа, поэтому обход устойчив к вставкам и удалениям.
+type skipList struct {
+	head  *skipNode
+	level int
+	size  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipMaxLevel && rand.Float64() < skipP {
+		level++
+	}
+	return level
+}
+
+// seek заполняет update узлами, после которых должен стоять key на каждом уровне,
+// и возвращает первый узел с ключом >= key
+func (l *skipList) seek(key string, update []*skipNode) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// last возвращает последний узел с ключом <= key
+func (l *skipList) last(key string) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key <= key {
+			x = x.next[i]
+		}
+	}
+	if x == l.head {
+		return nil
+	}
+	return x
+}
+
+// before возвращает последний узел с ключом < key
+func (l *skipList) before(key string) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+	}
+	if x == l.head {
+		return nil
+	}
+	return x
+}
+
+func (l *skipList) Get(key string) (string, bool) {
+	n := l.seek(key, nil)
+	if n == nil || n.key != key {
+		return "", false
+	}
+	return n.value, true
+}
+
+func (l *skipList) Set(key string, value string) {
+	update := make([]*skipNode, skipMaxLevel)
+	n := l.seek(key, update)
+	if n != nil && n.key == key {
+		n.value = value
+		return
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	n = &skipNode{
+		key:   key,
+		value: value,
+		next:  make([]*skipNode, level),
+	}
+	for i := range level {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	l.size++
+}
+
+func (l *skipList) Delete(key string) {
+	update := make([]*skipNode, skipMaxLevel)
+	n := l.seek(key, update)
+	if n == nil || n.key != key {
+		return
+	}
+
+	for i := range l.level {
+		if update[i].next[i] != n {
+			break
+		}
+		update[i].next[i] = n.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.size--
+}
+
+func (l *skipList) Len() int {
+	return l.size
+}
+
+func (l *skipList) Scan(cursor string, count int, fn func(key string)) (string, error) {
+	from := ""
+	if cursor != scanDone {
+		b, err := hex.DecodeString(cursor)
+		if err != nil || len(b) == 0 {
+			return "", ErrInvalidCursor
+		}
+		from = string(b)
+	}
+
+	n := l.seek(from, nil)
+	for visited := 0; n != nil && visited < count; visited++ {
+		fn(n.key)
+		n = n.next[0]
+	}
+
+	if n == nil {
+		return scanDone, nil
+	}
+	return hex.EncodeToString([]byte(n.key)), nil
+}
+
+func (l *skipList) ForEach(fn func(key string, value string) bool) {
+	for n := l.head.next[0]; n != nil; n = n.next[0] {
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
+
+// Range обходит ключи из отрезка [start, end] по возрастанию или по убыванию,
+// пока fn возвращает true
+func (l *skipList) Range(start string, end string, reverse bool, fn func(key string, value string) bool) {
+	if !reverse {
+		for n := l.seek(start, nil); n != nil && n.key <= end; n = n.next[0] {
+			if !fn(n.key, n.value) {
+				return
+			}
+		}
+		return
+	}
+
+	for n := l.last(end); n != nil && n.key >= start; n = l.before(n.key) {
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
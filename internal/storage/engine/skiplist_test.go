@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipList(t *testing.T) {
+	t.Parallel()
+
+	l := newSkipList()
+	want := map[string]string{}
+
+	for i := range 1000 {
+		key := fmt.Sprintf("key%04d", rand.IntN(500))
+		if i%3 == 0 {
+			l.Delete(key)
+			delete(want, key)
+			continue
+		}
+		value := fmt.Sprint(i)
+		l.Set(key, value)
+		want[key] = value
+	}
+
+	require.Equal(t, len(want), l.Len())
+	for key, value := range want {
+		got, ok := l.Get(key)
+		require.True(t, ok)
+		assert.Equal(t, value, got)
+	}
+
+	wantKeys := make([]string, 0, len(want))
+	for key := range want {
+		wantKeys = append(wantKeys, key)
+	}
+	sort.Strings(wantKeys)
+
+	gotKeys := []string{}
+	l.ForEach(func(key string, _ string) bool {
+		gotKeys = append(gotKeys, key)
+		return true
+	})
+	assert.Equal(t, wantKeys, gotKeys)
+}
+
+func TestSkipList_Range(t *testing.T) {
+	t.Parallel()
+
+	l := newSkipList()
+	for _, key := range []string{"evt:01", "evt:02", "evt:03", "evt:04", "other"} {
+		l.Set(key, "v"+key)
+	}
+
+	collect := func(start string, end string, reverse bool, limit int) []string {
+		keys := []string{}
+		l.Range(start, end, reverse, func(key string, _ string) bool {
+			keys = append(keys, key)
+			return limit == 0 || len(keys) < limit
+		})
+		return keys
+	}
+
+	assert.Equal(t, []string{"evt:02", "evt:03"}, collect("evt:02", "evt:03", false, 0))
+	assert.Equal(t, []string{"evt:01", "evt:02"}, collect("evt:", "evt:~", false, 2))
+	assert.Equal(t, []string{"evt:04", "evt:03"}, collect("evt:", "evt:~", true, 2))
+	assert.Equal(t, []string{"evt:03", "evt:02", "evt:01"}, collect("a", "evt:03", true, 0))
+	assert.Empty(t, collect("x", "z", false, 0))
+}
+
+func TestSkipList_Scan(t *testing.T) {
+	t.Parallel()
+
+	l := newSkipList()
+	const keys = 100
+	for i := range keys {
+		l.Set(fmt.Sprintf("key%03d", i), "value")
+	}
+
+	seen := []string{}
+	cursor := scanDone
+	for {
+		var err error
+		cursor, err = l.Scan(cursor, 7, func(key string) {
+			seen = append(seen, key)
+			// удаление уже пройденных ключей не ломает обход
+			l.Delete(key)
+		})
+		require.NoError(t, err)
+		if cursor == scanDone {
+			break
+		}
+	}
+	assert.Len(t, seen, keys)
+
+	_, err := l.Scan("zz", 1, func(string) {})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -2,49 +2,363 @@ package engine
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"hash/fnv"
+	"io"
 	"sync"
+	"time"
+
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/domain/event"
+	"inmem-db/pkg/glob"
 )
 
 var ErrNotFound = errors.New("value not found")
 
 type storage struct {
-	mu   sync.RWMutex
-	data map[string]string
+	mu      sync.RWMutex
+	data    keyspace
+	expires map[string]int64
+	mem     memory
+
+	// versions - версия каждого ключа для WATCH, меняется при любом изменении ключа.
+	// Удаленные ключи не хранятся, вместо этого deletedAt - версия последнего удаления.
+	versions  map[string]uint64
+	version   uint64
+	deletedAt uint64
+
+	// notifier получает события изменения ключей под блокировкой, поэтому в порядке применения
+	notifier Notifier
+
+	now func() time.Time
 }
 
-func newStorage() *storage {
+func newStorage(data keyspace) *storage {
 	return &storage{
-		data: make(map[string]string, 50),
+		data:     data,
+		expires:  make(map[string]int64),
+		versions: make(map[string]uint64),
+		now:      time.Now,
 	}
 }
 
-func (s *storage) Set(ctx context.Context, name string, value string) error {
+// tx - изменение хранилища под блокировкой.
+// changes накапливает команды, которые повторяют изменение при восстановлении из wal.
+type tx struct {
+	s *storage
+
+	// replay - команды применяются из wal или от мастера,
+	// истекшие ключи удаляются только явными DEL из журнала
+	replay bool
+	// silent - изменения уже были видны до перезапуска или передаются состоянием целиком,
+	// события о них не отправляются
+	silent  bool
+	changes []command.Command
+}
+
+func (s *storage) update(replay, silent bool, fn func(t *tx) error) ([]command.Command, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.data[name] = value
+	t := tx{
+		s:      s,
+		replay: replay,
+		silent: silent,
+	}
+	// изменения, сделанные до ошибки (удаление истекших и вытесненных ключей), тоже возвращаются
+	err := fn(&t)
+	return t.changes, err
+}
 
-	return nil
+// notify сообщает об изменении ключа. Восстановление из журнала при запуске и загрузка
+// состояния событий не порождают, команды мастера на реплике порождают их, как клиентские.
+func (t *tx) notify(typ event.Type, name string, value string) {
+	if t.silent {
+		return
+	}
+	t.s.notify(typ, name, value)
 }
 
-func (s *storage) Get(ctx context.Context, name string) (string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (t *tx) record(cmd command.Command) {
+	t.changes = append(t.changes, cmd)
+}
+
+// lookup возвращает значение ключа, истекший ключ удаляется и попадает в журнал как DEL
+func (t *tx) lookup(name string) (string, bool) {
+	v, ok := t.s.data.Get(name)
+	if !ok {
+		return "", false
+	}
+	if t.replay || !t.s.isExpired(name) {
+		return v, true
+	}
+
+	t.s.remove(name)
+	t.notify(event.Expire, name, "")
+	t.record(command.Command{
+		Type: command.CommandDEL,
+		Name: name,
+	})
+	return "", false
+}
+
+// set записывает значение, deadline == 0 снимает время жизни ключа
+func (t *tx) set(name string, value string, deadline int64) {
+	t.s.setValue(name, value)
+	t.s.bump(name)
+	t.notify(event.Set, name, value)
+	if deadline == 0 {
+		delete(t.s.expires, name)
+	} else {
+		t.s.expires[name] = deadline
+	}
+}
+
+func (t *tx) deadline(name string) int64 {
+	return t.s.expires[name]
+}
+
+func (t *tx) expire(name string, deadline int64) {
+	t.s.expires[name] = deadline
+	t.s.bump(name)
+}
 
-	v, ok := s.data[name]
+func (t *tx) persist(name string) bool {
+	_, ok := t.s.expires[name]
 	if !ok {
+		return false
+	}
+	delete(t.s.expires, name)
+	t.s.bump(name)
+	return true
+}
+
+func (t *tx) del(name string) {
+	if t.s.remove(name) {
+		t.notify(event.Del, name, "")
+	}
+}
+
+// Методы чтения ниже вызываются под блокировкой, ее берет Engine.
+
+// Get не видит истекшие ключи, удаляет их активная очистка
+func (s *storage) Get(ctx context.Context, name string) (string, error) {
+	v, ok := s.data.Get(name)
+	if !ok || s.isExpired(name) {
 		return "", ErrNotFound
 	}
+	s.touch(name)
 
 	return v, nil
 }
 
-func (s *storage) Del(ctx context.Context, name string) error {
+// MGet читает значения, found[i] сообщает, найден ли keys[i]
+func (s *storage) MGet(ctx context.Context, keys []string) (values []string, found []bool) {
+	values = make([]string, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		v, ok := s.data.Get(key)
+		if !ok || s.isExpired(key) {
+			continue
+		}
+		values[i] = v
+		found[i] = true
+		s.touch(key)
+	}
+	return values, found
+}
+
+// TTL возвращает оставшееся время жизни ключа, -1 если время жизни не задано
+func (s *storage) TTL(ctx context.Context, name string) (time.Duration, error) {
+	if _, ok := s.data.Get(name); !ok || s.isExpired(name) {
+		return 0, ErrNotFound
+	}
+	deadline, ok := s.expires[name]
+	if !ok {
+		return -1, nil
+	}
+	return time.UnixMilli(deadline).Sub(s.now()), nil
+}
+
+// Scan возвращает порцию ключей, подходящих под шаблон, и курсор для продолжения
+func (s *storage) Scan(ctx context.Context, cursor string, count int, match glob.Matcher) ([]string, string, error) {
+	keys := make([]string, 0, count)
+	next, err := s.data.Scan(cursor, count, func(key string) {
+		if !s.isExpired(key) && match(key) {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, next, nil
+}
+
+// Keys возвращает все ключи, подходящие под шаблон, за один проход
+func (s *storage) Keys(ctx context.Context, match glob.Matcher) []string {
+	keys := []string{}
+	s.data.ForEach(func(key string, _ string) bool {
+		if !s.isExpired(key) && match(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// Range возвращает пары ключ-значение из отрезка [start, end], не больше limit, если limit > 0
+func (s *storage) Range(ctx context.Context, start string, end string, reverse bool, limit int) ([][2]string, error) {
+	ordered, ok := s.data.(orderedKeyspace)
+	if !ok {
+		return nil, ErrRangeNotSupported
+	}
+
+	pairs := [][2]string{}
+	ordered.Range(start, end, reverse, func(key string, value string) bool {
+		if s.isExpired(key) {
+			return true
+		}
+		pairs = append(pairs, [2]string{key, value})
+		return limit <= 0 || len(pairs) < limit
+	})
+	return pairs, nil
+}
+
+// Len возвращает количество ключей вместе с истекшими, но еще не удаленными
+func (s *storage) Len(ctx context.Context) int {
+	return s.data.Len()
+}
+
+// Dump возвращает ключи как команды SET с абсолютным временем истечения, истекшие ключи пропускаются
+func (s *storage) Dump(ctx context.Context) []command.Command {
+	cmds := make([]command.Command, 0, s.data.Len())
+	s.data.ForEach(func(key string, value string) bool {
+		if s.isExpired(key) {
+			return true
+		}
+		cmds = append(cmds, command.Command{
+			Type:   command.CommandSET,
+			Name:   key,
+			Set:    command.SetArgs{Value: value},
+			Expire: command.ExpireArgs{Deadline: s.expires[key]},
+		})
+		return true
+	})
+	return cmds
+}
+
+// Checksum складывает хеши всех ключей, поэтому сумма не зависит от порядка обхода
+func (s *storage) Checksum(ctx context.Context) uint64 {
+	sum := uint64(0)
+	h := fnv.New64a()
+	s.data.ForEach(func(key string, value string) bool {
+		if s.isExpired(key) {
+			return true
+		}
+		h.Reset()
+		_, _ = io.WriteString(h, key)
+		_, _ = h.Write([]byte{0})
+		_, _ = io.WriteString(h, value)
+		_ = binary.Write(h, binary.BigEndian, s.expires[key])
+		sum += h.Sum64()
+		return true
+	})
+	return sum
+}
+
+// deleteExpired проверяет не больше limit ключей со временем жизни и удаляет истекшие
+func (s *storage) deleteExpired(limit int) (checked int, deleted []command.Command) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.data, name)
+	now := s.now().UnixMilli()
+	for name, deadline := range s.expires {
+		if checked == limit {
+			break
+		}
+		checked++
+		if deadline <= now {
+			s.remove(name)
+			s.notify(event.Expire, name, "")
+			deleted = append(deleted, command.Command{
+				Type: command.CommandDEL,
+				Name: name,
+			})
+		}
+	}
+	return checked, deleted
+}
+
+// remove удаляет ключ и сообщает, что он был
+func (s *storage) remove(name string) bool {
+	if _, ok := s.data.Get(name); !ok {
+		return false
+	}
+	s.removeValue(name)
+	delete(s.expires, name)
+
+	s.version++
+	s.deletedAt = s.version
+	delete(s.versions, name)
+	return true
+}
 
-	return nil
+func (s *storage) notify(typ event.Type, name string, value string) {
+	if s.notifier == nil {
+		return
+	}
+	s.notifier.Notify(event.Event{
+		Type:  typ,
+		Key:   name,
+		Value: value,
+	})
+}
+
+func (s *storage) bump(name string) {
+	s.version++
+	s.versions[name] = s.version
+}
+
+// Watch возвращает версии ключей для WATCH
+func (s *storage) Watch(ctx context.Context, keys []string) map[string]command.WatchedKey {
+	watched := make(map[string]command.WatchedKey, len(keys))
+	for _, key := range keys {
+		version := s.versions[key]
+		if version != 0 && s.isExpired(key) {
+			version = 0
+		}
+		watched[key] = command.WatchedKey{
+			Version: version,
+			Since:   s.version,
+		}
+	}
+	return watched
+}
+
+// changed сообщает, что хотя бы один ключ изменился после WATCH.
+// Для отсутствовавшего ключа любое удаление после WATCH считается изменением,
+// так как удаленные ключи не хранят версию.
+func (s *storage) changed(watched map[string]command.WatchedKey) bool {
+	for key, w := range watched {
+		version := s.versions[key]
+		if version != 0 && s.isExpired(key) {
+			version = 0
+		}
+		if version != w.Version {
+			return true
+		}
+		if version == 0 && s.deletedAt > w.Since {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *storage) isExpired(name string) bool {
+	deadline, ok := s.expires[name]
+	if !ok {
+		return false
+	}
+	return deadline <= s.now().UnixMilli()
 }
@@ -1,13 +1,169 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
 	"inmem-db/internal/server/tcp"
 )
 
-func NewMasterServer(addr string, segmenter SegmentsGetter) *tcp.Server {
-	cfg := tcp.DefaultConfig
-	cfg.Address = addr
+var (
+	ErrNotEnoughReplicas = errors.New("not enough replicas")
+	ErrAckTimeout        = errors.New("replica ack timeout")
+)
+
+const defaultAckTimeout = time.Second
+
+// masterState - данные мастера, которые нужны репликам, реализуется Storage
+type masterState interface {
+	// Checksum возвращает последний записанный сегмент и контрольную сумму данных на нем
+	Checksum(ctx context.Context) (int64, uint64, error)
+	// State передает в start сегмент, на котором сделано состояние, и количество команд,
+	// затем команды состояния в fn
+	State(ctx context.Context, start func(id int64, size uint64) error, fn func(cmd command.Command) error) error
+}
+
+type masterServer struct {
+	cfg    config.Replication
+	server *tcp.Server
+	acks   *replicaAcks
+	// state - Storage мастера, задается в New
+	state masterState
+}
+
+func NewMasterServer(cfg config.Replication, segmenter SegmentsGetter) (*masterServer, error) {
+	if cfg.MinReplicaAcks < 0 {
+		return nil, fmt.Errorf("invalid min replica acks: %d", cfg.MinReplicaAcks)
+	}
+	// реплика подтверждает сегменты только в потоке
+	if cfg.MinReplicaAcks > 0 && cfg.Mode == config.ReplicationPoll {
+		return nil, fmt.Errorf("min replica acks require %s mode", config.ReplicationStream)
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = defaultAckTimeout
+	}
+	switch cfg.AckPolicy {
+	case config.AckFail, config.AckDegrade:
+	case "":
+		cfg.AckPolicy = config.AckFail
+	default:
+		return nil, fmt.Errorf("unknown ack policy: %q", cfg.AckPolicy)
+	}
+
+	serverCfg := tcp.DefaultConfig
+	serverCfg.Address = cfg.MasterAddress
+
+	m := &masterServer{
+		cfg:  cfg,
+		acks: newReplicaAcks(),
+	}
+	m.server = tcp.NewServer(serverCfg, func(r io.Reader, w io.Writer) tcp.Starter {
+		return senderFactory(segmenter, m.state, m.acks)(r, w)
+	})
+	return m, nil
+}
+
+// Listen занимает адрес сервера репликации до Start
+func (m *masterServer) Listen() error {
+	return m.server.Listen()
+}
+
+func (m *masterServer) Start(ctx context.Context) error {
+	return m.server.Start(ctx)
+}
+
+// waitAcks ждет, пока MinReplicaAcks реплик подтвердят сегмент id, но не дольше AckTimeout.
+// Если подключено меньше реплик, поведение задает AckPolicy.
+func (m *masterServer) waitAcks(ctx context.Context, id int64) error {
+	n := m.cfg.MinReplicaAcks
+	if n == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.AckTimeout)
+	defer cancel()
+
+	for {
+		acked, connected, changed := m.acks.count(id)
+		if acked >= n {
+			return nil
+		}
+		if connected < n {
+			if m.cfg.AckPolicy == config.AckDegrade {
+				slog.WarnContext(ctx, "write is not replicated",
+					slog.Int64("segment_id", id),
+					slog.Int("replicas", connected),
+					slog.Int("min_replica_acks", n))
+				return nil
+			}
+			return fmt.Errorf("%w: %d connected, %d required", ErrNotEnoughReplicas, connected, n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: segment %d acked by %d of %d replicas", ErrAckTimeout, id, acked, n)
+		case <-changed:
+		}
+	}
+}
+
+// replicaAcks - последние сегменты, подтвержденные подключенными репликами
+type replicaAcks struct {
+	mu    sync.Mutex
+	acked map[*sender]int64
+	// changed закрывается и заменяется новым при каждом изменении
+	changed chan struct{}
+}
+
+func newReplicaAcks() *replicaAcks {
+	return &replicaAcks{
+		acked:   make(map[*sender]int64),
+		changed: make(chan struct{}),
+	}
+}
+
+// ack запоминает последний сегмент, подтвержденный репликой, и подключает ее
+func (a *replicaAcks) ack(s *sender, id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.acked[s] = id
+	a.notify()
+}
+
+// remove отключает реплику
+func (a *replicaAcks) remove(s *sender) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.acked[s]; !ok {
+		return
+	}
+	delete(a.acked, s)
+	a.notify()
+}
+
+// count возвращает, сколько реплик подтвердили сегмент id, сколько подключено,
+// и канал, который закроется при следующем изменении
+func (a *replicaAcks) count(id int64) (acked, connected int, changed <-chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, last := range a.acked {
+		if last >= id {
+			acked++
+		}
+	}
+	return acked, len(a.acked), a.changed
+}
 
-	server := tcp.NewServer(cfg, senderFactory(segmenter))
-	return server
+func (a *replicaAcks) notify() {
+	close(a.changed)
+	a.changed = make(chan struct{})
 }
@@ -1,17 +1,24 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
 	"inmem-db/internal/config"
 	"inmem-db/internal/domain/command"
+	"inmem-db/internal/pubsub"
 	"inmem-db/internal/storage/engine"
 	"inmem-db/internal/storage/wal"
+	"inmem-db/internal/storage/wal/decode"
+	"inmem-db/internal/storage/wal/encode"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/nettest"
+	"golang.org/x/sync/errgroup"
 )
 
 const syncTime = time.Millisecond * 10
@@ -24,13 +31,33 @@ func TestMasterReplication_SlaveGetCopy(t *testing.T) {
 	}
 
 	type test struct {
+		mode      config.ReplicationMode
 		masterCMD command.Command
 		slaveCMD  command.Command
 		slaveRes  res
 	}
 
 	tests := map[string]test{
-		"set value": {
+		"set value poll": {
+			mode: config.ReplicationPoll,
+			masterCMD: command.Command{
+				Type: command.CommandSET,
+				Name: "test_name01928",
+				Set: command.SetArgs{
+					Value: "test_value90812",
+				},
+			},
+			slaveCMD: command.Command{
+				Type: command.CommandGET,
+				Name: "test_name01928",
+			},
+			slaveRes: res{
+				s:   "test_value90812",
+				err: nil,
+			},
+		},
+		"set value stream": {
+			mode: config.ReplicationStream,
 			masterCMD: command.Command{
 				Type: command.CommandSET,
 				Name: "test_name01928",
@@ -53,19 +80,17 @@ func TestMasterReplication_SlaveGetCopy(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 			ctx := t.Context()
-			help := setupTest(t)
+			help := setupTest(t, test.mode)
 
 			_, err := help.master.Do(ctx, test.masterCMD)
 			require.NoError(t, err)
 
-			select {
-			case <-time.After(syncTime):
-			case <-ctx.Done():
-			}
-
-			s, err := help.slave.Do(ctx, test.slaveCMD)
-			assert.Equal(t, test.slaveRes.s, s)
-			assert.Equal(t, test.slaveRes.err, err)
+			// реплика могла подключиться раньше, чем мастер начал слушать порт
+			assert.EventuallyWithT(t, func(c *assert.CollectT) {
+				s, err := help.slave.Do(ctx, test.slaveCMD)
+				assert.Equal(c, test.slaveRes.s, s)
+				assert.Equal(c, test.slaveRes.err, err)
+			}, time.Second, syncTime)
 		})
 	}
 }
@@ -73,12 +98,15 @@ func TestMasterReplication_SlaveGetCopy(t *testing.T) {
 type testHelper struct {
 	master *Storage
 	slave  *Storage
+
+	// slaveOptions - опции engine реплики
+	slaveOptions []engine.Option
 }
 
-func setupTest(t *testing.T) testHelper {
+func setupTest(t *testing.T, mode config.ReplicationMode) testHelper {
 	t.Helper()
 	th := testHelper{}
-	th.newMaster(t)
+	th.newMaster(t, mode, config.Replication{})
 
 	ctx := t.Context()
 	go th.master.Start(ctx)
@@ -87,7 +115,8 @@ func setupTest(t *testing.T) testHelper {
 	return th
 }
 
-func (th *testHelper) newMaster(t *testing.T) {
+// newMaster создает мастер с настройками подтверждений из cfg и реплику к нему
+func (th *testHelper) newMaster(t *testing.T, mode config.ReplicationMode, cfg config.Replication) {
 	masterEngine := engine.New()
 	walConfig := config.WAL{
 		BatchSize:      5,
@@ -99,19 +128,17 @@ func (th *testHelper) newMaster(t *testing.T) {
 	w, err := wal.New(walConfig)
 	require.NoError(t, err)
 
-	l, err := nettest.NewLocalListener("tcp")
+	addr := localAddr(t)
+	cfg.MasterAddress = addr
+	masterServer, err := NewMasterServer(cfg, w)
 	require.NoError(t, err)
-	addr := l.Addr().String()
-	require.NoError(t, l.Close())
-
-	masterServer := NewMasterServer(addr, w)
 
 	th.master = New(masterEngine, w, WithMasterServer(masterServer))
-	th.newSlave(t, addr)
+	th.newSlave(t, addr, mode)
 }
 
-func (th *testHelper) newSlave(t *testing.T, masterAddress string) {
-	e := engine.New()
+func (th *testHelper) newSlave(t *testing.T, masterAddress string, mode config.ReplicationMode) {
+	e := engine.New(th.slaveOptions...)
 	walConfig := config.WAL{
 		BatchSize:      5,
 		BatchTimeout:   syncTime,
@@ -125,9 +152,579 @@ func (th *testHelper) newSlave(t *testing.T, masterAddress string) {
 	cfg := config.Replication{
 		ReplicaType:   config.SlaveReplica,
 		MasterAddress: masterAddress,
+		Mode:          mode,
 		SyncInterval:  syncTime / 2,
+		ListenAddress: localAddr(t),
 	}
 
 	client := NewReplicationClient(cfg, w, e)
 	th.slave = New(e, w, WithReplicationClient(client))
 }
+
+// localAddr возвращает свободный локальный адрес
+func localAddr(t *testing.T) string {
+	l, err := nettest.NewLocalListener("tcp")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+func TestSender_stream(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	w, err := wal.New(config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer w.Close()
+	set := command.Command{Type: command.CommandSET, Name: "a", Set: command.SetArgs{Value: "1"}}
+	require.NoError(t, w.Save(ctx, set))
+	set.Set.Value = "2"
+	require.NoError(t, w.Save(ctx, set))
+
+	master, replica := net.Pipe()
+	defer replica.Close()
+	go func() {
+		defer master.Close()
+		_ = senderFactory(w, nil, newReplicaAcks())(master, master).Start(ctx)
+	}()
+
+	for _, v := range []int64{streamRequest, 1, 20} {
+		require.NoError(t, encode.WriteID(replica, v))
+	}
+	receive := func() []wal.Segment {
+		require.NoError(t, replica.SetReadDeadline(time.Now().Add(time.Second)))
+		count, err := decode.ReadSize(replica)
+		require.NoError(t, err)
+		segments := make([]wal.Segment, count)
+		require.NoError(t, decodeSegments(replica, w.DecodeSegment, segments))
+		return segments
+	}
+
+	// сначала записанное до подписки, затем heartbeat, пока новых сегментов нет
+	segments := receive()
+	require.Len(t, segments, 1)
+	assert.Equal(t, []command.Command{set}, wal.SegmentCommands(segments[0]))
+	assert.Empty(t, receive())
+
+	require.NoError(t, w.Save(ctx, command.Command{Type: command.CommandDEL, Name: "a"}))
+	segments = receive()
+	if len(segments) == 0 {
+		// heartbeat мог уйти раньше записи
+		segments = receive()
+	}
+	require.Len(t, segments, 1)
+	assert.Equal(t, wal.ID(3), segments[0].ID)
+}
+
+func TestMasterReplication_fullSync(t *testing.T) {
+	t.Parallel()
+
+	set := func(name, value string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: value}}
+	}
+
+	type test struct {
+		// prepare пишет данные на мастер и реплику до их запуска
+		prepare func(t *testing.T, th testHelper)
+	}
+
+	tests := map[string]test{
+		"new replica": {
+			prepare: func(t *testing.T, th testHelper) {
+				for i := range 10 {
+					_, err := th.master.Do(t.Context(), set(fmt.Sprintf("name%d", i), "value"))
+					require.NoError(t, err)
+				}
+			},
+		},
+		"behind snapshot": {
+			prepare: func(t *testing.T, th testHelper) {
+				ctx := t.Context()
+				_, err := th.master.Do(ctx, set("name", "old"))
+				require.NoError(t, err)
+				require.NoError(t, th.slave.client.applySegments(ctx, th.master.w.(*wal.WAL).SegmentsAfter(0)))
+
+				_, err = th.master.Do(ctx, set("name", "new"))
+				require.NoError(t, err)
+				require.NoError(t, th.master.Snapshot(ctx))
+			},
+		},
+		"writes after snapshot": {
+			prepare: func(t *testing.T, th testHelper) {
+				ctx := t.Context()
+				_, err := th.master.Do(ctx, set("name", "old"))
+				require.NoError(t, err)
+				require.NoError(t, th.master.Snapshot(ctx))
+
+				// состояние передается из снимка, остальное реплика получает сегментами
+				_, err = th.master.Do(ctx, set("name", "new"))
+				require.NoError(t, err)
+				_, err = th.master.Do(ctx, set("other", "value"))
+				require.NoError(t, err)
+			},
+		},
+		"several batches": {
+			prepare: func(t *testing.T, th testHelper) {
+				// состояние больше пачки применения реплики
+				mset := command.Command{Type: command.CommandMSET}
+				for i := range 2*fullSyncBatch + 10 {
+					mset.Keys = append(mset.Keys, fmt.Sprintf("name%d", i))
+					mset.Values = append(mset.Values, "value")
+				}
+				_, err := th.master.Do(t.Context(), mset)
+				require.NoError(t, err)
+			},
+		},
+		"ahead of master": {
+			prepare: func(t *testing.T, th testHelper) {
+				ctx := t.Context()
+				other := engine.New()
+				st := New(other, th.slave.w)
+				for i := range 5 {
+					_, err := st.Do(ctx, set(fmt.Sprintf("stale%d", i), "value"))
+					require.NoError(t, err)
+				}
+				require.NoError(t, th.slave.e.Load(ctx, other.Dump(ctx)))
+
+				_, err := th.master.Do(ctx, set("name", "value"))
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		for _, mode := range []config.ReplicationMode{config.ReplicationPoll, config.ReplicationStream} {
+			t.Run(name+" "+string(mode), func(t *testing.T) {
+				t.Parallel()
+
+				ctx := t.Context()
+				th := testHelper{}
+				th.newMaster(t, mode, config.Replication{})
+				tc.prepare(t, th)
+
+				snapshotID := th.master.w.(*wal.WAL).SnapshotID()
+				go th.master.Start(ctx)
+				go th.slave.Start(ctx)
+
+				masterID, masterSum, err := th.master.Checksum(ctx)
+				require.NoError(t, err)
+				assert.EventuallyWithT(t, func(c *assert.CollectT) {
+					id, sum, err := th.slave.Checksum(ctx)
+					assert.NoError(c, err)
+					assert.Equal(c, masterID, id)
+					assert.Equal(c, masterSum, sum)
+				}, time.Second, syncTime)
+				// полная синхронизация не сжимает журнал мастера
+				assert.Equal(t, snapshotID, th.master.w.(*wal.WAL).SnapshotID())
+
+				// реплика продолжает получать сегменты после состояния
+				_, err = th.master.Do(ctx, set("next", "value"))
+				require.NoError(t, err)
+				assert.EventuallyWithT(t, func(c *assert.CollectT) {
+					s, err := th.slave.Do(ctx, command.Command{Type: command.CommandGET, Name: "next"})
+					assert.NoError(c, err)
+					assert.Equal(c, "value", s)
+				}, time.Second, syncTime)
+			})
+		}
+	}
+}
+
+func TestMasterReplication_keyspaceEvents(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []config.ReplicationMode{config.ReplicationPoll, config.ReplicationStream} {
+		t.Run(string(mode), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			broker, err := pubsub.New(config.PubSub{})
+			require.NoError(t, err)
+			notifier, err := pubsub.NewNotifier(broker, config.Notifications{Events: []string{"set", "del"}})
+			require.NoError(t, err)
+			sub := broker.NewSubscriber()
+			defer sub.Close()
+			sub.Subscribe(pubsub.KeyspacePrefix + "name")
+
+			th := testHelper{slaveOptions: []engine.Option{engine.WithNotifier(notifier)}}
+			th.newMaster(t, mode, config.Replication{})
+			go th.master.Start(ctx)
+			go th.slave.Start(ctx)
+
+			// первое состояние новая реплика загружает целиком, без событий
+			_, err = th.master.Do(ctx, command.Command{Type: command.CommandSET, Name: "other", Set: command.SetArgs{Value: "value"}})
+			require.NoError(t, err)
+			assert.EventuallyWithT(t, func(c *assert.CollectT) {
+				s, err := th.slave.Do(ctx, command.Command{Type: command.CommandGET, Name: "other"})
+				assert.NoError(c, err)
+				assert.Equal(c, "value", s)
+			}, time.Second, syncTime)
+
+			// следующие изменения от мастера видны подписчикам реплики
+			for _, cmd := range []command.Command{
+				{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "value"}},
+				{Type: command.CommandDEL, Name: "name"},
+			} {
+				_, err = th.master.Do(ctx, cmd)
+				require.NoError(t, err)
+			}
+
+			for _, want := range []string{"set", "del"} {
+				select {
+				case m := <-sub.Messages():
+					assert.Equal(t, pubsub.KeyspacePrefix+"name", m.Channel)
+					assert.Equal(t, want, m.Payload)
+				case <-time.After(time.Second):
+					require.Fail(t, "no keyspace event on replica", want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplicationClient_gap(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	master, err := wal.New(config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer master.Close()
+	for i := range 3 {
+		require.NoError(t, master.Save(ctx, command.Command{
+			Type: command.CommandSET,
+			Name: fmt.Sprintf("name%d", i),
+			Set:  command.SetArgs{Value: "value"},
+		}))
+	}
+	segments := master.SegmentsAfter(0)
+	require.Len(t, segments, 3)
+
+	help := testHelper{}
+	help.newSlave(t, "", config.ReplicationStream)
+	client := help.slave.client
+
+	// сегмент 3 без сегмента 2 не применяется, повтор сегмента 1 пропускается
+	err = client.applySegments(ctx, []wal.Segment{segments[0], segments[2]})
+	require.ErrorIs(t, err, ErrReplicationGap)
+	require.NoError(t, client.applySegments(ctx, segments))
+	assert.EqualValues(t, 3, client.wal.LastSegmentID())
+
+	_, err = help.slave.Do(ctx, command.Command{Type: command.CommandGET, Name: "name2"})
+	require.NoError(t, err)
+}
+
+func TestReplicationClient_verify(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	help := setupTest(t, config.ReplicationStream)
+	_, err := help.master.Do(ctx, command.Command{
+		Type: command.CommandSET,
+		Name: "name",
+		Set:  command.SetArgs{Value: "value"},
+	})
+	require.NoError(t, err)
+
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.EqualValues(c, 1, help.slave.client.wal.LastSegmentID())
+	}, time.Second, syncTime)
+	require.NoError(t, help.slave.client.verify(ctx))
+
+	// расхождение, которого нет в журнале
+	require.NoError(t, help.slave.e.Replay(ctx, command.Command{
+		Type: command.CommandSET,
+		Name: "name",
+		Set:  command.SetArgs{Value: "other"},
+	}))
+	require.ErrorIs(t, help.slave.client.verify(ctx), ErrChecksumMismatch)
+}
+
+func TestMasterReplication_acks(t *testing.T) {
+	t.Parallel()
+
+	set := command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "value"}}
+
+	type test struct {
+		mode config.ReplicationMode
+		cfg  config.Replication
+		// replica - как к мастеру подключается реплика
+		replica func(t *testing.T, th testHelper)
+
+		err error
+	}
+
+	startSlave := func(t *testing.T, th testHelper) {
+		go th.slave.Start(t.Context())
+	}
+	// silent подписывается на поток, но не подтверждает сегменты
+	silent := func(t *testing.T, th testHelper) {
+		var conn net.Conn
+		require.EventuallyWithT(t, func(c *assert.CollectT) {
+			var err error
+			conn, err = net.Dial("tcp", th.slave.client.cfg.MasterAddress)
+			assert.NoError(c, err)
+		}, time.Second, syncTime)
+		t.Cleanup(func() { conn.Close() })
+		for _, v := range []int64{streamRequest, 0, 20} {
+			require.NoError(t, encode.WriteID(conn, v))
+		}
+	}
+
+	tests := map[string]test{
+		"stream replica acks": {
+			mode:    config.ReplicationStream,
+			cfg:     config.Replication{MinReplicaAcks: 1},
+			replica: startSlave,
+		},
+		"poll replica is not counted": {
+			mode:    config.ReplicationPoll,
+			cfg:     config.Replication{MinReplicaAcks: 1},
+			replica: startSlave,
+			err:     ErrNotEnoughReplicas,
+		},
+		"poll replica after full sync is not counted": {
+			mode: config.ReplicationPoll,
+			cfg:  config.Replication{MinReplicaAcks: 1, AckTimeout: syncTime * 5},
+			replica: func(t *testing.T, th testHelper) {
+				// новая реплика загружает состояние мастера целиком
+				_, err := th.master.Do(t.Context(), set)
+				require.ErrorIs(t, err, ErrNotEnoughReplicas)
+				startSlave(t, th)
+				require.EventuallyWithT(t, func(c *assert.CollectT) {
+					s, err := th.slave.Do(t.Context(), command.Command{Type: command.CommandGET, Name: "name"})
+					assert.NoError(c, err)
+					assert.Equal(c, "value", s)
+				}, time.Second, syncTime)
+			},
+			err: ErrNotEnoughReplicas,
+		},
+		"no replicas fail": {
+			mode:    config.ReplicationStream,
+			cfg:     config.Replication{MinReplicaAcks: 1},
+			replica: func(*testing.T, testHelper) {},
+			err:     ErrNotEnoughReplicas,
+		},
+		"no replicas degrade": {
+			mode:    config.ReplicationStream,
+			cfg:     config.Replication{MinReplicaAcks: 1, AckPolicy: config.AckDegrade},
+			replica: func(*testing.T, testHelper) {},
+		},
+		"replica does not ack": {
+			mode:    config.ReplicationStream,
+			cfg:     config.Replication{MinReplicaAcks: 1, AckTimeout: syncTime * 5},
+			replica: silent,
+			err:     ErrAckTimeout,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			th := testHelper{}
+			th.newMaster(t, tc.mode, tc.cfg)
+			go th.master.Start(ctx)
+			tc.replica(t, th)
+
+			if tc.err == nil {
+				// реплика могла еще не подключиться
+				assert.EventuallyWithT(t, func(c *assert.CollectT) {
+					_, err := th.master.Do(ctx, set)
+					assert.NoError(c, err)
+				}, time.Second, syncTime)
+				return
+			}
+
+			assert.EventuallyWithT(t, func(c *assert.CollectT) {
+				_, err := th.master.Do(ctx, set)
+				assert.ErrorIs(c, err, tc.err)
+			}, time.Second, syncTime)
+			// запись уже применена на мастере
+			s, err := th.master.Do(ctx, command.Command{Type: command.CommandGET, Name: "name"})
+			require.NoError(t, err)
+			assert.Equal(t, "value", s)
+		})
+	}
+}
+
+func TestNewMasterServer_invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewMasterServer(config.Replication{MinReplicaAcks: -1}, nil)
+	require.Error(t, err)
+	_, err = NewMasterServer(config.Replication{AckPolicy: "wait"}, nil)
+	require.Error(t, err)
+	_, err = NewMasterServer(config.Replication{MinReplicaAcks: 1, Mode: config.ReplicationPoll}, nil)
+	require.Error(t, err)
+}
+
+func TestStorage_ReplicaOf(t *testing.T) {
+	t.Parallel()
+
+	set := func(name, value string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: value}}
+	}
+	get := func(name string) command.Command {
+		return command.Command{Type: command.CommandGET, Name: name}
+	}
+
+	for _, mode := range []config.ReplicationMode{config.ReplicationPoll, config.ReplicationStream} {
+		t.Run(string(mode), func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			th := setupTest(t, mode)
+
+			_, err := th.master.Do(ctx, set("name", "value"))
+			require.NoError(t, err)
+			assert.EventuallyWithT(t, func(c *assert.CollectT) {
+				s, err := th.slave.Do(ctx, get("name"))
+				assert.NoError(c, err)
+				assert.Equal(c, "value", s)
+			}, time.Second, syncTime)
+
+			// записи идут на оба узла, пока они меняют роли
+			stop := make(chan struct{})
+			grp := errgroup.Group{}
+			for i, node := range []*Storage{th.master, th.slave} {
+				grp.Go(func() error {
+					for j := 0; ; j++ {
+						select {
+						case <-stop:
+							return nil
+						default:
+						}
+						_, err := node.Do(ctx, set(fmt.Sprintf("load%d", i), fmt.Sprint(j)))
+						if err != nil && !errors.Is(err, ErrReadOnly) {
+							return err
+						}
+					}
+				})
+			}
+
+			require.NoError(t, th.slave.ReplicaOf(ctx, ""))
+			_, err = th.slave.Do(ctx, set("promoted", "value"))
+			require.NoError(t, err)
+
+			// запись старого мастера не попадает на новый и пропадает после REPLICAOF
+			_, err = th.master.Do(ctx, set("diverged", "value"))
+			require.NoError(t, err)
+			require.NoError(t, th.master.ReplicaOf(ctx, th.slave.server.cfg.MasterAddress))
+			_, err = th.master.Do(ctx, set("name", "other"))
+			require.ErrorIs(t, err, ErrReadOnly)
+
+			close(stop)
+			require.NoError(t, grp.Wait())
+
+			id, sum, err := th.slave.Checksum(ctx)
+			require.NoError(t, err)
+			assert.EventuallyWithT(t, func(c *assert.CollectT) {
+				masterID, masterSum, err := th.master.Checksum(ctx)
+				assert.NoError(c, err)
+				assert.Equal(c, id, masterID)
+				assert.Equal(c, sum, masterSum)
+			}, time.Second, syncTime)
+
+			s, err := th.master.Do(ctx, get("promoted"))
+			require.NoError(t, err)
+			assert.Equal(t, "value", s)
+			_, err = th.master.Do(ctx, get("diverged"))
+			assert.ErrorIs(t, err, engine.ErrNotFound)
+
+			// повышенный обратно узел снова принимает записи
+			require.NoError(t, th.master.ReplicaOf(ctx, ""))
+			_, err = th.master.Do(ctx, set("name", "other"))
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestStorage_ReplicaOf_listenBusy(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	th := setupTest(t, config.ReplicationStream)
+	set := command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "value"}}
+
+	// адрес сервера репликации занят, повышение не удается, узел остается репликой
+	l, err := net.Listen("tcp", th.slave.client.cfg.ListenAddress)
+	require.NoError(t, err)
+	require.Error(t, th.slave.ReplicaOf(ctx, ""))
+	_, err = th.slave.Do(ctx, set)
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	_, err = th.master.Do(ctx, set)
+	require.NoError(t, err)
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		s, err := th.slave.Do(ctx, command.Command{Type: command.CommandGET, Name: "name"})
+		assert.NoError(c, err)
+		assert.Equal(c, "value", s)
+	}, time.Second, syncTime)
+
+	require.NoError(t, l.Close())
+	require.NoError(t, th.slave.ReplicaOf(ctx, ""))
+	_, err = th.slave.Do(ctx, set)
+	require.NoError(t, err)
+}
+
+func TestMasterReplication_ackOwnSegment(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	th := testHelper{}
+	th.newMaster(t, config.ReplicationStream, config.Replication{MinReplicaAcks: 1, AckTimeout: time.Minute})
+	go th.master.Start(ctx)
+
+	// реплика подтверждает сегменты вручную
+	var conn net.Conn
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		var err error
+		conn, err = net.Dial("tcp", th.slave.client.cfg.MasterAddress)
+		assert.NoError(c, err)
+	}, time.Second, syncTime)
+	t.Cleanup(func() { conn.Close() })
+	for _, v := range []int64{streamRequest, 0, 20} {
+		require.NoError(t, encode.WriteID(conn, v))
+	}
+	require.Eventually(t, func() bool {
+		_, connected, _ := th.master.server.acks.count(0)
+		return connected == 1
+	}, time.Second, time.Millisecond)
+
+	do := func(name string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := th.master.Do(ctx, command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "value"}})
+			done <- err
+		}()
+		return done
+	}
+	first := do("first")
+	require.Eventually(t, func() bool { return th.master.w.LastSegmentID() == 1 }, time.Second, time.Millisecond)
+	second := do("second")
+	require.Eventually(t, func() bool { return th.master.w.LastSegmentID() == 2 }, time.Second, time.Millisecond)
+
+	// первая запись ждет только свой сегмент, хотя вторая уже записана
+	require.NoError(t, encode.WriteID(conn, 1))
+	require.NoError(t, <-first)
+	select {
+	case err := <-second:
+		t.Fatalf("second write returned before ack: %v", err)
+	case <-time.After(syncTime):
+	}
+
+	require.NoError(t, encode.WriteID(conn, 2))
+	require.NoError(t, <-second)
+}
@@ -1,19 +1,32 @@
 package storage
 
-import "inmem-db/internal/server/tcp"
+import (
+	"time"
+)
 
-type option func(*Storage)
+type Option func(*Storage)
 
-func WithReplicationClient(client *replicationClient) option {
+func WithReplicationClient(client *replicationClient) Option {
 	return func(s *Storage) {
 		s.isSlave = true
 		s.client = client
 	}
 }
 
-func WithMasterServer(masterServer *tcp.Server) option {
+func WithMasterServer(masterServer *masterServer) Option {
 	return func(s *Storage) {
 		s.isSlave = false
 		s.server = masterServer
 	}
 }
+
+// WithSnapshotPolicy включает автоматические снимки: каждые interval
+// и когда журнал после прошлого снимка вырос до walSize байт
+func WithSnapshotPolicy(interval time.Duration, walSize uint64) Option {
+	return func(s *Storage) {
+		s.snapshots = snapshotPolicy{
+			interval: interval,
+			walSize:  walSize,
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package storage
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"inmem-db/internal/client"
 	"inmem-db/internal/config"
 	"inmem-db/internal/domain/command"
 	"inmem-db/internal/storage/wal"
@@ -16,157 +20,409 @@ import (
 	"inmem-db/internal/storage/wal/encode"
 )
 
-var ErrReadOnly = errors.New("replication is read only")
+var (
+	ErrReadOnly = errors.New("replication is read only")
+	// ErrReplicationGap - сегмент от мастера идет не сразу после последнего сегмента реплики
+	ErrReplicationGap   = errors.New("replication gap")
+	ErrChecksumMismatch = errors.New("replica checksum mismatch")
+)
 
-type replicationClient struct {
-	client *client.Client
-	ticker *time.Ticker
+const (
+	// streamRequest передается вместо ID сегмента: реплика подписывается на поток сегментов.
+	// За ним следуют ID последнего сегмента реплики и интервал heartbeat в миллисекундах.
+	streamRequest int64 = -1
+	// checksumRequest запрашивает последний сегмент мастера и контрольную сумму данных на нем
+	checksumRequest int64 = -2
+	// fullSyncRequest передается вместо ID последнего сегмента реплики:
+	// реплика просит состояние мастера, даже если могла бы догнать его сегментами
+	fullSyncRequest int64 = -3
+	// fullSyncMarker передается вместо количества сегментов: за ним следует состояние мастера
+	fullSyncMarker uint32 = math.MaxUint32
+
+	defaultSyncInterval      = time.Second
+	defaultHeartbeatInterval = time.Second
+	// heartbeatMisses - сколько интервалов heartbeat реплика ждет сообщения от мастера
+	heartbeatMisses = 3
+	// fullSyncBatch - сколько команд состояния реплика применяет за раз при полной синхронизации
+	fullSyncBatch = 1024
+)
 
-	recv *io.PipeReader
-	send *io.PipeWriter
+type replicationClient struct {
+	cfg config.Replication
 
 	wal segmentManager
 	e   Engine
-
-	cleanup func()
+	// mu - блокировка Storage, снимок не должен видеть сегмент без его применения
+	mu sync.Locker
+	// checksum - контрольная сумма Storage, задается в New
+	checksum func(ctx context.Context) (int64, uint64, error)
+	// resync - история реплики могла разойтись с мастером, сначала нужна полная синхронизация
+	resync atomic.Bool
 }
 
 type segmentManager interface {
 	LastSegmentID() int64
 	SaveSegment(segment wal.Segment) error
+	WaitSynced() error
+	DecodeSegment(r io.Reader) (wal.Segment, error)
+	InstallSnapshot(ctx context.Context, id wal.ID, size uint64, next func() (command.Command, error)) error
 }
 
 func NewReplicationClient(cfg config.Replication, wal segmentManager, e Engine) *replicationClient {
-	sendReader, sendWriter := io.Pipe()
-	recvReader, recvWriter := io.Pipe()
+	if cfg.Mode == "" {
+		cfg.Mode = config.ReplicationStream
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = defaultSyncInterval
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultHeartbeatInterval
+	}
 
-	client := client.New(
-		config.Client{
-			Address: cfg.MasterAddress,
-		}, sendReader, recvWriter)
+	return &replicationClient{
+		cfg: cfg,
+		wal: wal,
+		e:   e,
+		mu:  &sync.Mutex{},
+	}
+}
+
+// Start получает сегменты от мастера, пока не отменен ctx.
+// После обрыва соединения реплика переподключается через SyncInterval.
+func (r *replicationClient) Start(ctx context.Context) error {
+	for {
+		err := r.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.ErrorContext(ctx, "sync with master", slog.String("error", err.Error()))
 
-	ticker := time.NewTicker(cfg.SyncInterval)
-	cleanup := func() {
-		recvReader.Close()
-		recvWriter.Close()
-		sendReader.Close()
-		sendWriter.Close()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.cfg.SyncInterval):
+		}
 	}
+}
 
-	return &replicationClient{
-		client: client,
-		ticker: ticker,
+func (r *replicationClient) connect(ctx context.Context) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", r.cfg.MasterAddress)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	// закрытие соединения прерывает ожидание ответа мастера
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	buffered := bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+	slog.InfoContext(ctx, "connect to master",
+		slog.String("addr", r.cfg.MasterAddress),
+		slog.String("mode", string(r.cfg.Mode)))
+
+	switch r.cfg.Mode {
+	case config.ReplicationPoll:
+		return r.poll(ctx, buffered)
+	case config.ReplicationStream:
+		return r.stream(ctx, buffered)
+	}
+	return fmt.Errorf("unknown replication mode: %q", r.cfg.Mode)
+}
+
+// poll запрашивает новые сегменты раз в SyncInterval
+func (r *replicationClient) poll(ctx context.Context, conn net.Conn) error {
+	t := time.NewTicker(r.cfg.SyncInterval)
+	defer t.Stop()
 
-		recv: recvReader,
-		send: sendWriter,
-		wal:  wal,
-		e:    e,
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
 
-		cleanup: cleanup,
+		slog.DebugContext(ctx, "sync master")
+		err := encode.WriteID(conn, r.after())
+		if err != nil {
+			return fmt.Errorf("write segment id: %w", err)
+		}
+		err = r.receive(ctx, conn)
+		if err != nil {
+			return err
+		}
 	}
 }
 
-func (r *replicationClient) Start(ctx context.Context) error {
-	go func() {
-		defer r.ticker.Stop()
-		defer r.cleanup()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+// stream подписывается на сегменты после последнего и применяет их по мере прихода,
+// подтверждая мастеру последний сохраненный сегмент.
+// Пустая пачка - heartbeat мастера, без него соединение считается потерянным.
+func (r *replicationClient) stream(ctx context.Context, conn net.Conn) error {
+	request := []int64{streamRequest, r.after(), r.cfg.HeartbeatInterval.Milliseconds()}
+	for _, v := range request {
+		err := encode.WriteID(conn, v)
+		if err != nil {
+			return fmt.Errorf("write stream request: %w", err)
+		}
+	}
+
+	acked := r.wal.LastSegmentID()
+	for {
+		err := conn.SetReadDeadline(time.Now().Add(heartbeatMisses * r.cfg.HeartbeatInterval))
+		if err != nil {
+			return fmt.Errorf("set deadline: %w", err)
+		}
+		err = r.receive(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// мастер ждет подтверждения сохраненных сегментов, если включена полусинхронная репликация.
+		// Сегмент подтверждается, когда сохранен так, как обещает sync_mode реплики.
+		last := r.wal.LastSegmentID()
+		if last != acked {
+			err = r.wal.WaitSynced()
+			if err != nil {
+				return fmt.Errorf("sync wal: %w", err)
 			}
-			select {
-			case <-ctx.Done():
-				return
-			case <-r.ticker.C:
-				err := r.sync(ctx)
-				if err != nil {
-					slog.ErrorContext(ctx, "sync with master", slog.String("error", err.Error()))
-				}
+			err = encode.WriteID(conn, last)
+			if err != nil {
+				return fmt.Errorf("write ack: %w", err)
 			}
+			acked = last
 		}
-	}()
-
-	return r.client.Start(ctx)
+	}
 }
 
-func (r *replicationClient) sync(ctx context.Context) error {
-	slog.DebugContext(ctx, "sync master")
+// after возвращает сегмент, после которого реплике нужны сегменты мастера
+func (r *replicationClient) after() int64 {
+	if r.resync.Load() {
+		return fullSyncRequest
+	}
+	return r.wal.LastSegmentID()
+}
 
-	segments, err := r.loadNewSegments(ctx)
+// receive читает пачку сегментов от мастера и применяет ее
+func (r *replicationClient) receive(ctx context.Context, conn net.Conn) error {
+	count, err := decode.ReadSize(conn)
 	if err != nil {
-		return fmt.Errorf("load new segments: %w", err)
+		return fmt.Errorf("read segments size: %w", err)
 	}
-
-	if len(segments) == 0 {
+	if count == fullSyncMarker {
+		return r.fullSync(ctx, conn)
+	}
+	if count == 0 {
 		return nil
 	}
 
+	segments := make([]wal.Segment, count)
+	err = decodeSegments(conn, r.wal.DecodeSegment, segments)
+	if err != nil {
+		return fmt.Errorf("load new segments: %w", err)
+	}
 	slog.DebugContext(ctx, "got new segments", slog.Int("length", len(segments)))
 
 	err = r.applySegments(ctx, segments)
 	if err != nil {
 		return fmt.Errorf("apply segments: %w", err)
 	}
-
 	return nil
 }
 
-func (r *replicationClient) countNewSegments(_ context.Context) (uint32, error) {
-	lastID := r.wal.LastSegmentID()
-	err := encode.WriteID(r.send, lastID)
+// fullSync загружает состояние мастера вместо своих данных и журнала. Команды применяются
+// пачками по мере чтения и сразу пишутся в снимок, все состояние в памяти не собирается.
+// Пока состояние загружается, данные реплики неполные, и после обрыва реплика снова
+// запросит полную синхронизацию. Срок ожидания продлевается перед каждой пачкой.
+func (r *replicationClient) fullSync(ctx context.Context, conn net.Conn) error {
+	id, err := decode.ReadID(conn)
 	if err != nil {
-		return 0, fmt.Errorf("write segment id: %w", err)
+		return fmt.Errorf("read full sync segment id: %w", err)
 	}
-
-	count, err := decode.ReadSize(r.recv)
+	count, err := decode.ReadSize(conn)
 	if err != nil {
-		return 0, fmt.Errorf("read segments size : %w", err)
+		return fmt.Errorf("read full sync size: %w", err)
 	}
-	return count, nil
-}
+	slog.InfoContext(ctx, "full sync with master",
+		slog.Int64("segment_id", id),
+		slog.Int("keys", int(count)))
 
-func (r *replicationClient) loadNewSegments(ctx context.Context) ([]wal.Segment, error) {
-	count, err := r.countNewSegments(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("count new segments: %w", err)
-	}
+	r.resync.Store(true)
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if count == 0 {
-		return []wal.Segment{}, nil
+	timeout := heartbeatMisses * r.cfg.HeartbeatInterval
+	batch := make([]command.Command, 0, min(count, fullSyncBatch))
+	loaded := false
+	apply := func() error {
+		// первая пачка заменяет прежние данные, следующие добавляются к ней
+		var err error
+		if loaded {
+			err = replayCommands(ctx, r.e, batch)
+		} else {
+			err = r.e.Load(ctx, batch)
+		}
+		if err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+		loaded = true
+		batch = batch[:0]
+		return nil
 	}
 
-	segments := make([]wal.Segment, count)
-	err = decodeSegments(r.recv, segments)
+	err = r.wal.InstallSnapshot(ctx, wal.ID(id), uint64(count), func() (command.Command, error) {
+		if len(batch) == 0 {
+			err := conn.SetReadDeadline(time.Now().Add(timeout))
+			if err != nil {
+				return command.Command{}, fmt.Errorf("set deadline: %w", err)
+			}
+		}
+		cmd, err := decode.Read(conn)
+		if err != nil {
+			return command.Command{}, fmt.Errorf("read full sync command: %w", err)
+		}
+		batch = append(batch, cmd)
+		if len(batch) == cap(batch) {
+			err = apply()
+		}
+		return cmd, err
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+	if !loaded || len(batch) > 0 {
+		err = apply()
+		if err != nil {
+			return err
+		}
 	}
-	return segments, nil
+	// дальше сроки ожидания задает режим репликации
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	r.resync.Store(false)
+	return nil
 }
 
+// applySegments применяет сегменты строго по порядку. Уже примененные пропускаются,
+// при разрыве соединение закрывается, и после переподключения реплика
+// снова запрашивает сегменты после последнего примененного.
 func (r *replicationClient) applySegments(ctx context.Context, segments []wal.Segment) error {
 	for _, s := range segments {
-		err := r.wal.SaveSegment(s)
+		last := wal.ID(r.wal.LastSegmentID())
+		if s.ID <= last {
+			continue
+		}
+		if s.ID != last+1 {
+			return fmt.Errorf("%w: segment %d after %d", ErrReplicationGap, s.ID, last)
+		}
+
+		err := r.applySegment(ctx, s)
 		if err != nil {
-			return fmt.Errorf("save segment: %w", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *replicationClient) applySegment(ctx context.Context, s wal.Segment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.wal.SaveSegment(s)
+	if err != nil {
+		return fmt.Errorf("save segment: %w", err)
+	}
+	cmds := wal.SegmentCommands(s)
+	err = replayCommands(ctx, r.e, cmds)
+	if err != nil {
+		return fmt.Errorf("do segment commands: %w", err)
+	}
+	return nil
+}
+
+// startVerify сверяет контрольную сумму с мастером раз в VerifyInterval
+func (r *replicationClient) startVerify(ctx context.Context) error {
+	t := time.NewTicker(r.cfg.VerifyInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
 		}
-		cmds := wal.SegmentCommands(s)
-		err = doCommands(ctx, r.e, cmds)
+
+		err := r.verify(ctx)
 		if err != nil {
-			return fmt.Errorf("do segment commands: %w", err)
+			slog.ErrorContext(ctx, "verify replica", slog.String("error", err.Error()))
 		}
 	}
+}
+
+// verify сравнивает контрольные суммы данных мастера и реплики. Суммы сравнимы, только
+// если реплика применила ровно те же сегменты, иначе проверка откладывается до следующего раза.
+func (r *replicationClient) verify(ctx context.Context) error {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", r.cfg.MasterAddress)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(heartbeatMisses * r.cfg.HeartbeatInterval))
+	if err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+
+	err = encode.WriteID(conn, checksumRequest)
+	if err != nil {
+		return fmt.Errorf("write checksum request: %w", err)
+	}
+	masterID, err := decode.ReadID(conn)
+	if err != nil {
+		return fmt.Errorf("read segment id: %w", err)
+	}
+	masterSum, err := decode.ReadID(conn)
+	if err != nil {
+		return fmt.Errorf("read checksum: %w", err)
+	}
+
+	id, sum, err := r.checksum(ctx)
+	if err != nil {
+		return fmt.Errorf("checksum: %w", err)
+	}
+	if id != masterID {
+		slog.DebugContext(ctx, "skip replica verification",
+			slog.Int64("segment_id", id),
+			slog.Int64("master_segment_id", masterID))
+		return nil
+	}
+	if sum != uint64(masterSum) {
+		return fmt.Errorf("%w: segment %d, master %x, replica %x", ErrChecksumMismatch, id, uint64(masterSum), sum)
+	}
+	slog.DebugContext(ctx, "replica verified", slog.Int64("segment_id", id))
 	return nil
 }
 
-func doCommands(ctx context.Context, e Engine, cmds []command.Command) error {
+func replayCommands(ctx context.Context, e Engine, cmds []command.Command) error {
 	for _, cmd := range cmds {
-		_, err := e.Do(ctx, cmd)
+		err := e.Replay(ctx, cmd)
 		if err != nil {
-			return fmt.Errorf("engine do while sync: %w", err)
+			return fmt.Errorf("engine replay: %w", err)
 		}
 	}
 	return nil
 }
+
+// bufferedConn читает соединение с мастером через буфер, команды и сегменты читаются мелкими частями
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c bufferedConn) ReadByte() (byte, error) {
+	return c.r.ReadByte()
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"inmem-db/internal/config"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ReplicaOf меняет роль без перезапуска. Пустой addr (REPLICAOF NO ONE) делает реплику
+// мастером, иначе узел становится репликой мастера addr и загружает его состояние целиком:
+// история узла могла разойтись с историей нового мастера.
+func (s *Storage) ReplicaOf(ctx context.Context, addr string) error {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+
+	if addr == "" {
+		return s.promote(ctx)
+	}
+	return s.follow(ctx, addr)
+}
+
+// promote останавливает репликацию и начинает принимать записи. Сервер репликации
+// запускается на ListenAddress, без него новый мастер не отдает сегменты репликам.
+// Если адрес занять не удалось, узел остается репликой.
+func (s *Storage) promote(ctx context.Context) error {
+	if !s.isSlave {
+		return nil
+	}
+
+	cfg := s.client.cfg
+	var server *masterServer
+	if cfg.ListenAddress != "" {
+		cfg.MasterAddress = cfg.ListenAddress
+		var err error
+		server, err = NewMasterServer(cfg, s.w)
+		if err != nil {
+			return err
+		}
+		server.state = s
+		err = server.Listen()
+		if err != nil {
+			return fmt.Errorf("replication server: %w", err)
+		}
+	} else {
+		slog.WarnContext(ctx, "replication listen address is not set, promoted master has no replicas")
+	}
+
+	// сегмент, который реплика применяет сейчас, применится целиком
+	s.stopRole()
+	s.mu.Lock()
+	s.isSlave = false
+	s.client = nil
+	s.server = server
+	s.mu.Unlock()
+	s.startRole()
+
+	slog.InfoContext(ctx, "promoted to master", slog.String("listen_address", cfg.ListenAddress))
+	return nil
+}
+
+// follow переключает узел на мастер addr. Мастер перестает принимать записи,
+// уже принятые записываются в журнал до подключения к новому мастеру.
+func (s *Storage) follow(ctx context.Context, addr string) error {
+	cfg := s.replicationConfig()
+	cfg.MasterAddress = addr
+	client := NewReplicationClient(cfg, s.w, s.e)
+	s.attachClient(client)
+	client.resync.Store(true)
+
+	s.stopRole()
+	s.mu.Lock()
+	err := s.w.Flush(ctx)
+	if err != nil {
+		s.mu.Unlock()
+		s.startRole()
+		return fmt.Errorf("wal flush: %w", err)
+	}
+	s.isSlave = true
+	s.client = client
+	s.server = nil
+	s.mu.Unlock()
+	s.startRole()
+
+	slog.InfoContext(ctx, "replica of", slog.String("master_address", addr))
+	return nil
+}
+
+// replicationConfig возвращает настройки текущей роли для новой. Адрес сервера
+// репликации мастера становится ListenAddress, чтобы его можно было снова повысить.
+func (s *Storage) replicationConfig() config.Replication {
+	switch {
+	case s.client != nil:
+		return s.client.cfg
+	case s.server != nil:
+		cfg := s.server.cfg
+		cfg.ListenAddress = cfg.MasterAddress
+		return cfg
+	}
+	return config.Replication{}
+}
+
+// startRole запускает задачи текущей роли: получение сегментов на реплике,
+// удаление истекших ключей и сервер репликации на мастере. Вызывается под roleMu.
+func (s *Storage) startRole() {
+	if s.roleCtx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.roleCtx)
+	grp, ctx := errgroup.WithContext(ctx)
+
+	if s.isSlave {
+		client := s.client
+		slog.InfoContext(ctx, "storage is slave")
+		grp.Go(func() error {
+			err := client.Start(ctx)
+			slog.InfoContext(ctx, "close connection to master")
+			return err
+		})
+		if client.cfg.VerifyInterval > 0 {
+			grp.Go(func() error {
+				return client.startVerify(ctx)
+			})
+		}
+	} else {
+		grp.Go(func() error {
+			return s.sweep(ctx)
+		})
+	}
+
+	if server := s.server; server != nil {
+		grp.Go(func() error {
+			err := server.Start(ctx)
+			slog.InfoContext(ctx, "close master server")
+			return err
+		})
+	}
+
+	stopped := atomic.Bool{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := grp.Wait()
+		if err != nil && !stopped.Load() {
+			select {
+			case s.roleErr <- err:
+			default:
+			}
+		}
+	}()
+
+	s.stopRole = func() {
+		stopped.Store(true)
+		cancel()
+		<-done
+	}
+}
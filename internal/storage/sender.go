@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"time"
 
+	"inmem-db/internal/domain/command"
 	"inmem-db/internal/server/tcp"
 	"inmem-db/internal/storage/wal"
 	"inmem-db/internal/storage/wal/decode"
@@ -15,6 +19,9 @@ import (
 
 type SegmentsGetter interface {
 	SegmentsAfter(id int64) []wal.Segment
+	Changed() <-chan struct{}
+	LastSegmentID() int64
+	SnapshotID() int64
 }
 
 type sender struct {
@@ -22,19 +29,25 @@ type sender struct {
 	output io.Writer
 
 	segmenter SegmentsGetter
+	state     masterState
+	acks      *replicaAcks
 }
 
-func senderFactory(segmenter SegmentsGetter) tcp.HandlerFactory {
+func senderFactory(segmenter SegmentsGetter, state masterState, acks *replicaAcks) tcp.HandlerFactory {
 	return func(r io.Reader, w io.Writer) tcp.Starter {
 		return &sender{
 			input:     r,
 			output:    w,
 			segmenter: segmenter,
+			state:     state,
+			acks:      acks,
 		}
 	}
 }
 
 func (sender *sender) Start(ctx context.Context) error {
+	defer sender.acks.remove(sender)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -50,8 +63,18 @@ func (sender *sender) Start(ctx context.Context) error {
 			return fmt.Errorf("read input: %w", err)
 		}
 		slog.DebugContext(ctx, "get message from slave", slog.Int64("after_id", afterID))
+		switch afterID {
+		case streamRequest:
+			return sender.stream(ctx)
+		case checksumRequest:
+			err = sender.sendChecksum(ctx)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-		err = sender.sendSegments(afterID)
+		err = sender.sendSegments(ctx, afterID)
 		if err != nil {
 			slog.ErrorContext(ctx, "send segments", slog.String("error", err.Error()))
 		}
@@ -59,8 +82,188 @@ func (sender *sender) Start(ctx context.Context) error {
 	}
 }
 
-func (sender *sender) sendSegments(id int64) error {
+// stream отправляет реплике новые сегменты сразу после их записи в журнал,
+// а пока их нет - пустые пачки раз в интервал heartbeat
+func (sender *sender) stream(ctx context.Context) error {
+	afterID, err := decode.ReadID(sender.input)
+	if err != nil {
+		return fmt.Errorf("read stream start: %w", err)
+	}
+	ms, err := decode.ReadID(sender.input)
+	if err != nil {
+		return fmt.Errorf("read heartbeat interval: %w", err)
+	}
+	heartbeat := time.Duration(ms) * time.Millisecond
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	slog.InfoContext(ctx, "start replication stream",
+		slog.Int64("after_id", afterID),
+		slog.Duration("heartbeat", heartbeat))
+
+	t := time.NewTicker(heartbeat)
+	defer t.Stop()
+
+	// реплика подтверждает сегменты в том же соединении, без нее поток не нужен
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go sender.readAcks(ctx, cancel)
+
+	initial := true
+	for {
+		// канал берется до чтения сегментов, чтобы не пропустить запись между ними
+		changed := sender.segmenter.Changed()
+		segments := sender.segmenter.SegmentsAfter(afterID)
+		if sender.needsFullSync(afterID, segments, initial) {
+			// сегменты реплики не из этого состояния, она подтвердит его после загрузки
+			sender.acks.ack(sender, 0)
+			afterID, err = sender.fullSync(ctx)
+			if err != nil {
+				return err
+			}
+			initial = false
+			t.Reset(heartbeat)
+			continue
+		}
+		if initial {
+			// реплика уже сохранила сегменты, после которых подписалась
+			sender.acks.ack(sender, afterID)
+			initial = false
+		}
+
+		if len(segments) > 0 {
+			err = sender.writeSegments(segments)
+			if err != nil {
+				return err
+			}
+			for _, segment := range segments {
+				afterID = max(afterID, int64(segment.ID))
+			}
+			t.Reset(heartbeat)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-t.C:
+			err = sender.writeSegments(nil)
+			if err != nil {
+				return fmt.Errorf("write heartbeat: %w", err)
+			}
+		}
+	}
+}
+
+// readAcks читает из потока последние сегменты, сохраненные репликой.
+// Ошибка чтения означает, что реплика отключилась.
+func (sender *sender) readAcks(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+
+	for {
+		id, err := decode.ReadID(sender.input)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				slog.DebugContext(ctx, "read replica ack", slog.String("error", err.Error()))
+			}
+			return
+		}
+		sender.acks.ack(sender, id)
+	}
+}
+
+// sendChecksum пишет последний сегмент мастера и контрольную сумму данных на нем
+func (sender *sender) sendChecksum(ctx context.Context) error {
+	id, sum, err := sender.state.Checksum(ctx)
+	if err != nil {
+		return fmt.Errorf("checksum: %w", err)
+	}
+	err = encode.WriteID(sender.output, id)
+	if err != nil {
+		return fmt.Errorf("write segment id: %w", err)
+	}
+	err = encode.WriteID(sender.output, int64(sum))
+	if err != nil {
+		return fmt.Errorf("write checksum: %w", err)
+	}
+	return nil
+}
+
+func (sender *sender) sendSegments(ctx context.Context, id int64) error {
 	segments := sender.segmenter.SegmentsAfter(id)
+	if sender.needsFullSync(id, segments, true) {
+		_, err := sender.fullSync(ctx)
+		return err
+	}
+	// реплика в режиме poll не подтверждает сегменты и не считается в MinReplicaAcks
+	return sender.writeSegments(segments)
+}
+
+// needsFullSync сообщает, что реплику после afterID нельзя догнать сегментами:
+// реплика сама просит состояние, новой реплике дешевле загрузить состояние, чем весь журнал,
+// нужные сегменты уже вошли в снимок, или у реплики сегменты, которых нет у мастера
+func (sender *sender) needsFullSync(afterID int64, segments []wal.Segment, initial bool) bool {
+	last := sender.segmenter.LastSegmentID()
+	switch {
+	case afterID == fullSyncRequest, afterID > last:
+		return true
+	case initial && afterID == 0 && last > 0:
+		return true
+	case afterID < sender.segmenter.SnapshotID():
+		return true
+	}
+	return len(segments) > 0 && int64(segments[0].ID) != afterID+1
+}
+
+// fullSync пишет состояние мастера: метку fullSyncMarker вместо количества сегментов,
+// последний вошедший в состояние сегмент, количество команд и команды.
+// Команды пишутся через буфер, он сбрасывается после последней.
+// Возвращает сегмент, после которого реплика продолжит получать сегменты.
+func (sender *sender) fullSync(ctx context.Context) (int64, error) {
+	output := bufio.NewWriter(sender.output)
+	id := int64(0)
+	err := sender.state.State(ctx, func(snapshotID int64, size uint64) error {
+		if size > math.MaxUint32 {
+			return fmt.Errorf("too many keys: %d", size)
+		}
+		id = snapshotID
+		slog.InfoContext(ctx, "full sync",
+			slog.Int64("segment_id", id),
+			slog.Uint64("keys", size))
+
+		err := encode.WriteSize(output, fullSyncMarker)
+		if err != nil {
+			return fmt.Errorf("write full sync marker: %w", err)
+		}
+		err = encode.WriteID(output, id)
+		if err != nil {
+			return fmt.Errorf("write segment id: %w", err)
+		}
+		err = encode.WriteSize(output, uint32(size))
+		if err != nil {
+			return fmt.Errorf("write size: %w", err)
+		}
+		return nil
+	}, func(cmd command.Command) error {
+		err := encode.Write(output, cmd)
+		if err != nil {
+			return fmt.Errorf("write command: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("master state: %w", err)
+	}
+	err = output.Flush()
+	if err != nil {
+		return 0, fmt.Errorf("write state: %w", err)
+	}
+	return id, nil
+}
+
+// writeSegments пишет пачку: количество сегментов и сами сегменты
+func (sender *sender) writeSegments(segments []wal.Segment) error {
 	err := encode.WriteSize(sender.output, uint32(len(segments)))
 	if err != nil {
 		return fmt.Errorf("write size: %w", err)
@@ -2,98 +2,591 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"inmem-db/internal/domain/command"
-	"inmem-db/internal/server/tcp"
+	"inmem-db/internal/storage/engine"
+	"inmem-db/internal/storage/wal"
+	"inmem-db/pkg/concurrent"
 
 	"golang.org/x/sync/errgroup"
 )
 
+// snapshotCheckInterval - как часто проверяются условия автоматического снимка
+const snapshotCheckInterval = time.Second
+
+// ErrWALFailed - изменение применено в engine, но не записано в журнал.
+// Хранилище перестает отвечать, состояние восстанавливается из журнала после перезапуска.
+var ErrWALFailed = errors.New("wal write failed, storage is stopped")
+
 type Engine interface {
-	Do(ctx context.Context, cmd command.Command) (string, error)
+	Exec(ctx context.Context, cmd command.Command) (string, []command.Command, error)
+	Replay(ctx context.Context, cmd command.Command) error
+	Recover(ctx context.Context, cmd command.Command) error
+	Sweep(ctx context.Context) []command.Command
+	Watch(ctx context.Context, keys []string) (map[string]command.WatchedKey, error)
+	ExecTx(ctx context.Context, tx command.Tx) ([]command.Result, []command.Command, error)
+	Dump(ctx context.Context) []command.Command
+	Checksum(ctx context.Context) uint64
+	Load(ctx context.Context, cmds []command.Command) error
 }
 
 type WAL interface {
-	Save(ctx context.Context, cmd command.Command) error
-	Load(ctx context.Context) ([]command.Command, error)
+	Push(ctx context.Context, cmds []command.Command) *concurrent.ValueFuture[wal.ID]
+	Flush(ctx context.Context) error
+	Recover(ctx context.Context, fn func(cmd command.Command) error) error
+
+	FinishInstall(ctx context.Context) error
+	LoadSnapshot(ctx context.Context, fn func(cmd command.Command) error) (wal.ID, error)
+	Checkpoint() (wal.Checkpoint, error)
+	SinceCheckpoint() uint64
+	LastSegmentID() int64
+	WriteSnapshot(ctx context.Context, cp wal.Checkpoint, cmds []command.Command) error
+	StreamSnapshot(start func(id wal.ID, size uint64) error, fn func(cmd command.Command) error) error
+
+	// сегменты для реплик и от мастера, роль меняется командой REPLICAOF
+	SegmentsGetter
+	segmentManager
 }
 
 type Storage struct {
+	// mu упорядочивает применение изменений к engine и их постановку в wal,
+	// поэтому порядок в журнале совпадает с порядком применения
+	mu sync.Mutex
+
 	e Engine
 	w WAL
 
+	// snapshotMu не дает делать два снимка одновременно
+	snapshotMu   sync.Mutex
+	lastSnapshot time.Time
+	snapshots    snapshotPolicy
+
+	// isSlave, client и server меняются под mu и roleMu
 	isSlave bool
 	client  *replicationClient
-	server  *tcp.Server
+	server  *masterServer
+
+	// roleMu упорядочивает смену роли командой REPLICAOF
+	roleMu sync.Mutex
+	// roleCtx - контекст Start, в нем работают задачи роли, nil - Start еще не вызван
+	roleCtx context.Context
+	// stopRole останавливает задачи текущей роли и ждет их завершения
+	stopRole func()
+	// roleErr получает ошибку задачи роли, которая не была остановлена
+	roleErr chan error
+
+	// failed закрывается после ошибки записи в журнал, failErr задается до закрытия
+	failOnce sync.Once
+	failed   chan struct{}
+	failErr  error
+
+	// lastWrite - последнее изменение, начатое под mu. Оно задается до применения к engine,
+	// поэтому чтение, увидевшее изменение, дождется его записи в журнал.
+	lastWrite atomic.Pointer[pending]
+}
+
+// pending - изменение engine, поставленное в журнал. done закрывается, когда оно
+// и все изменения до него записаны в журнал или запись не удалась, err задается до закрытия.
+type pending struct {
+	prev *pending
+	f    *concurrent.ValueFuture[wal.ID]
+
+	done chan struct{}
+	err  error
+}
+
+// snapshotPolicy - когда делать снимок автоматически, нулевые значения отключают условие
+type snapshotPolicy struct {
+	interval time.Duration
+	walSize  uint64
 }
 
-func New(e Engine, w WAL, options ...option) *Storage {
+func New(e Engine, w WAL, options ...Option) *Storage {
 	s := Storage{
-		e: e,
-		w: w,
+		e:        e,
+		w:        w,
+		stopRole: func() {},
+		roleErr:  make(chan error, 1),
+		failed:   make(chan struct{}),
 	}
 
 	for _, o := range options {
 		o(&s)
 	}
+	if s.client != nil {
+		s.attachClient(s.client)
+	}
+	if s.server != nil {
+		s.server.state = &s
+	}
 
 	return &s
 }
 
-// Do оборачивает engine для записи в engine и wal
+func (s *Storage) attachClient(client *replicationClient) {
+	client.mu = &s.mu
+	client.checksum = s.Checksum
+}
+
+// Do оборачивает engine для записи в engine и wal.
+// Изменение сначала применяется к engine, затем ставится в журнал: так известны точные
+// изменения команды (удаленные истекшие ключи, результат счетчика), а порядок в журнале
+// совпадает с порядком применения. Пока изменение пишется, его уже видят другие команды,
+// но ответ с ним, в том числе ответ на чтение, клиент получает только после записи в журнал.
+// Если запись не удалась, хранилище останавливается, и такие значения не отдаются.
 func (s *Storage) Do(ctx context.Context, cmd command.Command) (string, error) {
-	if cmd.Type != command.CommandGET {
-		if s.isSlave {
-			return "", ErrReadOnly
+	err := s.checkFailed()
+	if err != nil {
+		return "", err
+	}
+	if cmd.IsReadOnly() {
+		res, _, err := s.e.Exec(ctx, cmd)
+		if err != nil {
+			return "", fmt.Errorf("engine do: %w", err)
 		}
-
-		err := s.w.Save(ctx, cmd)
+		err = s.waitWritten()
 		if err != nil {
-			return "", fmt.Errorf("wal save: %w", err)
+			return "", err
 		}
+		return res, nil
 	}
 
-	res, err := s.e.Do(ctx, cmd)
+	// в wal пишется абсолютное время истечения, чтобы восстановление
+	// и реплики получили тот же набор ключей
+	cmd = cmd.WithDeadline(time.Now())
+
+	res, p, err := s.exec(ctx, cmd)
+	if errors.Is(err, ErrReadOnly) || errors.Is(err, ErrWALFailed) {
+		return "", err
+	}
+	// изменения, сделанные до ошибки engine, тоже должны записаться
+	id, walErr := s.finish(p)
 	if err != nil {
 		return "", fmt.Errorf("engine do: %w", err)
 	}
+	if walErr != nil {
+		return "", walErr
+	}
+	err = s.waitAcks(ctx, id)
+	if err != nil {
+		return "", err
+	}
 	return res, nil
 }
 
+// exec применяет команду и ставит изменения в очередь wal, не дожидаясь записи
+func (s *Storage) exec(ctx context.Context, cmd command.Command) (string, *pending, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkFailed()
+	if err != nil {
+		return "", nil, err
+	}
+	// роль проверяется под mu, чтобы после REPLICAOF ни одна запись не попала в журнал реплики
+	if s.isSlave {
+		return "", nil, ErrReadOnly
+	}
+
+	// даже при ошибке engine мог удалить истекшие или вытесненные ключи,
+	// эти изменения тоже должны попасть в журнал
+	p := s.begin()
+	res, changes, err := s.e.Exec(ctx, cmd)
+	p.f = s.push(ctx, changes)
+	return res, p, err
+}
+
+// Watch возвращает версии ключей для транзакции
+func (s *Storage) Watch(ctx context.Context, keys []string) (map[string]command.WatchedKey, error) {
+	err := s.checkFailed()
+	if err != nil {
+		return nil, err
+	}
+	watched, err := s.e.Watch(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("engine watch: %w", err)
+	}
+	return watched, nil
+}
+
+// DoTx выполняет транзакцию атомарно, изменения всех команд попадают в один сегмент wal
+func (s *Storage) DoTx(ctx context.Context, tx command.Tx) ([]command.Result, error) {
+	now := time.Now()
+	cmds := make([]command.Command, len(tx.Commands))
+	for i, cmd := range tx.Commands {
+		cmds[i] = cmd.WithDeadline(now)
+	}
+	tx.Commands = cmds
+
+	s.mu.Lock()
+	err := s.checkFailed()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if !tx.IsReadOnly() && s.isSlave {
+		s.mu.Unlock()
+		return nil, ErrReadOnly
+	}
+	p := s.begin()
+	results, changes, err := s.e.ExecTx(ctx, tx)
+	p.f = s.push(ctx, changes)
+	s.mu.Unlock()
+
+	id, walErr := s.finish(p)
+	if err != nil {
+		return nil, fmt.Errorf("engine exec: %w", err)
+	}
+	if walErr != nil {
+		return nil, walErr
+	}
+	err = s.waitAcks(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *Storage) push(ctx context.Context, changes []command.Command) *concurrent.ValueFuture[wal.ID] {
+	if len(changes) == 0 {
+		return nil
+	}
+	// изменение уже применено, поэтому оно должно попасть в журнал даже при отмене запроса
+	return s.w.Push(context.WithoutCancel(ctx), changes)
+}
+
+// begin начинает изменение engine, вызывается под mu до его применения
+func (s *Storage) begin() *pending {
+	p := &pending{
+		prev: s.lastWrite.Load(),
+		done: make(chan struct{}),
+	}
+	s.lastWrite.Store(p)
+	return p
+}
+
+// finish дожидается записи изменения p в журнал и возвращает его сегмент, 0 - изменений нет.
+// Изменение без записей в журнал ждет предыдущее: его результат мог зависеть от них.
+func (s *Storage) finish(p *pending) (wal.ID, error) {
+	if p == nil {
+		return 0, nil
+	}
+	id, err := s.wait(p.f)
+	if p.f == nil && p.prev != nil {
+		<-p.prev.done
+		err = p.prev.err
+	}
+	// цепочка не растет: ожидающие смотрят только на последнее изменение
+	p.prev = nil
+	p.err = err
+	close(p.done)
+	return id, err
+}
+
+// waitWritten дожидается записи в журнал изменений, которые могло увидеть чтение.
+// Значение, которое еще может пропасть при сбое журнала, клиенту не отдается.
+// На реплике изменения от мастера сохраняются в журнал до применения, их ждать не нужно.
+func (s *Storage) waitWritten() error {
+	p := s.lastWrite.Load()
+	if p == nil {
+		return nil
+	}
+	<-p.done
+	if p.err != nil {
+		return p.err
+	}
+	return s.checkFailed()
+}
+
+// wait дожидается записи изменений в журнал и возвращает их сегмент, 0 - изменений нет.
+// Изменения уже видны в engine, поэтому после ошибки записи хранилище останавливается,
+// а не продолжает отдавать их.
+func (s *Storage) wait(f *concurrent.ValueFuture[wal.ID]) (wal.ID, error) {
+	id, err := f.Get()
+	if err != nil {
+		s.fail(err)
+		return 0, fmt.Errorf("%w: %w", ErrWALFailed, err)
+	}
+	return id, nil
+}
+
+// fail останавливает хранилище после ошибки записи в журнал
+func (s *Storage) fail(err error) {
+	s.failOnce.Do(func() {
+		slog.Error("wal write failed, storage is stopped", slog.String("error", err.Error()))
+		s.failErr = err
+		close(s.failed)
+	})
+}
+
+func (s *Storage) checkFailed() error {
+	select {
+	case <-s.failed:
+		return fmt.Errorf("%w: %w", ErrWALFailed, s.failErr)
+	default:
+		return nil
+	}
+}
+
+// waitAcks ждет подтверждения сегмента id репликами, 0 - запись ничего не изменила
+func (s *Storage) waitAcks(ctx context.Context, id wal.ID) error {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	if id == 0 || server == nil {
+		return nil
+	}
+	err := server.waitAcks(ctx, int64(id))
+	if err != nil {
+		return fmt.Errorf("replica acks: %w", err)
+	}
+	return nil
+}
+
+// Restore завершает прерванную установку снимка, загружает последний снимок и применяет сегменты журнала после него
 func (s *Storage) Restore(ctx context.Context) error {
-	cmds, err := s.w.Load(ctx)
+	err := s.w.FinishInstall(ctx)
 	if err != nil {
-		return fmt.Errorf("load commands: %w", err)
+		return fmt.Errorf("finish snapshot install: %w", err)
 	}
-	for _, cmd := range cmds {
-		_, err = s.e.Do(ctx, cmd)
+
+	_, err = s.w.LoadSnapshot(ctx, func(cmd command.Command) error {
+		return s.e.Recover(ctx, cmd)
+	})
+	if err != nil {
+		return fmt.Errorf("load snapshot: %w", err)
+	}
+
+	err = s.w.Recover(ctx, func(cmd command.Command) error {
+		return s.e.Recover(ctx, cmd)
+	})
+	if err != nil {
+		return fmt.Errorf("recover wal: %w", err)
+	}
+
+	return nil
+}
+
+// sweep удаляет истекшие ключи на мастере и журналирует удаления,
+// реплики получают их как обычные DEL
+func (s *Storage) sweep(ctx context.Context) error {
+	t := time.NewTicker(engine.SweepInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		s.mu.Lock()
+		if s.checkFailed() != nil {
+			s.mu.Unlock()
+			return nil
+		}
+		p := s.begin()
+		p.f = s.push(ctx, s.e.Sweep(ctx))
+		s.mu.Unlock()
+
+		_, err := s.finish(p)
 		if err != nil {
-			return fmt.Errorf("engine do: %w", err)
+			return nil
 		}
 	}
+}
+
+// Snapshot сохраняет состояние engine и удаляет вошедшие в снимок файлы журнала
+func (s *Storage) Snapshot(ctx context.Context) error {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	cp, cmds, err := s.checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+	s.lastSnapshot = time.Now()
+
+	// запись снимка не блокирует команды, состояние уже скопировано
+	err = s.w.WriteSnapshot(ctx, cp, cmds)
+	if err != nil {
+		return fmt.Errorf("wal snapshot: %w", err)
+	}
+	return nil
+}
+
+// checkpoint копирует состояние, в которое вошли ровно сегменты до cp.ID:
+// новые изменения не применяются, пока все поставленные в очередь не записаны
+func (s *Storage) checkpoint(ctx context.Context) (wal.Checkpoint, []command.Command, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// состояние engine может содержать изменения, которых нет в журнале
+	err := s.checkFailed()
+	if err != nil {
+		return wal.Checkpoint{}, nil, err
+	}
+	err = s.w.Flush(ctx)
+	if err != nil {
+		return wal.Checkpoint{}, nil, fmt.Errorf("wal flush: %w", err)
+	}
+	cp, err := s.w.Checkpoint()
+	if err != nil {
+		return wal.Checkpoint{}, nil, fmt.Errorf("wal checkpoint: %w", err)
+	}
+	return cp, s.e.Dump(ctx), nil
+}
+
+// Checksum возвращает последний записанный сегмент и контрольную сумму данных,
+// в которые вошли ровно сегменты до него
+func (s *Storage) Checksum(ctx context.Context) (int64, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkFailed()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = s.w.Flush(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("wal flush: %w", err)
+	}
+	return s.w.LastSegmentID(), s.e.Checksum(ctx), nil
+}
+
+// State передает в start сегмент, на котором сделано состояние, и количество команд,
+// затем команды состояния в fn. Если есть целый снимок, команды читаются из его файла
+// и передача не блокирует команды. Иначе состояние копируется на последнем записанном
+// сегменте, как для снимка, но журнал не сжимается: подключение реплики не удаляет сегменты.
+// Реплика загружает состояние при полной синхронизации и догоняет мастера сегментами после него.
+func (s *Storage) State(ctx context.Context, start func(id int64, size uint64) error, fn func(cmd command.Command) error) error {
+	err := s.checkFailed()
+	if err != nil {
+		return err
+	}
+	err = s.streamSnapshot(start, fn)
+	if !errors.Is(err, wal.ErrNoSnapshot) {
+		return err
+	}
 
+	id, cmds, err := s.dump(ctx)
+	if err != nil {
+		return err
+	}
+	err = start(id, uint64(len(cmds)))
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		err = fn(cmd)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (s *Storage) streamSnapshot(start func(id int64, size uint64) error, fn func(cmd command.Command) error) error {
+	// снимок не заменяется, пока читается
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	return s.w.StreamSnapshot(func(id wal.ID, size uint64) error {
+		return start(int64(id), size)
+	}, fn)
+}
+
+// dump копирует состояние, в которое вошли ровно сегменты до последнего записанного
+func (s *Storage) dump(ctx context.Context) (int64, []command.Command, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkFailed()
+	if err != nil {
+		return 0, nil, err
+	}
+	err = s.w.Flush(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("wal flush: %w", err)
+	}
+	return s.w.LastSegmentID(), s.e.Dump(ctx), nil
+}
+
+// autoSnapshot делает снимки по интервалу и по объему журнала после прошлого снимка
+func (s *Storage) autoSnapshot(ctx context.Context) error {
+	check := snapshotCheckInterval
+	if s.snapshots.interval > 0 {
+		check = min(check, s.snapshots.interval)
+	}
+	t := time.NewTicker(check)
+	defer t.Stop()
+
+	s.snapshotMu.Lock()
+	s.lastSnapshot = time.Now()
+	s.snapshotMu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		if !s.snapshotDue() {
+			continue
+		}
+		err := s.Snapshot(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "auto snapshot", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (s *Storage) snapshotDue() bool {
+	s.snapshotMu.Lock()
+	last := s.lastSnapshot
+	s.snapshotMu.Unlock()
+
+	p := s.snapshots
+	if p.interval > 0 && time.Since(last) >= p.interval {
+		return true
+	}
+	return p.walSize > 0 && s.w.SinceCheckpoint() >= p.walSize
+}
+
 func (s *Storage) Start(ctx context.Context) error {
 	grp, ctx := errgroup.WithContext(ctx)
 
-	if s.isSlave {
-		slog.InfoContext(ctx, "storage is slave")
-		grp.Go(func() error {
-			err := s.client.Start(ctx)
-			slog.InfoContext(ctx, "close connection to master")
+	s.roleMu.Lock()
+	s.roleCtx = ctx
+	s.startRole()
+	s.roleMu.Unlock()
+
+	grp.Go(func() error {
+		var err error
+		select {
+		case <-ctx.Done():
+		case err = <-s.roleErr:
 			return err
-		})
-	}
+		case <-s.failed:
+			err = s.checkFailed()
+		}
 
-	if s.server != nil {
+		s.roleMu.Lock()
+		defer s.roleMu.Unlock()
+		s.stopRole()
+		return err
+	})
+
+	if s.snapshots.interval > 0 || s.snapshots.walSize > 0 {
 		grp.Go(func() error {
-			err := s.server.Start(ctx)
-			slog.InfoContext(ctx, "close master server")
-			return err
+			return s.autoSnapshot(ctx)
 		})
 	}
 	return grp.Wait()
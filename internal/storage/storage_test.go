@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -9,8 +10,10 @@ import (
 
 	"inmem-db/internal/compute/parser"
 	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
 	"inmem-db/internal/storage/engine"
 	"inmem-db/internal/storage/wal"
+	"inmem-db/pkg/concurrent"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -81,3 +84,403 @@ func TestDo_concurrently(t *testing.T) {
 	wg.Wait()
 	w.Close()
 }
+
+func TestRestore_expire(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+	w, err := wal.New(cfg)
+	require.NoError(t, err)
+
+	s := New(engine.New(), w)
+	p := parser.Parser{}
+	for _, line := range []string{
+		"SET session token EX 100",
+		"SET short token EX 1",
+		"SET forever token",
+	} {
+		cmd, err := p.Parse(ctx, line)
+		require.NoError(t, err)
+		_, err = s.Do(ctx, cmd)
+		require.NoError(t, err)
+	}
+	w.Close()
+
+	// ждем, пока истечет короткий ключ
+	time.Sleep(time.Second)
+
+	w, err = wal.New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	s = New(engine.New(), w)
+	require.NoError(t, s.Restore(ctx))
+
+	ttl := func(name string) string {
+		cmd, err := p.Parse(ctx, "TTL "+name)
+		require.NoError(t, err)
+		got, err := s.Do(ctx, cmd)
+		require.NoError(t, err)
+		return got
+	}
+
+	assert.Contains(t, []string{"99", "100"}, ttl("session"))
+	assert.Equal(t, "-2", ttl("short"))
+	assert.Equal(t, "-1", ttl("forever"))
+}
+
+func TestDo_incrConcurrently(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	const workers = 500
+
+	cfg := config.WAL{
+		BatchSize:      100,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+	w, err := wal.New(cfg)
+	require.NoError(t, err)
+
+	s := New(engine.New(), w)
+	p := parser.Parser{}
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := range workers {
+		go func() {
+			defer wg.Done()
+			line := "INCR counter"
+			if i%2 == 0 {
+				line = "INCRBY counter 3"
+			}
+			cmd, err := p.Parse(ctx, line)
+			require.NoError(t, err)
+
+			_, err = s.Do(ctx, cmd)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	get, err := p.Parse(ctx, "GET counter")
+	require.NoError(t, err)
+	want, err := s.Do(ctx, get)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(workers/2*3+workers/2), want)
+
+	// нецелое значение не попадает в журнал
+	set, err := p.Parse(ctx, "SET text value")
+	require.NoError(t, err)
+	_, err = s.Do(ctx, set)
+	require.NoError(t, err)
+	incr, err := p.Parse(ctx, "INCR text")
+	require.NoError(t, err)
+	_, err = s.Do(ctx, incr)
+	assert.ErrorIs(t, err, engine.ErrNotInteger)
+	w.Close()
+
+	w, err = wal.New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	s = New(engine.New(), w)
+	require.NoError(t, s.Restore(ctx))
+
+	got, err := s.Do(ctx, get)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestDo_setNXConcurrently(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	const workers = 100
+
+	cfg := config.WAL{
+		BatchSize:      100,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+	w, err := wal.New(cfg)
+	require.NoError(t, err)
+
+	s := New(engine.New(), w)
+	p := parser.Parser{}
+
+	mu := sync.Mutex{}
+	winners := []string{}
+
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := range workers {
+		go func() {
+			defer wg.Done()
+			owner := fmt.Sprintf("owner%d", i)
+			cmd, err := p.Parse(ctx, "SET lock "+owner+" NX")
+			require.NoError(t, err)
+
+			got, err := s.Do(ctx, cmd)
+			require.NoError(t, err)
+			if got == "1" {
+				mu.Lock()
+				winners = append(winners, owner)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, winners, 1)
+	w.Close()
+
+	w, err = wal.New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// в журнал попадает только успешная запись
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, cmds, 1)
+
+	s = New(engine.New(), w)
+	for _, cmd := range cmds {
+		require.NoError(t, s.e.Replay(ctx, cmd))
+	}
+
+	get, err := p.Parse(ctx, "GET lock")
+	require.NoError(t, err)
+	got, err := s.Do(ctx, get)
+	require.NoError(t, err)
+	assert.Equal(t, winners[0], got)
+}
+
+func TestSnapshot_restore(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	const (
+		workers = 50
+		incrs   = 20
+	)
+
+	cfg := config.WAL{
+		BatchSize:      10,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "100B",
+		DataDir:        t.TempDir(),
+	}
+	w, err := wal.New(cfg)
+	require.NoError(t, err)
+	s := New(engine.New(), w)
+	require.NoError(t, s.Restore(ctx))
+
+	p := parser.Parser{}
+	do := func(line string) string {
+		cmd, err := p.Parse(ctx, line)
+		require.NoError(t, err)
+		got, err := s.Do(ctx, cmd)
+		require.NoError(t, err)
+		return got
+	}
+	do("SET name value")
+
+	// INCR не идемпотентна: если снимок и журнал пересекутся, счетчик будет больше
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for range incrs {
+				do("INCR counter")
+			}
+		}()
+	}
+	for range 5 {
+		require.NoError(t, s.Snapshot(ctx))
+	}
+	wg.Wait()
+	do("DEL name")
+	w.Close()
+
+	w, err = wal.New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	s = New(engine.New(), w)
+	require.NoError(t, s.Restore(ctx))
+
+	assert.Equal(t, fmt.Sprint(workers*incrs), do("GET counter"))
+	cmd, err := p.Parse(ctx, "GET name")
+	require.NoError(t, err)
+	_, err = s.Do(ctx, cmd)
+	assert.ErrorIs(t, err, engine.ErrNotFound)
+}
+
+func TestDo_walFailed(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	w, err := wal.New(config.WAL{
+		BatchSize:      10,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	})
+	require.NoError(t, err)
+	s := New(engine.New(), w)
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start(ctx)
+	}()
+
+	p := parser.Parser{}
+	parse := func(line string) command.Command {
+		cmd, err := p.Parse(ctx, line)
+		require.NoError(t, err)
+		return cmd
+	}
+	_, err = s.Do(ctx, parse("SET name value"))
+	require.NoError(t, err)
+
+	// закрытый журнал не принимает записи, а engine уже применил SET
+	w.Close()
+	_, err = s.Do(ctx, parse("SET name other"))
+	require.ErrorIs(t, err, ErrWALFailed)
+
+	// незаписанное значение не отдается ни чтением, ни транзакцией, ни снимком
+	_, err = s.Do(ctx, parse("GET name"))
+	assert.ErrorIs(t, err, ErrWALFailed)
+	_, err = s.DoTx(ctx, command.Tx{Commands: []command.Command{parse("GET name")}})
+	assert.ErrorIs(t, err, ErrWALFailed)
+	_, err = s.Watch(ctx, []string{"name"})
+	assert.ErrorIs(t, err, ErrWALFailed)
+	assert.ErrorIs(t, s.Snapshot(ctx), ErrWALFailed)
+	err = s.State(ctx, nil, nil)
+	assert.ErrorIs(t, err, ErrWALFailed)
+
+	select {
+	case err := <-started:
+		assert.ErrorIs(t, err, concurrent.ErrClosed)
+	case <-ctx.Done():
+		t.Fatal("storage is not stopped")
+	}
+}
+
+// heldWAL отдает результат записи в журнал только после закрытия release,
+// err заменяет результат ошибкой
+type heldWAL struct {
+	*wal.WAL
+	release chan struct{}
+	err     error
+}
+
+func (w heldWAL) Push(ctx context.Context, cmds []command.Command) *concurrent.ValueFuture[wal.ID] {
+	f := w.WAL.Push(ctx, cmds)
+	if f == nil {
+		return nil
+	}
+	return concurrent.NewValueFuture(func() (wal.ID, error) {
+		id, err := f.Get()
+		<-w.release
+		if w.err != nil {
+			return 0, w.err
+		}
+		return id, err
+	})
+}
+
+func TestDo_readDuringPush(t *testing.T) {
+	t.Parallel()
+
+	errDisk := errors.New("disk failed")
+
+	type result struct {
+		value string
+		err   error
+	}
+
+	type test struct {
+		pushErr error
+
+		want result
+	}
+
+	tests := map[string]test{
+		"slow push":   {want: result{value: "value"}},
+		"failed push": {pushErr: errDisk, want: result{err: ErrWALFailed}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			w, err := wal.New(config.WAL{
+				BatchSize:      10,
+				BatchTimeout:   time.Millisecond,
+				MaxSegmentSize: "10MB",
+				DataDir:        t.TempDir(),
+			})
+			require.NoError(t, err)
+			defer w.Close()
+			held := heldWAL{WAL: w, release: make(chan struct{}), err: tc.pushErr}
+			s := New(engine.New(), held)
+
+			p := parser.Parser{}
+			parse := func(line string) command.Command {
+				cmd, err := p.Parse(ctx, line)
+				require.NoError(t, err)
+				return cmd
+			}
+			do := func(cmd command.Command) <-chan result {
+				out := make(chan result, 1)
+				go func() {
+					value, err := s.Do(ctx, cmd)
+					out <- result{value: value, err: err}
+				}()
+				return out
+			}
+
+			set := do(parse("SET name value"))
+			// SET применен к engine, но еще не записан в журнал
+			require.Eventually(t, func() bool {
+				value, _, err := s.e.Exec(ctx, parse("GET name"))
+				return err == nil && value == "value"
+			}, time.Second, time.Millisecond)
+
+			get := do(parse("GET name"))
+			select {
+			case res := <-get:
+				t.Fatalf("read returned %+v before the write is in the wal", res)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(held.release)
+			setRes, getRes := <-set, <-get
+			if tc.want.err != nil {
+				assert.ErrorIs(t, setRes.err, tc.want.err)
+				assert.ErrorIs(t, getRes.err, tc.want.err)
+				return
+			}
+			require.NoError(t, setRes.err)
+			require.NoError(t, getRes.err)
+			assert.Equal(t, tc.want.value, getRes.value)
+		})
+	}
+}
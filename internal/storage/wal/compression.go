@@ -0,0 +1,88 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"inmem-db/internal/config"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrUnknownCodec = errors.New("unknown segment codec")
+
+// codec - способ сжатия содержимого сегмента, хранится в младших битах флагов сегмента
+type codec byte
+
+const (
+	codecNone   codec = 0
+	codecSnappy codec = 1
+	codecZstd   codec = 2
+
+	codecMask byte = 0x03
+)
+
+func parseCodec(c config.Compression) (codec, error) {
+	switch c {
+	case "", config.CompressionNone:
+		return codecNone, nil
+	case config.CompressionSnappy:
+		return codecSnappy, nil
+	case config.CompressionZstd:
+		return codecZstd, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownCodec, c)
+}
+
+// EncodeAll и DecodeAll можно вызывать конкурентно, поэтому кодеры общие
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		e, _ := zstd.NewWriter(nil)
+		return e
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		d, _ := zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(0),
+			zstd.WithDecoderMaxMemory(maxFrameSize))
+		return d
+	})
+)
+
+// compress сжимает содержимое сегмента. Если сжатие не уменьшает размер,
+// содержимое остается как есть, и возвращается codecNone.
+func compress(c codec, payload []byte) (codec, []byte) {
+	var out []byte
+	switch c {
+	case codecSnappy:
+		out = s2.EncodeSnappy(nil, payload)
+	case codecZstd:
+		out = zstdEncoder().EncodeAll(payload, nil)
+	default:
+		return codecNone, payload
+	}
+	if len(out) >= len(payload) {
+		return codecNone, payload
+	}
+	return c, out
+}
+
+func decompress(c codec, body []byte) ([]byte, error) {
+	switch c {
+	case codecNone:
+		return body, nil
+	case codecSnappy:
+		size, err := s2.DecodedLen(body)
+		if err != nil {
+			return nil, fmt.Errorf("snappy: %w", err)
+		}
+		if size > maxFrameSize {
+			return nil, fmt.Errorf("snappy: decoded size %d is too large", size)
+		}
+		return s2.Decode(nil, body)
+	case codecZstd:
+		return zstdDecoder().DecodeAll(body, nil)
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, c)
+}
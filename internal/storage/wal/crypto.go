@@ -0,0 +1,306 @@
+package wal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"inmem-db/internal/config"
+)
+
+var (
+	ErrNoKey   = errors.New("encryption key not found")
+	ErrBadKey  = errors.New("bad encryption key")
+	ErrDecrypt = errors.New("decryption failed")
+)
+
+const (
+	// flagEncrypted - бит флагов сегмента: содержимое зашифровано, перед ним ID ключа и nonce
+	flagEncrypted byte = 0x04
+
+	keyIDSize = 4
+	// sealedChunkSize - размер части потока при шифровании снимка
+	sealedChunkSize = 64 << 10
+)
+
+// Keyring - ключи AES-GCM по ID. Новые записи шифруются ключом с наибольшим ID,
+// старые ключи остаются для чтения записанного до ротации.
+type Keyring struct {
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// LoadKeyring читает ключи из файла или переменной окружения из конфигурации.
+// Без них возвращает nil, и журнал не шифруется.
+func LoadKeyring(cfg config.WAL) (*Keyring, error) {
+	var text string
+	switch {
+	case cfg.EncryptionKeyFile != "" && cfg.EncryptionKeyEnv != "":
+		return nil, fmt.Errorf("%w: both key file and key env are set", ErrBadKey)
+	case cfg.EncryptionKeyFile != "":
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		text = string(data)
+	case cfg.EncryptionKeyEnv != "":
+		value, ok := os.LookupEnv(cfg.EncryptionKeyEnv)
+		if !ok {
+			return nil, fmt.Errorf("%w: env %s is not set", ErrNoKey, cfg.EncryptionKeyEnv)
+		}
+		text = value
+	default:
+		return nil, nil
+	}
+	return ParseKeyring(text)
+}
+
+// ParseKeyring разбирает ключи вида "<id>:<ключ в hex>", разделенные запятыми или
+// переводами строк. ID - положительное число, ключ - 16, 24 или 32 байта.
+// Строки с # - комментарии.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := Keyring{keys: map[uint32]cipher.AEAD{}}
+
+	for _, line := range strings.Split(text, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			err := k.add(entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("%w: no keys", ErrBadKey)
+	}
+	return &k, nil
+}
+
+func (k *Keyring) add(entry string) error {
+	rawID, rawKey, ok := strings.Cut(entry, ":")
+	if !ok {
+		return fmt.Errorf("%w: want <id>:<hex key>", ErrBadKey)
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(rawID), 10, 32)
+	if err != nil || id == 0 {
+		return fmt.Errorf("%w: invalid id %q", ErrBadKey, rawID)
+	}
+	if _, ok := k.keys[uint32(id)]; ok {
+		return fmt.Errorf("%w: duplicate id %d", ErrBadKey, id)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(rawKey))
+	if err != nil {
+		return fmt.Errorf("%w: key %d is not hex", ErrBadKey, id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("%w: key %d: %w", ErrBadKey, id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("%w: key %d: %w", ErrBadKey, id, err)
+	}
+
+	k.keys[uint32(id)] = aead
+	k.active = max(k.active, uint32(id))
+	return nil
+}
+
+// activeID возвращает ключ для новых записей, 0 - шифрование выключено
+func (k *Keyring) activeID() uint32 {
+	if k == nil {
+		return 0
+	}
+	return k.active
+}
+
+func (k *Keyring) aead(id uint32) (cipher.AEAD, error) {
+	if k != nil {
+		if aead, ok := k.keys[id]; ok {
+			return aead, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: id %d", ErrNoKey, id)
+}
+
+// seal шифрует содержимое сегмента: ID ключа, nonce, шифротекст.
+// Флаги и ID ключа подписываются вместе с содержимым.
+func (k *Keyring) seal(flags byte, plain []byte) []byte {
+	aead := k.keys[k.active]
+	out := make([]byte, keyIDSize+aead.NonceSize(), keyIDSize+aead.NonceSize()+len(plain)+aead.Overhead())
+	binary.BigEndian.PutUint32(out, k.active)
+	nonce := out[keyIDSize:]
+	_, _ = rand.Read(nonce)
+
+	return aead.Seal(out, nonce, plain, sealedAD(flags, out[:keyIDSize]))
+}
+
+func (k *Keyring) open(flags byte, body []byte) ([]byte, error) {
+	if len(body) < keyIDSize {
+		return nil, fmt.Errorf("%w: no key id", ErrDecrypt)
+	}
+	aead, err := k.aead(binary.BigEndian.Uint32(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < keyIDSize+aead.NonceSize() {
+		return nil, fmt.Errorf("%w: no nonce", ErrDecrypt)
+	}
+
+	nonce := body[keyIDSize : keyIDSize+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, body[keyIDSize+aead.NonceSize():], sealedAD(flags, body[:keyIDSize]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return plain, nil
+}
+
+func sealedAD(flags byte, keyID []byte) []byte {
+	return append([]byte{flags}, keyID...)
+}
+
+// sealWriter шифрует поток частями: признак последней части, длина, nonce, шифротекст.
+// Номер части и признак подписаны, поэтому части нельзя переставить или отрезать.
+type sealWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	keyID uint32
+	buf   []byte
+	chunk uint64
+}
+
+func (k *Keyring) newSealWriter(w io.Writer) *sealWriter {
+	return &sealWriter{
+		w:     w,
+		aead:  k.keys[k.active],
+		keyID: k.active,
+		buf:   make([]byte, 0, sealedChunkSize),
+	}
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sealedChunkSize-len(s.buf))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(s.buf) == sealedChunkSize {
+			err := s.flush(false)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close пишет последнюю часть, без нее поток считается оборванным
+func (s *sealWriter) Close() error {
+	return s.flush(true)
+}
+
+func (s *sealWriter) flush(final bool) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	sealed := s.aead.Seal(nil, nonce, s.buf, chunkAD(s.keyID, s.chunk, final))
+
+	header := []byte{0}
+	if final {
+		header[0] = 1
+	}
+	header = binary.BigEndian.AppendUint32(header, uint32(len(sealed)))
+	for _, part := range [][]byte{header, nonce, sealed} {
+		_, err := s.w.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.buf = s.buf[:0]
+	s.chunk++
+	return nil
+}
+
+// openReader расшифровывает поток sealWriter
+type openReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	keyID uint32
+	buf   []byte
+	chunk uint64
+	final bool
+}
+
+func (k *Keyring) newOpenReader(r io.Reader, keyID uint32) (*openReader, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &openReader{r: r, aead: aead, keyID: keyID}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.final {
+			return 0, io.EOF
+		}
+		err := o.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+func (o *openReader) next() error {
+	header := make([]byte, 5+o.aead.NonceSize())
+	_, err := io.ReadFull(o.r, header)
+	if err != nil {
+		return fmt.Errorf("%w: read chunk %d: %w", ErrDecrypt, o.chunk, err)
+	}
+	final := header[0] == 1
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > sealedChunkSize+uint32(o.aead.Overhead()) {
+		return fmt.Errorf("%w: chunk %d is too large", ErrDecrypt, o.chunk)
+	}
+
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(o.r, sealed)
+	if err != nil {
+		return fmt.Errorf("%w: read chunk %d: %w", ErrDecrypt, o.chunk, err)
+	}
+	o.buf, err = o.aead.Open(sealed[:0], header[5:], sealed, chunkAD(o.keyID, o.chunk, final))
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %w", ErrDecrypt, o.chunk, err)
+	}
+
+	o.final = final
+	o.chunk++
+	return nil
+}
+
+func chunkAD(keyID uint32, chunk uint64, final bool) []byte {
+	ad := binary.BigEndian.AppendUint32(nil, keyID)
+	ad = binary.BigEndian.AppendUint64(ad, chunk)
+	if final {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
@@ -0,0 +1,169 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKey1 = strings.Repeat("11", 32)
+	testKey2 = strings.Repeat("22", 32)
+)
+
+func TestParseKeyring(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		text string
+
+		active uint32
+		err    error
+	}
+
+	tests := map[string]test{
+		"one key":      {text: "1:" + testKey1, active: 1},
+		"rotated":      {text: "# old\n1:" + testKey1 + "\n2:" + testKey2 + "\n", active: 2},
+		"comma":        {text: "2:" + testKey2 + ", 1:" + testKey1, active: 2},
+		"aes-128":      {text: "3:" + strings.Repeat("ab", 16), active: 3},
+		"empty":        {text: "# nothing", err: ErrBadKey},
+		"zero id":      {text: "0:" + testKey1, err: ErrBadKey},
+		"no id":        {text: testKey1, err: ErrBadKey},
+		"not hex":      {text: "1:" + strings.Repeat("zz", 32), err: ErrBadKey},
+		"short key":    {text: "1:abcd", err: ErrBadKey},
+		"duplicate id": {text: "1:" + testKey1 + "\n1:" + testKey2, err: ErrBadKey},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			k, err := ParseKeyring(tc.text)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.active, k.activeID())
+		})
+	}
+}
+
+func TestWAL_encryption(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	dir := t.TempDir()
+	keyFile := path.Join(t.TempDir(), "keys")
+	cfg := config.WAL{
+		BatchSize:         1,
+		BatchTimeout:      time.Millisecond,
+		MaxSegmentSize:    "10MB",
+		DataDir:           dir,
+		Compression:       config.CompressionSnappy,
+		EncryptionKeyFile: keyFile,
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "secret-value"}}
+	}
+	write := func(keys string, cmds ...command.Command) {
+		require.NoError(t, os.WriteFile(keyFile, []byte(keys), 0o600))
+		w, err := New(cfg)
+		require.NoError(t, err)
+		_, err = w.Load(ctx)
+		require.NoError(t, err)
+		for _, cmd := range cmds {
+			require.NoError(t, w.Save(ctx, cmd))
+		}
+		w.Close()
+	}
+
+	write("1:"+testKey1, set("a"))
+	// ротация: новый ключ для записи, старый остается для чтения
+	write("1:"+testKey1+"\n2:"+testKey2, set("b"))
+
+	data, err := os.ReadFile(path.Join(dir, "wal_0001.bin"))
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(data, []byte("secret-value")))
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []command.Command{set("a"), set("b")}, cmds)
+
+	// снимок тоже шифруется
+	require.NoError(t, w.Flush(ctx))
+	cp, err := w.Checkpoint()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSnapshot(ctx, cp, cmds))
+	w.Close()
+
+	snapshot := path.Join(dir, "snapshot_00000000000000000002.bin")
+	data, err = os.ReadFile(snapshot)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(data, []byte("secret-value")))
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	loaded := []command.Command{}
+	_, err = w.LoadSnapshot(ctx, func(cmd command.Command) error {
+		loaded = append(loaded, cmd)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cmds, loaded)
+	w.Close()
+
+	// оборванный зашифрованный снимок не читается
+	require.NoError(t, os.WriteFile(snapshot, data[:len(data)-5], 0o644))
+	k, err := ParseKeyring("2:" + testKey2)
+	require.NoError(t, err)
+	assert.ErrorIs(t, readSnapshot(snapshot, k, func(command.Command) error { return nil }), ErrDecrypt)
+}
+
+func TestWAL_encryptionMissingKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:        1,
+		BatchTimeout:     time.Millisecond,
+		MaxSegmentSize:   "10MB",
+		DataDir:          t.TempDir(),
+		EncryptionKeyEnv: "INMEM_TEST_WAL_KEYS",
+	}
+	t.Setenv(cfg.EncryptionKeyEnv, "1:"+testKey1)
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	require.NoError(t, w.Save(ctx, command.Command{Type: command.CommandDEL, Name: "a"}))
+	w.Close()
+
+	// без ключа журнал не читается и не обрезается как поврежденный
+	t.Setenv(cfg.EncryptionKeyEnv, "2:"+testKey2)
+	w, err = New(cfg)
+	require.NoError(t, err)
+	_, err = w.Load(ctx)
+	assert.ErrorIs(t, err, ErrNoKey)
+	w.Close()
+
+	t.Setenv(cfg.EncryptionKeyEnv, "1:"+testKey1)
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, cmds, 1)
+}
@@ -2,6 +2,7 @@ package decode
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
@@ -9,11 +10,21 @@ import (
 	"inmem-db/internal/storage/wal/encode"
 )
 
+var ErrStringTooLong = errors.New("string is too long")
+
+// MaxStringSize защищает от выделения памяти по испорченной длине строки
+const MaxStringSize = 1 << 30
+
 var CmdType2Byte = encode.CmdType2Byte
 
 var Byte2CmdType = map[byte]string{
-	CmdType2Byte[string(command.CommandSET)]: string(command.CommandSET),
-	CmdType2Byte[string(command.CommandDEL)]: string(command.CommandDEL),
+	CmdType2Byte[string(command.CommandSET)]:     string(command.CommandSET),
+	CmdType2Byte[string(command.CommandDEL)]:     string(command.CommandDEL),
+	CmdType2Byte[string(command.CommandEXPIRE)]:  string(command.CommandEXPIRE),
+	CmdType2Byte[string(command.CommandPERSIST)]: string(command.CommandPERSIST),
+	CmdType2Byte[string(command.CommandINCRBY)]:  string(command.CommandINCRBY),
+	CmdType2Byte[string(command.CommandMSET)]:    string(command.CommandMSET),
+	CmdType2Byte[string(command.CommandMDEL)]:    string(command.CommandMDEL),
 }
 
 func ReadID(r io.Reader) (int64, error) {
@@ -34,10 +45,26 @@ func ReadSize(r io.Reader) (uint32, error) {
 	return size, nil
 }
 
+// Read читает команду, длины строк записаны в uvarint
 func Read(r io.Reader) (command.Command, error) {
+	return reader{r: r}.read()
+}
+
+// ReadLegacy читает команду старого формата, в котором длины строк записаны в uint16
+func ReadLegacy(r io.Reader) (command.Command, error) {
+	return reader{r: r, legacy: true}.read()
+}
+
+type reader struct {
+	r      io.Reader
+	legacy bool
+}
+
+func (rd reader) read() (command.Command, error) {
+	r := rd.r
 	cmd := command.Command{}
 
-	cmdType, err := readType(r)
+	cmdType, hasDeadline, err := readType(r)
 	if err != nil {
 		return command.Command{}, err
 	}
@@ -47,53 +74,145 @@ func Read(r io.Reader) (command.Command, error) {
 		cmd.Type = command.CommandDEL
 	case string(command.CommandSET):
 		cmd.Type = command.CommandSET
+	case string(command.CommandEXPIRE):
+		cmd.Type = command.CommandEXPIRE
+	case string(command.CommandPERSIST):
+		cmd.Type = command.CommandPERSIST
+	case string(command.CommandINCRBY):
+		cmd.Type = command.CommandINCRBY
+	case string(command.CommandMSET):
+		cmd.Type = command.CommandMSET
+		cmd.Keys, cmd.Values, err = rd.readPairs()
+		return cmd, err
+	case string(command.CommandMDEL):
+		cmd.Type = command.CommandMDEL
+		cmd.Keys, err = rd.readList()
+		return cmd, err
 	}
 
-	name, err := readString(r)
+	name, err := rd.readString()
 	if err != nil {
 		return command.Command{}, err
 	}
 	cmd.Name = name
 
-	if cmd.Type != command.CommandSET {
-		return cmd, nil
+	switch cmd.Type {
+	case command.CommandSET:
+		value, err := rd.readString()
+		if err != nil {
+			return command.Command{}, err
+		}
+
+		cmd.Set.Value = value
+	case command.CommandINCRBY:
+		err = binary.Read(r, binary.BigEndian, &cmd.Incr.Delta)
+		if err != nil {
+			return command.Command{}, fmt.Errorf("read delta: %w", err)
+		}
 	}
 
-	value, err := readString(r)
+	if !hasDeadline {
+		return cmd, nil
+	}
+	err = binary.Read(r, binary.BigEndian, &cmd.Expire.Deadline)
 	if err != nil {
-		return command.Command{}, err
+		return command.Command{}, fmt.Errorf("read deadline: %w", err)
 	}
 
-	cmd.Set.Value = value
-
 	return cmd, nil
 }
 
-func readType(r io.Reader) (string, error) {
+func readType(r io.Reader) (string, bool, error) {
 	var typeByte byte
 	err := binary.Read(r, binary.BigEndian, &typeByte)
 	if err != nil {
-		return "", fmt.Errorf("read cmd type: %w", err)
+		return "", false, fmt.Errorf("read cmd type: %w", err)
 	}
-	cmdType, ok := Byte2CmdType[typeByte]
+	hasDeadline := typeByte&encode.FlagDeadline != 0
+	cmdType, ok := Byte2CmdType[typeByte&^encode.FlagDeadline]
 	if !ok {
-		return "", fmt.Errorf("unknown cmd type: %d", typeByte)
+		return "", false, fmt.Errorf("unknown cmd type: %d", typeByte)
 	}
-	return cmdType, nil
+	return cmdType, hasDeadline, nil
 }
 
-func readString(r io.Reader) (string, error) {
-	size := uint16(0)
-	err := binary.Read(r, binary.BigEndian, &size)
+func (rd reader) readStringSize() (uint64, error) {
+	if rd.legacy {
+		size := uint16(0)
+		err := binary.Read(rd.r, binary.BigEndian, &size)
+		return uint64(size), err
+	}
+	br, ok := rd.r.(io.ByteReader)
+	if !ok {
+		br = byteReader{rd.r}
+	}
+	return binary.ReadUvarint(br)
+}
+
+func (rd reader) readString() (string, error) {
+	size, err := rd.readStringSize()
 	if err != nil {
 		return "", fmt.Errorf("read string size: %w", err)
 	}
+	if size > MaxStringSize {
+		return "", fmt.Errorf("%w: %d bytes", ErrStringTooLong, size)
+	}
 
 	s := make([]byte, size)
-	_, err = r.Read(s)
+	_, err = io.ReadFull(rd.r, s)
 	if err != nil {
 		return "", fmt.Errorf("read string: %w", err)
 	}
 
 	return string(s), nil
 }
+
+func (rd reader) readList() ([]string, error) {
+	size, err := ReadSize(rd.r)
+	if err != nil {
+		return nil, fmt.Errorf("read list size: %w", err)
+	}
+	list := make([]string, 0, min(size, 1024))
+	for range size {
+		s, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func (rd reader) readPairs() ([]string, []string, error) {
+	size, err := ReadSize(rd.r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read pairs size: %w", err)
+	}
+	keys := make([]string, 0, min(size, 1024))
+	values := make([]string, 0, min(size, 1024))
+	for range size {
+		key, err := rd.readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := rd.readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+// byteReader читает по байту без буфера, чтобы не забрать из r лишнее.
+// Буферизованный r читается через его собственный ReadByte.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	buf := [1]byte{}
+	_, err := io.ReadFull(b.r, buf[:])
+	return buf[0], err
+}
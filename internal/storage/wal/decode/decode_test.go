@@ -2,11 +2,15 @@ package decode
 
 import (
 	"bytes"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/encode"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDecodeCmd(t *testing.T) {
@@ -14,6 +18,8 @@ func TestDecodeCmd(t *testing.T) {
 
 	type test struct {
 		bytes []byte
+		// legacy - длины строк в uint16, как до перехода на uvarint
+		legacy bool
 
 		gotCmd  command.Command
 		wantErr bool
@@ -23,7 +29,7 @@ func TestDecodeCmd(t *testing.T) {
 		"get decode": {
 			bytes: []byte{
 				CmdType2Byte[string(command.CommandGET)], // тип команды
-				0x00, 0x04,                               // размер имени
+				0x04,                                     // размер имени
 				'n', 'a', 'm', 'e',
 			},
 			wantErr: true,
@@ -31,9 +37,9 @@ func TestDecodeCmd(t *testing.T) {
 		"set decode": {
 			bytes: []byte{
 				CmdType2Byte[string(command.CommandSET)], // тип команды
-				0x00, 0x04,                               // размер имени
+				0x04,                                     // размер имени
 				'n', 'a', 'm', 'e',
-				0x00, 0x06, // размер значения
+				0x06, // размер значения
 				'v', 'a', 'l', 'u', 'e', '1',
 			},
 
@@ -45,17 +51,117 @@ func TestDecodeCmd(t *testing.T) {
 		"del decode": {
 			bytes: []byte{
 				CmdType2Byte[string(command.CommandDEL)], // тип команды
-				0x00, 0x04,                               // размер имени
+				0x04,                                     // размер имени
 				'n', 'a', 'm', 'e',
 			},
 
 			gotCmd:  command.Command{Type: command.CommandDEL, Name: "name"},
 			wantErr: false,
 		},
+		"set with deadline decode": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandSET)] | encode.FlagDeadline, // тип команды
+				0x04, // размер имени
+				'n', 'a', 'm', 'e',
+				0x01, // размер значения
+				'v',
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, // deadline
+			},
+
+			gotCmd: command.Command{
+				Type:   command.CommandSET,
+				Name:   "name",
+				Set:    command.SetArgs{Value: "v"},
+				Expire: command.ExpireArgs{Deadline: 0x0102},
+			},
+			wantErr: false,
+		},
+		"persist decode": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandPERSIST)], // тип команды
+				0x04, // размер имени
+				'n', 'a', 'm', 'e',
+			},
+
+			gotCmd:  command.Command{Type: command.CommandPERSIST, Name: "name"},
+			wantErr: false,
+		},
+		"incrby decode": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandINCRBY)], // тип команды
+				0x04, // размер имени
+				'n', 'a', 'm', 'e',
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, // изменение
+			},
+
+			gotCmd:  command.Command{Type: command.CommandINCRBY, Name: "name", Incr: command.IncrArgs{Delta: 3}},
+			wantErr: false,
+		},
+		"mset decode": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandMSET)], // тип команды
+				0x00, 0x00, 0x00, 0x02, // количество пар
+				0x01, 'a',
+				0x01, '1',
+				0x01, 'b',
+				0x01, '2',
+			},
+
+			gotCmd: command.Command{
+				Type:   command.CommandMSET,
+				Keys:   []string{"a", "b"},
+				Values: []string{"1", "2"},
+			},
+			wantErr: false,
+		},
+		"mdel decode": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandMDEL)], // тип команды
+				0x00, 0x00, 0x00, 0x01, // количество ключей
+				0x01, 'a',
+			},
+
+			gotCmd:  command.Command{Type: command.CommandMDEL, Keys: []string{"a"}},
+			wantErr: false,
+		},
+		"legacy set decode": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandSET)], // тип команды
+				0x00, 0x04,                               // размер имени
+				'n', 'a', 'm', 'e',
+				0x00, 0x01, // размер значения
+				'v',
+			},
+			legacy: true,
+
+			gotCmd:  command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "v"}},
+			wantErr: false,
+		},
+		"truncated value": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandSET)], // тип команды
+				0x04,                                     // размер имени
+				'n', 'a', 'm', 'e',
+				0x06, // размер значения
+				'v', 'a',
+			},
+
+			wantErr: true,
+		},
+		"huge value size": {
+			bytes: []byte{
+				CmdType2Byte[string(command.CommandSET)], // тип команды
+				0x04,                                     // размер имени
+				'n', 'a', 'm', 'e',
+				0xFF, 0xFF, 0xFF, 0xFF, 0x0F, // размер значения
+			},
+
+			wantErr: true,
+		},
 		"invalid cmd type": {
 			bytes: []byte{
-				0xFF,       // тип команды
-				0x00, 0x04, // размер имени
+				0xFF, // тип команды
+				0x04, // размер имени
 				'n', 'a', 'm', 'e',
 			},
 
@@ -66,8 +172,12 @@ func TestDecodeCmd(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
+			read := Read
+			if test.legacy {
+				read = ReadLegacy
+			}
 			buf := bytes.NewBuffer(test.bytes)
-			cmd, err := Read(buf)
+			cmd, err := read(buf)
 			if test.wantErr {
 				assert.Error(t, err)
 				return
@@ -78,3 +188,20 @@ func TestDecodeCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeCmd_longValue(t *testing.T) {
+	t.Parallel()
+
+	cmd := command.Command{
+		Type: command.CommandSET,
+		Name: strings.Repeat("k", 300),
+		Set:  command.SetArgs{Value: strings.Repeat("v", 200<<10)},
+	}
+	buf := bytes.Buffer{}
+	require.NoError(t, encode.Write(&buf, cmd))
+
+	// короткие чтения не должны обрезать значение
+	got, err := Read(iotest.OneByteReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, cmd, got)
+}
@@ -0,0 +1,109 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"inmem-db/internal/config"
+)
+
+var ErrClosed = errors.New("wal is closed")
+
+const defaultSyncInterval = 100 * time.Millisecond
+
+// durability отслеживает, сколько байт журнала уже сброшено на диск,
+// и будит тех, кто ждет сброса своей записи
+type durability struct {
+	mu     sync.Mutex
+	synced uint64
+	err    error
+	// changed закрывается и заменяется при каждом изменении synced или err
+	changed chan struct{}
+}
+
+func newDurability() *durability {
+	return &durability{changed: make(chan struct{})}
+}
+
+// wait ждет, пока на диск будут сброшены первые pos байт журнала
+func (d *durability) wait(pos uint64) error {
+	for {
+		d.mu.Lock()
+		synced, err, changed := d.synced, d.err, d.changed
+		d.mu.Unlock()
+
+		if synced >= pos {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		<-changed
+	}
+}
+
+// advance отмечает результат сброса. Ошибка остается навсегда: после неудачного
+// fsync нельзя полагаться на то, что данные из кэша попадут на диск.
+func (d *durability) advance(synced uint64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return
+	}
+	if err != nil {
+		d.err = err
+	} else {
+		d.synced = max(d.synced, synced)
+	}
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func validateSync(cfg config.WAL) (config.WAL, error) {
+	switch cfg.SyncMode {
+	case "":
+		cfg.SyncMode = config.SyncModeAlways
+	case config.SyncModeAlways, config.SyncModeNone:
+	case config.SyncModeInterval:
+		if cfg.SyncInterval < 0 {
+			return cfg, fmt.Errorf("invalid sync interval: %s", cfg.SyncInterval)
+		}
+		if cfg.SyncInterval == 0 {
+			cfg.SyncInterval = defaultSyncInterval
+		}
+	default:
+		return cfg, fmt.Errorf("invalid sync mode: %q", cfg.SyncMode)
+	}
+	return cfg, nil
+}
+
+// syncLoop сбрасывает журнал на диск раз в SyncInterval до закрытия журнала
+func (w *WAL) syncLoop() {
+	defer close(w.syncDone)
+
+	t := time.NewTicker(w.cfg.SyncInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			w.sync()
+			w.durable.advance(0, ErrClosed)
+			return
+		case <-t.C:
+			w.sync()
+		}
+	}
+}
+
+func (w *WAL) sync() {
+	synced, err := w.store.Sync()
+	if err != nil {
+		slog.Error("sync wal", slog.String("error", err.Error()))
+	}
+	w.durable.advance(synced, err)
+}
@@ -0,0 +1,131 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMode(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		mode     config.SyncMode
+		interval time.Duration
+
+		err bool
+	}
+
+	tests := map[string]test{
+		"default":  {},
+		"always":   {mode: config.SyncModeAlways},
+		"interval": {mode: config.SyncModeInterval, interval: 5 * time.Millisecond},
+		"none":     {mode: config.SyncModeNone},
+		"unknown":  {mode: "sometimes", err: true},
+		"negative": {mode: config.SyncModeInterval, interval: -time.Second, err: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			cfg := config.WAL{
+				BatchSize:      10,
+				BatchTimeout:   time.Millisecond,
+				MaxSegmentSize: "40B",
+				DataDir:        t.TempDir(),
+				SyncMode:       tc.mode,
+				SyncInterval:   tc.interval,
+			}
+
+			w, err := New(cfg)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			cmd := command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "value"}}
+			for range 3 {
+				require.NoError(t, w.Save(ctx, cmd))
+			}
+			require.NoError(t, w.Flush(ctx))
+			w.Close()
+
+			w, err = New(cfg)
+			require.NoError(t, err)
+			defer w.Close()
+			cmds, err := w.Load(ctx)
+			require.NoError(t, err)
+			assert.Len(t, cmds, 3)
+		})
+	}
+}
+
+func TestDurability_wait(t *testing.T) {
+	t.Parallel()
+
+	d := newDurability()
+	done := make(chan error)
+	go func() {
+		done <- d.wait(10)
+	}()
+
+	d.advance(5, nil)
+	select {
+	case <-done:
+		t.Fatal("wait returned before sync")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	d.advance(10, nil)
+	require.NoError(t, <-done)
+
+	syncErr := errors.New("disk is gone")
+	d.advance(0, syncErr)
+	assert.NoError(t, d.wait(10))
+	assert.ErrorIs(t, d.wait(11), syncErr)
+	d.advance(20, nil)
+	assert.ErrorIs(t, d.wait(11), syncErr)
+}
+
+func TestWAL_WaitSynced(t *testing.T) {
+	t.Parallel()
+
+	w, err := New(config.WAL{
+		BatchSize:      10,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+		SyncMode:       config.SyncModeInterval,
+		SyncInterval:   time.Hour,
+	})
+	require.NoError(t, err)
+
+	// сегмент от мастера записан, но еще не сброшен на диск
+	cmd := command.Command{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "value"}}
+	require.NoError(t, w.SaveSegment(encoded(t, newSegment(1, []command.Command{cmd}))))
+	done := make(chan error)
+	go func() {
+		done <- w.WaitSynced()
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("wait returned before sync")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Close сбрасывает журнал на диск
+	w.Close()
+	require.NoError(t, <-done)
+}
@@ -9,10 +9,18 @@ import (
 )
 
 var CmdType2Byte = map[string]byte{
-	string(command.CommandSET): 2,
-	string(command.CommandDEL): 3,
+	string(command.CommandSET):     2,
+	string(command.CommandDEL):     3,
+	string(command.CommandEXPIRE):  4,
+	string(command.CommandPERSIST): 5,
+	string(command.CommandINCRBY):  6,
+	string(command.CommandMSET):    7,
+	string(command.CommandMDEL):    8,
 }
 
+// FlagDeadline - бит в типе команды, после аргументов команды записан deadline
+const FlagDeadline byte = 0x80
+
 func WriteID(w io.Writer, id int64) error {
 	return binary.Write(w, binary.BigEndian, id)
 }
@@ -22,7 +30,7 @@ func WriteSize(w io.Writer, size uint32) error {
 }
 
 func Write(w io.Writer, cmd command.Command) error {
-	if cmd.Type == command.CommandGET {
+	if cmd.IsReadOnly() {
 		return nil
 	}
 
@@ -31,15 +39,38 @@ func Write(w io.Writer, cmd command.Command) error {
 		return err
 	}
 
+	switch cmd.Type {
+	case command.CommandMSET:
+		return writePairs(w, cmd.Keys, cmd.Values)
+	case command.CommandMDEL:
+		return writeList(w, cmd.Keys)
+	}
+
 	err = writeString(w, cmd.Name)
 	if err != nil {
 		return err
 	}
-	if cmd.Type != command.CommandSET {
-		return nil
+	switch cmd.Type {
+	case command.CommandSET:
+		err = writeString(w, cmd.Set.Value)
+		if err != nil {
+			return err
+		}
+	case command.CommandINCRBY:
+		err = binary.Write(w, binary.BigEndian, cmd.Incr.Delta)
+		if err != nil {
+			return fmt.Errorf("write delta: %w", err)
+		}
 	}
 
-	return writeString(w, cmd.Set.Value)
+	if cmd.Expire.Deadline == 0 {
+		return nil
+	}
+	err = binary.Write(w, binary.BigEndian, cmd.Expire.Deadline)
+	if err != nil {
+		return fmt.Errorf("write deadline: %w", err)
+	}
+	return nil
 }
 
 func writeType(w io.Writer, cmd command.Command) error {
@@ -47,6 +78,9 @@ func writeType(w io.Writer, cmd command.Command) error {
 	if !ok {
 		return fmt.Errorf("unknown cmd type: %s", cmd.Type)
 	}
+	if cmd.Expire.Deadline != 0 {
+		b |= FlagDeadline
+	}
 	_, err := w.Write([]byte{b})
 	if err != nil {
 		return fmt.Errorf("write cmd type: %w", err)
@@ -54,15 +88,51 @@ func writeType(w io.Writer, cmd command.Command) error {
 	return nil
 }
 
+// writeString пишет длину строки в uvarint и саму строку
 func writeString(w io.Writer, s string) error {
-	err := binary.Write(w, binary.BigEndian, uint16(len(s)))
+	_, err := w.Write(binary.AppendUvarint(nil, uint64(len(s))))
 	if err != nil {
 		return fmt.Errorf("write string size: %w", err)
 	}
 
-	_, err = w.Write([]byte(s))
+	_, err = io.WriteString(w, s)
 	if err != nil {
 		return fmt.Errorf("write string: %w", err)
 	}
 	return nil
 }
+
+func writeList(w io.Writer, list []string) error {
+	err := WriteSize(w, uint32(len(list)))
+	if err != nil {
+		return fmt.Errorf("write list size: %w", err)
+	}
+	for _, s := range list {
+		err = writeString(w, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePairs(w io.Writer, keys []string, values []string) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("keys and values count mismatch: %d != %d", len(keys), len(values))
+	}
+	err := WriteSize(w, uint32(len(keys)))
+	if err != nil {
+		return fmt.Errorf("write pairs size: %w", err)
+	}
+	for i := range keys {
+		err = writeString(w, keys[i])
+		if err != nil {
+			return err
+		}
+		err = writeString(w, values[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -29,18 +29,103 @@ func TestEncodeCmd(t *testing.T) {
 			}},
 			wantBytes: []byte{
 				CmdType2Byte[string(command.CommandSET)], // тип команды
-				0x00, 0x04,                               // размер имени
+				0x04,                                     // размер имени
 				'n', 'a', 'm', 'e',
-				0x00, 0x06, // размер значения
+				0x06, // размер значения
 				'v', 'a', 'l', 'u', 'e', '1',
 			},
 			wantErr: false,
 		},
+		"set with deadline encode": {
+			cmd: command.Command{
+				Type:   command.CommandSET,
+				Name:   "name",
+				Set:    command.SetArgs{Value: "v"},
+				Expire: command.ExpireArgs{Deadline: 0x0102},
+			},
+			wantBytes: []byte{
+				CmdType2Byte[string(command.CommandSET)] | FlagDeadline, // тип команды
+				0x04, // размер имени
+				'n', 'a', 'm', 'e',
+				0x01, // размер значения
+				'v',
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, // deadline
+			},
+			wantErr: false,
+		},
+		"expire encode": {
+			cmd: command.Command{
+				Type:   command.CommandEXPIRE,
+				Name:   "name",
+				Expire: command.ExpireArgs{Deadline: 0x0102},
+			},
+			wantBytes: []byte{
+				CmdType2Byte[string(command.CommandEXPIRE)] | FlagDeadline, // тип команды
+				0x04, // размер имени
+				'n', 'a', 'm', 'e',
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, // deadline
+			},
+			wantErr: false,
+		},
+		"incrby encode": {
+			cmd: command.Command{
+				Type: command.CommandINCRBY,
+				Name: "name",
+				Incr: command.IncrArgs{Delta: -2},
+			},
+			wantBytes: []byte{
+				CmdType2Byte[string(command.CommandINCRBY)], // тип команды
+				0x04, // размер имени
+				'n', 'a', 'm', 'e',
+				0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE, // изменение
+			},
+			wantErr: false,
+		},
+		"mset encode": {
+			cmd: command.Command{
+				Type:   command.CommandMSET,
+				Keys:   []string{"a", "b"},
+				Values: []string{"1", "2"},
+			},
+			wantBytes: []byte{
+				CmdType2Byte[string(command.CommandMSET)], // тип команды
+				0x00, 0x00, 0x00, 0x02, // количество пар
+				0x01, 'a',
+				0x01, '1',
+				0x01, 'b',
+				0x01, '2',
+			},
+			wantErr: false,
+		},
+		"mset without values": {
+			cmd: command.Command{
+				Type: command.CommandMSET,
+				Keys: []string{"a", "b"},
+			},
+			wantErr: true,
+		},
+		"mdel encode": {
+			cmd: command.Command{
+				Type: command.CommandMDEL,
+				Keys: []string{"a", "b"},
+			},
+			wantBytes: []byte{
+				CmdType2Byte[string(command.CommandMDEL)], // тип команды
+				0x00, 0x00, 0x00, 0x02, // количество ключей
+				0x01, 'a',
+				0x01, 'b',
+			},
+			wantErr: false,
+		},
+		"ttl encode": {
+			cmd:     command.Command{Type: command.CommandTTL, Name: "name"},
+			wantErr: false,
+		},
 		"del encode": {
 			cmd: command.Command{Type: command.CommandDEL, Name: "name"},
 			wantBytes: []byte{
 				CmdType2Byte[string(command.CommandDEL)], // тип команды
-				0x00, 0x04,                               // размер имени
+				0x04,                                     // размер имени
 				'n', 'a', 'm', 'e',
 			},
 			wantErr: false,
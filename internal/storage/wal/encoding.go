@@ -1,20 +1,118 @@
 package wal
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"time"
 
 	"inmem-db/internal/domain/command"
 	"inmem-db/internal/storage/wal/decode"
 	"inmem-db/internal/storage/wal/encode"
 )
 
+var (
+	ErrTruncated = errors.New("segment is truncated")
+	ErrCorrupted = errors.New("segment is corrupted")
+	ErrTooLarge  = errors.New("segment is too large")
+)
+
+const (
+	// frameHeaderSize - длина сегмента и CRC32C перед флагами и содержимым
+	frameHeaderSize = 8
+	// maxFrameSize защищает от выделения памяти по испорченной длине
+	maxFrameSize = 1 << 30
+	// maxSegmentPayload - после такого объема команд пачка продолжается в новом сегменте,
+	// больше него бывает только сегмент из одного Push
+	maxSegmentPayload = maxFrameSize / 4
+	// cmdOverhead - верхняя оценка байт команды помимо ключей и значений
+	cmdOverhead = 32
+
+	// flagTime - бит флагов сегмента: после ID записано время записи сегмента
+	flagTime byte = 0x08
+	// flagVarint - бит флагов сегмента: длины строк в командах записаны в uvarint, без него - в uint16
+	flagVarint byte = 0x10
+)
+
+// cmdSize оценивает размер закодированной команды сверху
+func cmdSize(cmd command.Command) int {
+	size := len(cmd.Name) + len(cmd.Set.Value) + cmdOverhead
+	for _, key := range cmd.Keys {
+		size += len(key) + cmdOverhead
+	}
+	for _, value := range cmd.Values {
+		size += len(value)
+	}
+	return size
+}
+
+// EncodeSegment пишет сегмент одним вызовом Write: длина, CRC32C, флаги, содержимое.
+// Сегмент, прочитанный из журнала или от мастера, пишется в том виде, в котором пришел.
 func EncodeSegment(w io.Writer, segment Segment) error {
+	frame := segment.raw
+	if frame == nil {
+		var err error
+		frame, err = encodeFrame(segment, codecNone, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(frame)
+	if err != nil {
+		return fmt.Errorf("write segment '%d': %w", segment.ID, err)
+	}
+	return nil
+}
+
+// encodeFrame кодирует сегмент, сжимая содержимое кодеком c и шифруя активным ключом keys
+func encodeFrame(segment Segment, c codec, keys *Keyring) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 64*len(segment.commands)))
+	err := encodePayload(buf, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	c, body := compress(c, buf.Bytes())
+	flags := byte(c) | flagVarint
+	if !segment.Time.IsZero() {
+		flags |= flagTime
+	}
+	if keys.activeID() != 0 {
+		flags |= flagEncrypted
+		body = keys.seal(flags, body)
+	}
+	if 1+len(body) > maxFrameSize {
+		// такой сегмент не прочитается при восстановлении
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, 1+len(body))
+	}
+	frame := make([]byte, frameHeaderSize+1+len(body))
+	frame[frameHeaderSize] = flags
+	copy(frame[frameHeaderSize+1:], body)
+
+	sealed := frame[frameHeaderSize:]
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(sealed)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(sealed, castagnoli))
+	return frame, nil
+}
+
+// encodePayload пишет ID, время записи, если оно есть, и команды сегмента
+func encodePayload(w io.Writer, segment Segment) error {
 	err := encode.WriteID(w, int64(segment.ID))
 	if err != nil {
 		return fmt.Errorf("encode segment id: %w", err)
 	}
 
+	if !segment.Time.IsZero() {
+		err = binary.Write(w, binary.BigEndian, segment.Time.UnixNano())
+		if err != nil {
+			return fmt.Errorf("encode segment time: %w", err)
+		}
+	}
+
 	err = encode.WriteSize(w, uint32(len(segment.commands)))
 	if err != nil {
 		return fmt.Errorf("encode segment size: %w", err)
@@ -30,27 +128,142 @@ func EncodeSegment(w io.Writer, segment Segment) error {
 	return nil
 }
 
+// DecodeSegment читает незашифрованный сегмент и проверяет его CRC.
+// Возвращает io.EOF, если данных больше нет, ErrTruncated, если сегмент оборван,
+// и ErrCorrupted, если содержимое не совпадает с CRC.
 func DecodeSegment(r io.Reader) (Segment, error) {
+	return decodeSegment(r, nil)
+}
+
+// decodeSegment читает сегмент, зашифрованный сегмент расшифровывается ключом из keys.
+// Ошибка расшифровки - не след сбоя записи, поэтому она не ErrCorrupted.
+func decodeSegment(r io.Reader, keys *Keyring) (Segment, error) {
+	frame, err := readFrame(r)
+	if err != nil {
+		return Segment{}, err
+	}
+	sealed := frame[frameHeaderSize:]
+	if len(sealed) == 0 {
+		return Segment{}, fmt.Errorf("%w: empty segment", ErrCorrupted)
+	}
+
+	flags, body := sealed[0], sealed[1:]
+	if flags&flagEncrypted != 0 {
+		body, err = keys.open(flags, body)
+		if err != nil {
+			return Segment{}, err
+		}
+	}
+	payload, err := decompress(codec(flags&codecMask), body)
+	if err != nil {
+		return Segment{}, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	segment, err := decodeFramePayload(payload, flags)
+	if err != nil {
+		return Segment{}, err
+	}
+	segment.raw = frame
+	return segment, nil
+}
+
+// readFrame читает сегмент целиком вместе с заголовком и проверяет CRC
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("%w: size %d", ErrCorrupted, size)
+	}
+
+	frame := make([]byte, frameHeaderSize+int(size))
+	copy(frame, header)
+	_, err = io.ReadFull(r, frame[frameHeaderSize:])
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+	if crc32.Checksum(frame[frameHeaderSize:], castagnoli) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+	return frame, nil
+}
+
+// hasFrame сообщает, что с какого-то смещения в data начинается сегмент с верной CRC.
+// Пустые сегменты не считаются: нули в конце файла выглядят как пустой сегмент.
+func hasFrame(data []byte) bool {
+	for p := 0; p+frameHeaderSize <= len(data); p++ {
+		size := int(binary.BigEndian.Uint32(data[p : p+4]))
+		end := p + frameHeaderSize + size
+		if size == 0 || end > len(data) {
+			continue
+		}
+		if crc32.Checksum(data[p+frameHeaderSize:end], castagnoli) == binary.BigEndian.Uint32(data[p+4:p+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeFramePayload(payload []byte, flags byte) (Segment, error) {
+	buf := bytes.NewReader(payload)
+	segment, err := decodePayload(buf, flags)
+	if err != nil {
+		return Segment{}, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	if buf.Len() != 0 {
+		return Segment{}, fmt.Errorf("%w: %d extra bytes", ErrCorrupted, buf.Len())
+	}
+	return segment, nil
+}
+
+// decodePayload читает содержимое сегмента в формате из флагов,
+// у сегментов старого формата без заголовка флаги нулевые
+func decodePayload(r io.Reader, flags byte) (Segment, error) {
 	id, err := decode.ReadID(r)
 	if err != nil {
 		return Segment{}, fmt.Errorf("decode segment id: %w", err)
 	}
 
+	var at time.Time
+	if flags&flagTime != 0 {
+		nanos := int64(0)
+		err = binary.Read(r, binary.BigEndian, &nanos)
+		if err != nil {
+			return Segment{}, fmt.Errorf("decode segment time: %w", err)
+		}
+		at = time.Unix(0, nanos)
+	}
+
 	size, err := decode.ReadSize(r)
 	if err != nil {
 		return Segment{}, fmt.Errorf("decode segment size: %w", err)
 	}
 	segment := Segment{
 		ID:       ID(id),
-		commands: make([]command.Command, size),
+		Time:     at,
+		commands: make([]command.Command, 0, min(size, 1024)),
 	}
 
-	for i := range size {
-		cmd, err := decode.Read(r)
+	read := decode.ReadLegacy
+	if flags&flagVarint != 0 {
+		read = decode.Read
+	}
+	for range size {
+		cmd, err := read(r)
 		if err != nil {
 			return Segment{}, fmt.Errorf("decode command of segment '%d': %w", segment.ID, err)
 		}
-		segment.commands[i] = cmd
+		segment.commands = append(segment.commands, cmd)
 	}
 
 	return segment, nil
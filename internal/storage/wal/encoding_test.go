@@ -2,7 +2,10 @@ package wal
 
 import (
 	"bytes"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"inmem-db/internal/domain/command"
 
@@ -26,10 +29,82 @@ func TestEncodeDecodeSegment(t *testing.T) {
 		},
 	}
 	segment := newSegment(ID(123), commands)
+	segment.Time = time.Unix(0, 1700000000123456789)
 	buf := bytes.Buffer{}
 	err := EncodeSegment(&buf, segment)
 	require.NoError(t, err)
 	gotSegment, err := DecodeSegment(&buf)
 	require.NoError(t, err)
-	assert.Equal(t, segment, gotSegment)
+	assert.Equal(t, segment.ID, gotSegment.ID)
+	assert.True(t, segment.Time.Equal(gotSegment.Time))
+	assert.Equal(t, segment.commands, gotSegment.commands)
+}
+
+func TestEncodeFrame_compression(t *testing.T) {
+	t.Parallel()
+
+	value := strings.Repeat(`{"name":"value","tags":["a","b"]}`, 20)
+	commands := []command.Command{
+		{Type: command.CommandSET, Name: "json1", Set: command.SetArgs{Value: value}},
+		{Type: command.CommandSET, Name: "json2", Set: command.SetArgs{Value: value}},
+	}
+	segment := newSegment(ID(7), commands)
+	plain, err := encodeFrame(segment, codecNone, nil)
+	require.NoError(t, err)
+
+	for _, c := range []codec{codecSnappy, codecZstd} {
+		frame, err := encodeFrame(segment, c, nil)
+		require.NoError(t, err)
+		assert.Less(t, len(frame), len(plain)/4)
+
+		got, err := DecodeSegment(bytes.NewReader(frame))
+		require.NoError(t, err)
+		assert.Equal(t, commands, got.commands)
+
+		// прочитанный сегмент пересылается в том же виде
+		buf := bytes.Buffer{}
+		require.NoError(t, EncodeSegment(&buf, got))
+		assert.Equal(t, frame, buf.Bytes())
+	}
+
+	// мелкий сегмент, который не сжимается, пишется как есть
+	small := newSegment(ID(8), []command.Command{{Type: command.CommandDEL, Name: "k"}})
+	frame, err := encodeFrame(small, codecZstd, nil)
+	require.NoError(t, err)
+	assert.Equal(t, byte(codecNone), frame[frameHeaderSize]&codecMask)
+}
+
+func TestDecodeSegment_damaged(t *testing.T) {
+	t.Parallel()
+
+	segment := newSegment(ID(1), []command.Command{
+		{Type: command.CommandSET, Name: "name", Set: command.SetArgs{Value: "value"}},
+	})
+	buf := bytes.Buffer{}
+	require.NoError(t, EncodeSegment(&buf, segment))
+	data := buf.Bytes()
+
+	type test struct {
+		data []byte
+		err  error
+	}
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)-1] ^= 0xff
+
+	tests := map[string]test{
+		"empty":        {data: nil, err: io.EOF},
+		"torn header":  {data: data[:3], err: ErrTruncated},
+		"torn payload": {data: data[:len(data)-2], err: ErrTruncated},
+		"flipped byte": {data: flipped, err: ErrCorrupted},
+		"huge length":  {data: []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, err: ErrCorrupted},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := DecodeSegment(bytes.NewReader(tc.data))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
 }
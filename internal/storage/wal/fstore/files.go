@@ -1,12 +1,17 @@
 package fstore
 
 import (
-	"bytes"
+	"bufio"
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
+	"slices"
 	"sync"
+	"time"
 
 	"inmem-db/internal/config"
 )
@@ -14,20 +19,43 @@ import (
 const (
 	namePattern = "wal_[0-9]*.bin"
 	nameFormat  = "wal_%04d.bin"
+
+	readBufferSize = 64 << 10
 )
 
+// File - открытый на чтение файл журнала
+type File struct {
+	Header Header
+	*bufio.Reader
+
+	f *os.File
+}
+
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
 type FStore struct {
-	mu       sync.Mutex
-	opened   *os.File
-	filesCnt uint
+	mu     sync.Mutex
+	opened *os.File
+	// lastNum - номер последнего файла журнала
+	lastNum uint
+	// openedNum - номер открытого файла
+	openedNum uint
 
 	dir         string
 	maxFileSize uint64
 	written     uint64
+	// sinceRotate - сколько байт записано после последнего Rotate
+	sinceRotate uint64
+	// total - сколько байт записано с момента создания
+	total uint64
+
+	syncMode config.SyncMode
 }
 
 func New(cfg config.WAL) (*FStore, error) {
-	maxSize, err := parseSize(cfg.MaxSegmentSize)
+	maxSize, err := config.ParseSize(cfg.MaxSegmentSize)
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +63,7 @@ func New(cfg config.WAL) (*FStore, error) {
 	s := FStore{
 		dir:         cfg.DataDir,
 		maxFileSize: maxSize,
+		syncMode:    cfg.SyncMode,
 	}
 
 	return &s, nil
@@ -44,14 +73,21 @@ func (s *FStore) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.opened != nil {
-		return s.opened.Close()
+	if s.opened == nil {
+		return nil
 	}
-	return nil
+	if s.syncMode != config.SyncModeNone {
+		err := s.opened.Sync()
+		if err != nil {
+			s.opened.Close()
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	return s.opened.Close()
 }
 
-// Write - записывает данные в открытый файл, используйте ReadAll перед первым вызовом Write.
-// Вы можете стереть существующие файлы, если не вызовете ReadAll.
+// Write - записывает данные в открытый файл, журнал читается через Open до первого вызова Write.
+// Данные попадают на диск только после Sync.
 func (s *FStore) Write(data []byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -63,13 +99,13 @@ func (s *FStore) Write(data []byte) (int, error) {
 		}
 	}
 
-	defer s.opened.Sync()
-
 	written, err := s.opened.Write(data)
 	if err != nil {
 		return 0, fmt.Errorf("write '%v' : %w", data, err)
 	}
 	s.written += uint64(written)
+	s.sinceRotate += uint64(written)
+	s.total += uint64(written)
 
 	if s.written > s.maxFileSize {
 		err := s.openNewFile()
@@ -81,8 +117,117 @@ func (s *FStore) Write(data []byte) (int, error) {
 	return written, nil
 }
 
-// ReadAll загружает все данные из файлов в папке
-func (s *FStore) ReadAll() ([]byte, error) {
+// Rotate закрывает текущий файл, следующая запись начнет новый.
+// Возвращает номер первого файла, в который попадут следующие записи:
+// все записанное до вызова Rotate лежит в файлах с меньшими номерами.
+func (s *FStore) Rotate() (uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened == nil {
+		err := s.openLastUsed()
+		if err != nil {
+			return 0, fmt.Errorf("open last used file: %w", err)
+		}
+	}
+
+	if s.written > 0 {
+		err := s.openNewFile()
+		if err != nil {
+			return 0, fmt.Errorf("open new file: %w", err)
+		}
+	}
+	s.sinceRotate = 0
+	return s.openedNum, nil
+}
+
+// SinceRotate возвращает объем записей после последнего Rotate
+func (s *FStore) SinceRotate() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sinceRotate
+}
+
+// RemoveBefore удаляет файлы журнала с номерами меньше num
+func (s *FStore) RemoveBefore(num uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := filesInDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("files in dir: %w", err)
+	}
+
+	for _, name := range files {
+		if fileNum(name) >= num {
+			continue
+		}
+		slog.Info("remove wal file", slog.String("name", name))
+		err := os.Remove(path.Join(s.dir, name))
+		if err != nil {
+			return fmt.Errorf("remove: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemoveAfter удаляет файлы журнала с номерами больше num, вызывается до первой записи
+func (s *FStore) RemoveAfter(num uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened != nil {
+		return fmt.Errorf("remove after %d: store is already opened for writing", num)
+	}
+	files, err := filesInDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("files in dir: %w", err)
+	}
+
+	for _, name := range files {
+		if fileNum(name) <= num {
+			continue
+		}
+		slog.Info("remove wal file", slog.String("name", name))
+		err := os.Remove(path.Join(s.dir, name))
+		if err != nil {
+			return fmt.Errorf("remove: %w", err)
+		}
+	}
+	return nil
+}
+
+// FileNum возвращает номер файла журнала из его имени
+func FileNum(name string) uint {
+	return fileNum(name)
+}
+
+// Sync сбрасывает открытый файл на диск и возвращает, сколько байт с момента
+// создания гарантированно сохранено. Закрытые файлы сбрасываются при переключении.
+func (s *FStore) Sync() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened == nil {
+		return s.total, nil
+	}
+	err := s.opened.Sync()
+	if err != nil {
+		return 0, fmt.Errorf("sync: %w", err)
+	}
+	return s.total, nil
+}
+
+// Written возвращает, сколько байт записано с момента создания
+func (s *FStore) Written() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// Files возвращает имена файлов журнала по порядку
+func (s *FStore) Files() ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -90,48 +235,112 @@ func (s *FStore) ReadAll() ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("files in dir: %w", err)
 	}
+	if len(files) > 0 {
+		s.lastNum = max(s.lastNum, fileNum(files[len(files)-1]))
+	}
+	return files, nil
+}
+
+// Open открывает файл журнала на чтение, сегменты читаются из File после заголовка
+func (s *FStore) Open(name string) (*File, error) {
+	f, err := os.Open(path.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	r := bufio.NewReaderSize(f, readBufferSize)
+	// Peek возвращает меньше HeaderSize байт, если файл короче
+	raw, _ := r.Peek(HeaderSize)
+	h, _, err := splitHeader(raw)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if h.Version != VersionLegacy && len(raw) > 0 {
+		_, _ = r.Discard(HeaderSize)
+	}
+	return &File{Header: h, Reader: r, f: f}, nil
+}
 
-	data, err := s.readFiles(files)
+// Truncate обрезает файл журнала до size байт, вызывается до первой записи
+func (s *FStore) Truncate(name string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened != nil {
+		return fmt.Errorf("truncate %s: store is already opened for writing", name)
+	}
+	err := os.Truncate(path.Join(s.dir, name), size)
 	if err != nil {
-		return nil, fmt.Errorf("read files: %w", err)
+		return fmt.Errorf("truncate: %w", err)
 	}
+	return nil
+}
+
+// Rewrite атомарно заменяет файл журнала файлом текущей версии с сегментами из write
+func (s *FStore) Rewrite(name string, write func(w io.Writer) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return data, nil
+	if s.opened != nil {
+		return fmt.Errorf("rewrite %s: store is already opened for writing", name)
+	}
+	return WriteFileAtomic(path.Join(s.dir, name), func(w io.Writer) error {
+		_, err := w.Write(encodeHeader(Header{Version: Version, Created: time.Now()}))
+		if err != nil {
+			return fmt.Errorf("write header: %w", err)
+		}
+		return write(w)
+	})
 }
 
 func (s *FStore) openNewFile() error {
-	s.filesCnt++
-	name := fmt.Sprintf(nameFormat, s.filesCnt)
+	s.lastNum++
+	name := fmt.Sprintf(nameFormat, s.lastNum)
 	f, err := os.Create(path.Join(s.dir, name))
 	if err != nil {
 		return fmt.Errorf("create: %w", err)
 	}
-	s.opened.Close()
+	if s.opened != nil {
+		err := s.closeOpened()
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if s.syncMode != config.SyncModeNone {
+		// иначе после сбоя новый файл может пропасть вместе с записанным в него
+		err := SyncDir(s.dir)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
 
 	s.written = 0
 	s.opened = f
-	return nil
+	s.openedNum = s.lastNum
+	return s.writeHeader()
 }
 
-func (s *FStore) readFiles(names []string) ([]byte, error) {
-	cnt := uint(0)
-	slog.Debug("find entries", slog.Int("cnt", len(names)))
-
-	sumData := &bytes.Buffer{}
-
-	for _, name := range names {
-		name = path.Join(s.dir, name)
+func (s *FStore) writeHeader() error {
+	written, err := s.opened.Write(encodeHeader(Header{Version: Version, Created: time.Now()}))
+	if err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	s.written += uint64(written)
+	return nil
+}
 
-		data, err := readFile(name)
+// closeOpened закрывает заполненный файл, Sync сбрасывает только открытый
+func (s *FStore) closeOpened() error {
+	if s.syncMode != config.SyncModeNone {
+		err := s.opened.Sync()
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("sync: %w", err)
 		}
-		cnt++
-		sumData.Write(data)
 	}
-
-	s.filesCnt = cnt
-	return sumData.Bytes(), nil
+	return s.opened.Close()
 }
 
 func (s *FStore) openLastUsed() error {
@@ -147,6 +356,16 @@ func (s *FStore) openLastUsed() error {
 	last := files[len(files)-1]
 	name := path.Join(s.dir, last)
 
+	h, err := readHeader(name)
+	if err != nil {
+		return err
+	}
+	// в файл старой версии не дописываем, чтобы в нем не смешались форматы
+	if h.Version != Version {
+		s.lastNum = max(s.lastNum, fileNum(last))
+		return s.openNewFile()
+	}
+
 	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0o766)
 	if err != nil {
 		return fmt.Errorf("open last: %w", err)
@@ -158,18 +377,44 @@ func (s *FStore) openLastUsed() error {
 
 	s.opened = f
 	s.written = uint64(stat.Size())
+	s.openedNum = fileNum(last)
+	s.lastNum = max(s.lastNum, s.openedNum)
 
+	// сбой сразу после создания файла
+	if s.written == 0 {
+		return s.writeHeader()
+	}
 	return nil
 }
 
+// readHeader читает заголовок файла, не загружая сегменты
+func readHeader(name string) (Header, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Header{}, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, HeaderSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Header{}, fmt.Errorf("read header: %w", err)
+	}
+	h, _, err := splitHeader(buf[:n])
+	if err != nil {
+		return Header{}, fmt.Errorf("%s: %w", path.Base(name), err)
+	}
+	return h, nil
+}
+
 func filesInDir(dir string) ([]string, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("read dir: %w", err)
 	}
 
-	names := make([]string, len(entries))
-	for i, e := range entries {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
@@ -178,12 +423,23 @@ func filesInDir(dir string) ([]string, error) {
 			continue
 		}
 
-		names[i] = e.Name()
+		names = append(names, e.Name())
 	}
+	// после wal_9999.bin имена длиннее, поэтому порядок по номеру, а не по имени
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Compare(fileNum(a), fileNum(b))
+	})
 
 	return names, nil
 }
 
+// fileNum возвращает номер файла журнала из его имени
+func fileNum(name string) uint {
+	num := uint(0)
+	_, _ = fmt.Sscanf(name, nameFormat, &num)
+	return num
+}
+
 func skipFile(name string) bool {
 	ok, err := path.Match(namePattern, name)
 	if err != nil || !ok {
@@ -193,12 +449,50 @@ func skipFile(name string) bool {
 	return false
 }
 
-func readFile(name string) ([]byte, error) {
-	slog.Debug("read file", slog.String("name", name))
+// WriteFileAtomic пишет файл во временный и переименовывает его,
+// поэтому после сбоя остается либо старый файл, либо новый целиком
+func WriteFileAtomic(name string, write func(w io.Writer) error) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer os.Remove(tmp)
 
-	data, err := os.ReadFile(name)
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
 	if err != nil {
-		return nil, fmt.Errorf("read file: %w", err)
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("close: %w", closeErr)
 	}
-	return data, nil
+
+	err = os.Rename(tmp, name)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return SyncDir(path.Dir(name))
+}
+
+// SyncDir сбрасывает на диск записи каталога о созданных и переименованных файлах
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir: %w", err)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("sync dir: %w", err)
+	}
+	return nil
 }
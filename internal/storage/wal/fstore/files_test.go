@@ -2,6 +2,7 @@ package fstore
 
 import (
 	"crypto/rand"
+	"io"
 	"testing"
 	"time"
 
@@ -35,8 +36,18 @@ func TestFiles(t *testing.T) {
 
 	s, err = New(cfg)
 	require.NoError(t, err)
-	readData, err := s.ReadAll()
+	files, err := s.Files()
 	require.NoError(t, err)
+	readData := []byte{}
+	for _, name := range files {
+		f, err := s.Open(name)
+		require.NoError(t, err)
+		assert.Equal(t, Version, f.Header.Version)
+		body, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		readData = append(readData, body...)
+	}
 	err = s.Close()
 	require.NoError(t, err)
 
@@ -0,0 +1,80 @@
+package fstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrBadHeader          = errors.New("bad wal file header")
+	ErrUnsupportedVersion = errors.New("unsupported wal file version")
+	// ErrTornHeader - файл короче заголовка, но начинается как заголовок: сбой при создании файла
+	ErrTornHeader = errors.New("torn wal file header")
+)
+
+const (
+	magic = "INMEMWAL"
+
+	// VersionLegacy - файл без заголовка, записанный до появления версий
+	VersionLegacy uint16 = 0
+	// Version - версия, в которой пишутся новые файлы
+	Version uint16 = 2
+
+	// HeaderSize - magic, версия, флаги, время создания и CRC32C заголовка
+	HeaderSize = len(magic) + 2 + 2 + 8 + 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Header - заголовок файла журнала
+type Header struct {
+	Version uint16
+	Created time.Time
+}
+
+func encodeHeader(h Header) []byte {
+	buf := make([]byte, 0, HeaderSize)
+	buf = append(buf, magic...)
+	buf = binary.BigEndian.AppendUint16(buf, h.Version)
+	// флаги зарезервированы
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Created.UnixNano()))
+	return binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, castagnoli))
+}
+
+// splitHeader отделяет заголовок от сегментов. Файл без magic - старый формат без заголовка.
+// Файл короче заголовка, который начинается с magic или с ее части, записан не до конца.
+func splitHeader(data []byte) (Header, []byte, error) {
+	if len(data) == 0 {
+		return Header{Version: Version}, nil, nil
+	}
+	if len(data) < len(magic) && bytes.HasPrefix([]byte(magic), data) {
+		return Header{}, nil, fmt.Errorf("%w: %d bytes", ErrTornHeader, len(data))
+	}
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return Header{Version: VersionLegacy}, data, nil
+	}
+	if len(data) < HeaderSize {
+		return Header{}, nil, fmt.Errorf("%w: %d bytes", ErrTornHeader, len(data))
+	}
+
+	raw := data[:HeaderSize]
+	sum := binary.BigEndian.Uint32(raw[HeaderSize-4:])
+	if crc32.Checksum(raw[:HeaderSize-4], castagnoli) != sum {
+		return Header{}, nil, fmt.Errorf("%w: checksum mismatch", ErrBadHeader)
+	}
+
+	raw = raw[len(magic):]
+	h := Header{
+		Version: binary.BigEndian.Uint16(raw[0:2]),
+		Created: time.Unix(0, int64(binary.BigEndian.Uint64(raw[4:12]))),
+	}
+	if h.Version != Version {
+		return Header{}, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+	return h, data[HeaderSize:], nil
+}
@@ -0,0 +1,63 @@
+package fstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitHeader(t *testing.T) {
+	t.Parallel()
+
+	created := time.Unix(0, time.Now().UnixNano())
+	header := encodeHeader(Header{Version: Version, Created: created})
+	future := encodeHeader(Header{Version: Version + 1, Created: created})
+	damaged := append([]byte{}, header...)
+	damaged[len(magic)+5] ^= 0xff
+
+	type test struct {
+		data []byte
+
+		header Header
+		body   []byte
+		err    error
+	}
+
+	tests := map[string]test{
+		"current": {
+			data:   append(append([]byte{}, header...), 1, 2, 3),
+			header: Header{Version: Version, Created: created},
+			body:   []byte{1, 2, 3},
+		},
+		"legacy": {
+			data:   []byte{0, 0, 0, 0, 1},
+			header: Header{Version: VersionLegacy},
+			body:   []byte{0, 0, 0, 0, 1},
+		},
+		"empty": {
+			header: Header{Version: Version},
+		},
+		"torn":       {data: header[:HeaderSize-1], err: ErrTornHeader},
+		"torn magic": {data: header[:3], err: ErrTornHeader},
+		"damaged":    {data: damaged, err: ErrBadHeader},
+		"newer":      {data: future, err: ErrUnsupportedVersion},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h, body, err := splitHeader(tc.data)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.header.Version, h.Version)
+			assert.True(t, tc.header.Created.Equal(h.Created))
+			assert.Equal(t, tc.body, body)
+		})
+	}
+}
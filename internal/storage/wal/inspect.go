@@ -0,0 +1,200 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/fstore"
+)
+
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// SegmentInfo - сегмент и его место в журнале
+type SegmentInfo struct {
+	File string
+	// Offset - смещение от начала файла, Size - размер сегмента на диске
+	Offset   int64
+	Size     int64
+	ID       ID
+	Time     time.Time
+	Commands []command.Command
+}
+
+// Inspect передает в fn сегменты всех файлов журнала по порядку, в том числе
+// покрытые снимком, и ничего не меняет на диске. Повреждение в середине журнала - ошибка,
+// оборванный хвост последнего файла возвращается в TornTail.
+func (w *WAL) Inspect(ctx context.Context, fn func(info SegmentInfo) error) (*TornTail, error) {
+	files, err := w.store.Files()
+	if err != nil {
+		return nil, fmt.Errorf("load files: %w", err)
+	}
+
+	for i, name := range files {
+		_, _, tail, err := w.scanFile(ctx, files, i, func(segment Segment, offset, size int64) error {
+			return fn(SegmentInfo{
+				File:     name,
+				Offset:   offset,
+				Size:     size,
+				ID:       segment.ID,
+				Time:     segment.Time,
+				Commands: segment.commands,
+			})
+		})
+		if err != nil || tail != nil {
+			return tail, err
+		}
+	}
+	return nil, nil
+}
+
+// SnapshotInfo - файл снимка и результат его проверки
+type SnapshotInfo struct {
+	Name string
+	ID   ID
+	Err  error
+}
+
+// Snapshots проверяет все снимки в каталоге журнала
+func (w *WAL) Snapshots() ([]SnapshotInfo, error) {
+	names, err := snapshotFiles(w.cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SnapshotInfo, 0, len(names))
+	for _, name := range names {
+		err := readSnapshot(path.Join(w.cfg.DataDir, name), w.keys, func(command.Command) error { return nil })
+		infos = append(infos, SnapshotInfo{Name: name, ID: snapshotID(name), Err: err})
+	}
+	return infos, nil
+}
+
+// Report - результат проверки каталога журнала
+type Report struct {
+	Files    int
+	Segments int
+	FirstID  ID
+	LastID   ID
+	// SnapshotID - сегмент самого нового целого снимка
+	SnapshotID ID
+	Snapshots  []SnapshotInfo
+	Tail       *TornTail
+	// Problems - разрывы и другие ошибки, из-за которых восстановление не удастся
+	Problems []string
+}
+
+// OK сообщает, что журнал восстановится без потерь и без обрезки
+func (r Report) OK() bool {
+	return len(r.Problems) == 0 && r.Tail == nil
+}
+
+// Verify читает весь журнал и снимки и проверяет, что сегменты после снимка идут подряд
+func (w *WAL) Verify(ctx context.Context) (Report, error) {
+	report := Report{}
+
+	snapshots, err := w.Snapshots()
+	if err != nil {
+		return report, err
+	}
+	report.Snapshots = snapshots
+	for _, s := range snapshots {
+		if s.Err == nil {
+			report.SnapshotID = max(report.SnapshotID, s.ID)
+		}
+	}
+
+	files := map[string]struct{}{}
+	prev := ID(0)
+	tail, err := w.Inspect(ctx, func(info SegmentInfo) error {
+		files[info.File] = struct{}{}
+		report.Segments++
+		if report.FirstID == 0 {
+			report.FirstID = info.ID
+		}
+		report.LastID = info.ID
+
+		if prev != 0 && info.ID != prev+1 && info.ID > report.SnapshotID {
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("%s at offset %d: segment %d after %d", info.File, info.Offset, info.ID, prev))
+		}
+		prev = info.ID
+		return nil
+	})
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	}
+	report.Tail = tail
+	report.Files = len(files)
+
+	// без целого снимка журнал должен начинаться с первого сегмента
+	if report.Segments > 0 && report.FirstID > report.SnapshotID+1 {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("segment %d is missing after snapshot %d", report.SnapshotID+1, report.SnapshotID))
+	}
+	// файлы журнала до поврежденного снимка удалены, его должны покрывать сегменты
+	if n := len(snapshots); n > 0 && snapshots[n-1].Err != nil && report.LastID < snapshots[n-1].ID {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("snapshot %d is corrupted and wal ends at segment %d", snapshots[n-1].ID, report.LastID))
+	}
+	return report, nil
+}
+
+// TruncateAfter удаляет из журнала сегменты после id вместе со снимками новее id.
+// Вызывается до Load, пока журнал не пишется.
+func (w *WAL) TruncateAfter(ctx context.Context, id ID) error {
+	files, err := w.store.Files()
+	if err != nil {
+		return fmt.Errorf("load files: %w", err)
+	}
+
+	found := false
+	file, end := "", int64(0)
+	for i, name := range files {
+		_, _, _, err := w.scanFile(ctx, files, i, func(segment Segment, offset, size int64) error {
+			if segment.ID == id {
+				found = true
+				file, end = name, offset+size
+				return errStopScan
+			}
+			return nil
+		})
+		if errors.Is(err, errStopScan) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %d", ErrSegmentNotFound, id)
+	}
+
+	err = w.store.Truncate(file, end)
+	if err != nil {
+		return err
+	}
+	err = w.store.RemoveAfter(fstore.FileNum(file))
+	if err != nil {
+		return err
+	}
+
+	names, err := snapshotFiles(w.cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if snapshotID(name) <= id {
+			continue
+		}
+		err = os.Remove(path.Join(w.cfg.DataDir, name))
+		if err != nil {
+			return fmt.Errorf("remove snapshot: %w", err)
+		}
+	}
+	return nil
+}
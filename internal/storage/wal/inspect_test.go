@@ -0,0 +1,196 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/fstore"
+)
+
+// writeSegments пишет n сегментов по одной команде и возвращает файлы журнала
+func writeSegments(t *testing.T, cfg config.WAL, n int) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	for i := range n {
+		require.NoError(t, w.Save(ctx, command.Command{
+			Type: command.CommandSET,
+			Name: fmt.Sprintf("name%d", i),
+			Set:  command.SetArgs{Value: "value"},
+		}))
+	}
+	w.Close()
+
+	entries, err := os.ReadDir(cfg.DataDir)
+	require.NoError(t, err)
+	files := []string{}
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	return files
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "1KB",
+		DataDir:        t.TempDir(),
+	}
+	writeSegments(t, cfg, 3)
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	infos := []SegmentInfo{}
+	tail, err := w.Inspect(context.Background(), func(info SegmentInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Nil(t, tail)
+	require.Len(t, infos, 3)
+
+	offset := int64(fstore.HeaderSize)
+	for i, info := range infos {
+		assert.Equal(t, ID(i+1), info.ID)
+		assert.Equal(t, offset, info.Offset)
+		assert.Len(t, info.Commands, 1)
+		offset += info.Size
+	}
+	stat, err := os.Stat(path.Join(cfg.DataDir, infos[0].File))
+	require.NoError(t, err)
+	assert.Equal(t, stat.Size(), offset)
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		damage func(t *testing.T, dir string, files []string)
+
+		ok       bool
+		tail     bool
+		problems int
+		first    ID
+	}
+
+	tests := map[string]test{
+		"ok": {
+			damage: func(*testing.T, string, []string) {},
+			ok:     true,
+			first:  1,
+		},
+		"torn tail": {
+			damage: func(t *testing.T, dir string, files []string) {
+				last := lastWithData(t, dir, files)
+				stat, err := os.Stat(last)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(last, stat.Size()-3))
+			},
+			tail:  true,
+			first: 1,
+		},
+		"gap": {
+			damage: func(t *testing.T, dir string, files []string) {
+				require.NoError(t, os.Remove(path.Join(dir, files[1])))
+			},
+			problems: 1,
+			first:    1,
+		},
+		"first file removed": {
+			damage: func(t *testing.T, dir string, files []string) {
+				require.NoError(t, os.Remove(path.Join(dir, files[0])))
+			},
+			problems: 1,
+			first:    2,
+		},
+		"corrupted only snapshot": {
+			damage: func(t *testing.T, dir string, files []string) {
+				broken := path.Join(dir, "snapshot_00000000000000000010.bin")
+				require.NoError(t, os.WriteFile(broken, []byte("INMEMSNP"), 0o644))
+			},
+			problems: 1,
+			first:    1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.WAL{
+				BatchSize:      1,
+				BatchTimeout:   time.Millisecond,
+				MaxSegmentSize: "30B",
+				DataDir:        t.TempDir(),
+			}
+			files := writeSegments(t, cfg, 4)
+			require.Greater(t, len(files), 2)
+			tc.damage(t, cfg.DataDir, files)
+
+			w, err := New(cfg)
+			require.NoError(t, err)
+			defer w.Close()
+
+			report, err := w.Verify(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tc.ok, report.OK())
+			assert.Equal(t, tc.tail, report.Tail != nil)
+			assert.Len(t, report.Problems, tc.problems)
+			assert.Equal(t, tc.first, report.FirstID)
+		})
+	}
+}
+
+func TestTruncateAfter(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "100B",
+		DataDir:        t.TempDir(),
+	}
+	writeSegments(t, cfg, 5)
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	require.ErrorIs(t, w.TruncateAfter(ctx, 10), ErrSegmentNotFound)
+	require.NoError(t, w.TruncateAfter(ctx, 2))
+	w.Close()
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	require.Len(t, cmds, 2)
+	assert.Equal(t, "name1", cmds[1].Name)
+
+	// следующий сегмент продолжает журнал без разрыва
+	require.NoError(t, w.Save(ctx, command.Command{Type: command.CommandDEL, Name: "name0"}))
+	report, err := w.Verify(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, ID(3), report.LastID)
+}
@@ -0,0 +1,86 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"inmem-db/internal/storage/wal/fstore"
+)
+
+// segmentDecoder выбирает формат сегментов файла: в файлах без заголовка
+// сегменты записаны без длины и CRC, как до появления версий
+func segmentDecoder(f *fstore.File, keys *Keyring) func(r *segmentReader) (Segment, error) {
+	if f.Header.Version == fstore.VersionLegacy {
+		return decodeLegacySegment
+	}
+	return func(r *segmentReader) (Segment, error) {
+		return decodeSegment(r, keys)
+	}
+}
+
+// decodeLegacySegment читает сегмент без длины и CRC, поэтому повреждение
+// обнаруживается только по ошибке разбора
+func decodeLegacySegment(r *segmentReader) (Segment, error) {
+	if r.atEOF() {
+		return Segment{}, io.EOF
+	}
+	segment, err := decodePayload(r, 0)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return Segment{}, ErrTruncated
+		}
+		return Segment{}, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	return segment, nil
+}
+
+// Migrate переписывает файлы журнала старых версий в текущую и возвращает их количество.
+// Каждый файл заменяется атомарно, прерванную миграцию можно запустить снова.
+// Вызывается до Load, пока журнал не пишется.
+func (w *WAL) Migrate(ctx context.Context) (int, error) {
+	files, err := w.store.Files()
+	if err != nil {
+		return 0, fmt.Errorf("load files: %w", err)
+	}
+
+	migrated := 0
+	for i, name := range files {
+		f, err := w.store.Open(name)
+		if err != nil {
+			return migrated, err
+		}
+		from := f.Header.Version
+		f.Close()
+		if from == fstore.Version {
+			continue
+		}
+
+		segments := 0
+		// оборванный хвост последнего файла не попадает в новый файл
+		err = w.store.Rewrite(name, func(out io.Writer) error {
+			_, st, _, err := w.scanFile(ctx, files, i, func(segment Segment, _, _ int64) error {
+				segment, err := w.encode(segment)
+				if err != nil {
+					return err
+				}
+				return EncodeSegment(out, segment)
+			})
+			segments = st.segments
+			return err
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("rewrite %s: %w", name, err)
+		}
+		migrated++
+
+		slog.InfoContext(ctx, "wal file migrated",
+			slog.String("file", name),
+			slog.Int("from_version", int(from)),
+			slog.Int("to_version", int(fstore.Version)),
+			slog.Int("segments", segments))
+	}
+	return migrated, nil
+}
@@ -0,0 +1,129 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/encode"
+	"inmem-db/internal/storage/wal/fstore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "value"}}
+	}
+	// старый формат: файлы без заголовка, сегменты без длины и CRC
+	require.NoError(t, os.WriteFile(path.Join(cfg.DataDir, "wal_0001.bin"), legacyPayload(1, set("a")), 0o644))
+	require.NoError(t, os.WriteFile(path.Join(cfg.DataDir, "wal_0002.bin"),
+		append(legacyPayload(2, set("b")), legacyPayload(3, set("c"))...), 0o644))
+	want := []command.Command{set("a"), set("b"), set("c")}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, cmds)
+	w.Close()
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	migrated, err := w.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+	migrated, err = w.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+	w.Close()
+
+	for _, name := range []string{"wal_0001.bin", "wal_0002.bin"} {
+		data, err := os.ReadFile(path.Join(cfg.DataDir, name))
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(data, []byte("INMEMWAL")), name)
+	}
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	cmds, err = w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, cmds)
+	require.NoError(t, w.Save(ctx, set("d")))
+	w.Close()
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	cmds, err = w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, append(want, set("d")), cmds)
+}
+
+func TestLoad_legacyAppend(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+
+	old := bytes.NewBuffer(legacyPayload(1, command.Command{Type: command.CommandDEL, Name: "a"}))
+	require.NoError(t, os.WriteFile(path.Join(cfg.DataDir, "wal_0001.bin"), old.Bytes(), 0o644))
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	_, err = w.Load(ctx)
+	require.NoError(t, err)
+	require.NoError(t, w.Save(ctx, command.Command{Type: command.CommandDEL, Name: "b"}))
+	w.Close()
+
+	// в файл старого формата новые сегменты не дописываются
+	data, err := os.ReadFile(path.Join(cfg.DataDir, "wal_0001.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, old.Bytes(), data)
+
+	data, err = os.ReadFile(path.Join(cfg.DataDir, "wal_0002.bin"))
+	require.NoError(t, err)
+	assert.Greater(t, len(data), fstore.HeaderSize)
+}
+
+// legacyPayload кодирует сегмент из SET и DEL так, как до флагов: длины строк в uint16
+func legacyPayload(id ID, cmds ...command.Command) []byte {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(id))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(cmds)))
+	appendString := func(s string) {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+		buf = append(buf, s...)
+	}
+	for _, cmd := range cmds {
+		buf = append(buf, encode.CmdType2Byte[string(cmd.Type)])
+		appendString(cmd.Name)
+		if cmd.Type == command.CommandSET {
+			appendString(cmd.Set.Value)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,228 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"inmem-db/internal/storage/wal/fstore"
+)
+
+var (
+	ErrTargetUnreachable = errors.New("recovery target is unreachable")
+	ErrDirNotEmpty       = errors.New("directory is not empty")
+)
+
+// restoredMarker - файл, который RestoreTo пишет последним. С ним каталог восстановлен целиком,
+// и повторный запуск с той же целью не восстанавливает его заново.
+const restoredMarker = "restored"
+
+// Target - момент, на который восстанавливается журнал: последний сегмент и время записи.
+// Нулевое поле не ограничивает.
+type Target struct {
+	ID   ID
+	Time time.Time
+}
+
+// ParseTarget разбирает цель восстановления: ID сегмента и время в формате RFC 3339
+func ParseTarget(id int64, at string) (Target, error) {
+	if id < 0 {
+		return Target{}, fmt.Errorf("invalid recovery segment id: %d", id)
+	}
+	target := Target{ID: ID(id)}
+	if at != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, at)
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid recovery time: %w", err)
+		}
+		target.Time = parsed
+	}
+	if target.ID == 0 && target.Time.IsZero() {
+		return Target{}, fmt.Errorf("recovery segment id or time is required")
+	}
+	return target, nil
+}
+
+// includes сообщает, что сегмент записан не позже цели. У сегментов старого формата
+// нет времени, они старше сегментов со временем и входят в любую цель по времени.
+func (t Target) includes(segment Segment) bool {
+	if t.ID != 0 && segment.ID > t.ID {
+		return false
+	}
+	return t.Time.IsZero() || !segment.Time.After(t.Time)
+}
+
+// RestoreTo копирует в пустой каталог dir самый новый целый снимок и сегменты после него
+// до target включительно. Сервер, запущенный на dir, восстановит состояние на момент target.
+// Исходный журнал не меняется, оборванный хвост не копируется.
+// Возвращает последний сегмент, вошедший в восстановленное состояние.
+func (w *WAL) RestoreTo(ctx context.Context, dir string, target Target) (last ID, err error) {
+	// журнал уже заменен снимком от мастера, копировать из него нечего
+	pending, err := w.PendingInstall()
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) > 0 {
+		return 0, fmt.Errorf("%w: %s, start the server on %s first", ErrInstallPending, pending[0], w.cfg.DataDir)
+	}
+
+	err = makeEmptyDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	// каталог был пуст, поэтому при ошибке его можно удалить целиком
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	snapshot, snapshotID, err := w.latestSnapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if target.ID != 0 && target.ID < snapshotID {
+		return 0, fmt.Errorf("%w: segment %d is compacted into snapshot %d", ErrTargetUnreachable, target.ID, snapshotID)
+	}
+
+	cfg := w.cfg
+	cfg.DataDir = dir
+	dst, err := New(cfg)
+	if err != nil {
+		return 0, fmt.Errorf("new wal: %w", err)
+	}
+	defer dst.Close()
+
+	if snapshot != "" {
+		err = copyFile(snapshot, path.Join(dir, path.Base(snapshot)))
+		if err != nil {
+			return 0, fmt.Errorf("copy snapshot: %w", err)
+		}
+	}
+
+	last, copied, err := w.copySegments(ctx, dst, snapshotID, target)
+	if err != nil {
+		return 0, err
+	}
+	if target.ID > last {
+		return 0, fmt.Errorf("%w: wal ends at segment %d", ErrTargetUnreachable, last)
+	}
+	// после снимка нет сегментов, время снимка ограничено временем его файла
+	if snapshot != "" && copied == 0 && !target.Time.IsZero() {
+		stat, err := os.Stat(snapshot)
+		if err != nil {
+			return 0, fmt.Errorf("stat snapshot: %w", err)
+		}
+		if stat.ModTime().After(target.Time) {
+			return 0, fmt.Errorf("%w: snapshot %d was written at %s", ErrTargetUnreachable,
+				snapshotID, stat.ModTime().Format(time.RFC3339))
+		}
+	}
+
+	err = fstore.WriteFileAtomic(path.Join(dir, restoredMarker), func(f io.Writer) error {
+		_, err := fmt.Fprintf(f, "segment %d\n", last)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("write restored marker: %w", err)
+	}
+
+	slog.InfoContext(ctx, "wal restored",
+		slog.String("dir", dir),
+		slog.Int64("snapshot_id", int64(snapshotID)),
+		slog.Int("segments", copied),
+		slog.Int64("last_segment_id", int64(last)))
+	return last, nil
+}
+
+// Restored сообщает, что RestoreTo уже закончил восстановление в dir
+func Restored(dir string) (bool, error) {
+	_, err := os.Stat(path.Join(dir, restoredMarker))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat restored marker: %w", err)
+	}
+	return true, nil
+}
+
+// copySegments пишет в dst сегменты после after, пока они входят в target
+func (w *WAL) copySegments(ctx context.Context, dst *WAL, after ID, target Target) (ID, int, error) {
+	files, err := w.store.Files()
+	if err != nil {
+		return 0, 0, fmt.Errorf("load files: %w", err)
+	}
+
+	last, copied := after, 0
+	for i := range files {
+		_, _, _, err := w.scanFile(ctx, files, i, func(segment Segment, _, _ int64) error {
+			if segment.ID <= last {
+				return nil
+			}
+			if segment.ID != last+1 {
+				return fmt.Errorf("%w: segment %d after %d in %s", ErrGap, segment.ID, last, files[i])
+			}
+			if !target.includes(segment) {
+				// сегмент сразу после снимка уже позже цели, значит и снимок позже нее
+				if copied == 0 && after > 0 {
+					return fmt.Errorf("%w: segment %d after snapshot %d was written at %s", ErrTargetUnreachable,
+						segment.ID, after, segment.Time.Format(time.RFC3339))
+				}
+				return errStopScan
+			}
+
+			err := EncodeSegment(dst.store, segment)
+			if err != nil {
+				return err
+			}
+			last = segment.ID
+			copied++
+			return nil
+		})
+		if errors.Is(err, errStopScan) {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return last, copied, nil
+}
+
+// makeEmptyDir создает каталог или проверяет, что существующий каталог пуст
+func makeEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return fmt.Errorf("create dir: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%w: %s", ErrDirNotEmpty, dir)
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return fstore.WriteFileAtomic(to, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
@@ -0,0 +1,191 @@
+package wal
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	t.Parallel()
+
+	target, err := ParseTarget(5, "2026-10-18T10:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, ID(5), target.ID)
+	assert.True(t, target.Time.Equal(time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)))
+
+	_, err = ParseTarget(0, "")
+	assert.Error(t, err)
+	_, err = ParseTarget(0, "yesterday")
+	assert.Error(t, err)
+}
+
+func TestRestoreTo(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "60B",
+		DataDir:        t.TempDir(),
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "v"}}
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	var mid time.Time
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, w.Save(ctx, set(name)))
+		if i == 2 {
+			mid = time.Now()
+		}
+	}
+	w.Close()
+
+	type test struct {
+		target Target
+
+		want []string
+		err  error
+	}
+
+	tests := map[string]test{
+		"by id":       {target: Target{ID: 2}, want: []string{"a", "b"}},
+		"by time":     {target: Target{Time: mid}, want: []string{"a", "b", "c"}},
+		"first limit": {target: Target{ID: 2, Time: mid}, want: []string{"a", "b"}},
+		"all":         {target: Target{ID: 5}, want: []string{"a", "b", "c", "d", "e"}},
+		"beyond end":  {target: Target{ID: 10}, err: ErrTargetUnreachable},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			src, err := New(cfg)
+			require.NoError(t, err)
+			defer src.Close()
+
+			dst := path.Join(t.TempDir(), "restored")
+			_, err = src.RestoreTo(ctx, dst, tc.target)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				assert.NoDirExists(t, dst)
+				return
+			}
+			require.NoError(t, err)
+			done, err := Restored(dst)
+			require.NoError(t, err)
+			assert.True(t, done)
+
+			restoredCfg := cfg
+			restoredCfg.DataDir = dst
+			restored, err := New(restoredCfg)
+			require.NoError(t, err)
+			defer restored.Close()
+			cmds, err := restored.Load(ctx)
+			require.NoError(t, err)
+
+			names := []string{}
+			for _, cmd := range cmds {
+				names = append(names, cmd.Name)
+			}
+			assert.Equal(t, tc.want, names)
+		})
+	}
+
+	// исходный журнал не изменился
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Len(t, cmds, 5)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "file"), nil, 0o644))
+	_, err = w.RestoreTo(ctx, dir, Target{ID: 1})
+	require.ErrorIs(t, err, ErrDirNotEmpty)
+	assert.FileExists(t, path.Join(dir, "file"))
+	done, err := Restored(dir)
+	require.NoError(t, err)
+	assert.False(t, done)
+}
+
+func TestRestoreTo_snapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "1KB",
+		DataDir:        t.TempDir(),
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "v"}}
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	_, err = w.Load(ctx)
+	require.NoError(t, err)
+	before := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, w.Save(ctx, set(name)))
+	}
+	cp, err := w.Checkpoint()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSnapshot(ctx, cp, []command.Command{set("a"), set("b"), set("c")}))
+	require.NoError(t, w.Save(ctx, set("d")))
+	require.NoError(t, w.Save(ctx, set("e")))
+	w.Close()
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// история до снимка удалена
+	_, err = w.RestoreTo(ctx, path.Join(t.TempDir(), "r"), Target{ID: 2})
+	require.ErrorIs(t, err, ErrTargetUnreachable)
+	_, err = w.RestoreTo(ctx, path.Join(t.TempDir(), "r"), Target{Time: before})
+	require.ErrorIs(t, err, ErrTargetUnreachable)
+
+	dst := path.Join(t.TempDir(), "r")
+	last, err := w.RestoreTo(ctx, dst, Target{ID: 4})
+	require.NoError(t, err)
+	assert.Equal(t, ID(4), last)
+
+	restoredCfg := cfg
+	restoredCfg.DataDir = dst
+	restored, err := New(restoredCfg)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	state := []command.Command{}
+	id, err := restored.LoadSnapshot(ctx, func(cmd command.Command) error {
+		state = append(state, cmd)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, cp.ID, id)
+	assert.Len(t, state, 3)
+	cmds, err := restored.Load(ctx)
+	require.NoError(t, err)
+	require.Len(t, cmds, 1)
+	assert.Equal(t, "d", cmds[0].Name)
+}
@@ -0,0 +1,268 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/fstore"
+)
+
+// errStopScan прерывает чтение файлов, когда нужные сегменты уже прочитаны
+var errStopScan = errors.New("stop scan")
+
+// Recover передает в fn команды сегментов после снимка. Журнал читается по одному файлу
+// и сегменту, поэтому память не зависит от его размера. Оборванный или поврежденный
+// последний сегмент последнего файла - след сбоя во время записи, он отрезается.
+// Повреждение в другом месте журнала - ошибка с именем файла и смещением.
+// Без снимка журнал должен начинаться с первого сегмента, а непрочитанный снимок
+// должен быть покрыт сегментами: файлы журнала до него уже удалены.
+func (w *WAL) Recover(ctx context.Context, fn func(cmd command.Command) error) error {
+	files, err := w.store.Files()
+	if err != nil {
+		return fmt.Errorf("load files: %w", err)
+	}
+	newest, err := newestSnapshotID(w.cfg.DataDir)
+	if err != nil {
+		return err
+	}
+
+	w.mu.RLock()
+	after := w.snapshotID
+	w.mu.RUnlock()
+
+	start := time.Now()
+	segments, bytes := 0, int64(0)
+	for i, name := range files {
+		h, st, tail, err := w.scanFile(ctx, files, i, func(segment Segment, _, _ int64) error {
+			// файлы, покрытые снимком, могли не успеть удалиться
+			if segment.ID <= after {
+				return nil
+			}
+			if segment.ID != after+1 {
+				return fmt.Errorf("%w: segment %d after %d in %s", ErrGap, segment.ID, after, name)
+			}
+			after = segment.ID
+
+			for _, cmd := range segment.commands {
+				err := fn(cmd)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if tail != nil {
+			err = w.store.Truncate(name, tail.Offset)
+			if err != nil {
+				return err
+			}
+		}
+
+		segments += st.segments
+		bytes += st.bytes
+		slog.InfoContext(ctx, "wal recovery progress",
+			slog.String("file", name),
+			slog.Int("version", int(h.Version)),
+			slog.String("files", fmt.Sprintf("%d/%d", i+1, len(files))),
+			slog.Int("segments", segments),
+			slog.Int64("bytes", bytes),
+			slog.Duration("elapsed", time.Since(start)))
+	}
+	if after < newest {
+		return fmt.Errorf("%w: snapshot %d is not loaded and wal ends at segment %d", ErrGap, newest, after)
+	}
+
+	w.mu.Lock()
+	w.maxID = max(w.maxID, after)
+	w.recoveredID = after
+	w.mu.Unlock()
+
+	slog.InfoContext(ctx, "wal recovered",
+		slog.Int("files", len(files)),
+		slog.Int("segments", segments),
+		slog.Int64("bytes", bytes),
+		slog.Int64("last_segment_id", int64(after)),
+		slog.Duration("elapsed", time.Since(start)))
+	return nil
+}
+
+// Load возвращает все команды после снимка, Recover делает то же без сбора в память
+func (w *WAL) Load(ctx context.Context) ([]command.Command, error) {
+	commands := make([]command.Command, 0, 100)
+	err := w.Recover(ctx, func(cmd command.Command) error {
+		commands = append(commands, cmd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// fileStats - сколько прочитано из файла журнала
+type fileStats struct {
+	segments int
+	bytes    int64
+}
+
+// TornTail - оборванный последний сегмент последнего файла, след сбоя во время записи
+type TornTail struct {
+	File string
+	// Offset - смещение от начала файла, до него файл обрезается
+	Offset int64
+	Err    error
+}
+
+// scanFile передает в fn сегменты files[i] по одному вместе со смещением от начала
+// файла и размером. Оборванный хвост последнего файла не считается ошибкой,
+// scanFile возвращает его в tail. Последний файл с оборванным заголовком сегментов
+// не содержит, он обрезается целиком, и запись заново начнет его с заголовка.
+func (w *WAL) scanFile(ctx context.Context, files []string, i int, fn func(segment Segment, offset, size int64) error) (fstore.Header, fileStats, *TornTail, error) {
+	name := files[i]
+	f, err := w.store.Open(name)
+	if errors.Is(err, fstore.ErrTornHeader) && w.isLastData(files[i+1:]) {
+		slog.WarnContext(ctx, "torn wal file header",
+			slog.String("file", name),
+			slog.String("error", err.Error()))
+		return fstore.Header{Version: fstore.Version}, fileStats{}, &TornTail{File: name, Err: err}, nil
+	}
+	if err != nil {
+		return fstore.Header{}, fileStats{}, nil, fmt.Errorf("load %s: %w", name, err)
+	}
+	defer f.Close()
+
+	base := int64(0)
+	if f.Header.Version != fstore.VersionLegacy {
+		base = int64(fstore.HeaderSize)
+	}
+
+	r := &segmentReader{r: f.Reader}
+	decode := segmentDecoder(f, w.keys)
+	st := fileStats{}
+	for {
+		offset := r.n
+		segment, err := decode(r)
+		if errors.Is(err, io.EOF) {
+			st.bytes = r.n
+			return f.Header, st, nil, nil
+		}
+		if err != nil {
+			if !isTornTail(err, r) || !w.isLastData(files[i+1:]) || w.frameAfter(name, offset) {
+				return f.Header, st, nil, fmt.Errorf("decode %s at offset %d: %w", name, base+offset, err)
+			}
+			slog.WarnContext(ctx, "torn wal tail",
+				slog.String("file", name),
+				slog.Int64("offset", base+offset),
+				slog.String("error", err.Error()))
+			st.bytes = offset
+			return f.Header, st, &TornTail{File: name, Offset: base + offset, Err: err}, nil
+		}
+
+		st.segments++
+		err = fn(segment, base+offset, r.n-offset)
+		if err != nil {
+			return f.Header, st, nil, err
+		}
+	}
+}
+
+// readSegments читает с диска сегменты с ID в (from, to]
+func (w *WAL) readSegments(ctx context.Context, from, to ID) ([]Segment, error) {
+	files, err := w.store.Files()
+	if err != nil {
+		return nil, fmt.Errorf("load files: %w", err)
+	}
+
+	segments := []Segment{}
+	for i := range files {
+		_, _, _, err := w.scanFile(ctx, files, i, func(segment Segment, _, _ int64) error {
+			if segment.ID > to {
+				return errStopScan
+			}
+			if segment.ID > from {
+				segments = append(segments, segment)
+			}
+			return nil
+		})
+		if errors.Is(err, errStopScan) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// isLastData сообщает, что в следующих файлах нет сегментов: после переполнения
+// файла журнал сразу открывает новый, поэтому последний файл может быть пустым
+func (w *WAL) isLastData(next []string) bool {
+	for _, name := range next {
+		f, err := w.store.Open(name)
+		if err != nil {
+			return false
+		}
+		_, err = f.Peek(1)
+		f.Close()
+		if !errors.Is(err, io.EOF) {
+			return false
+		}
+	}
+	return true
+}
+
+// frameAfter сообщает, что после начала поврежденного сегмента в файле есть целый сегмент.
+// Так бывает, если испорчена длина сегмента в середине файла: по ней сегмент
+// доходит до конца файла, хотя это не оборванная запись.
+func (w *WAL) frameAfter(name string, offset int64) bool {
+	f, err := w.store.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	_, err = f.Discard(int(offset) + 1)
+	if err != nil {
+		return false
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		return false
+	}
+	return hasFrame(rest)
+}
+
+// isTornTail сообщает, что ошибка в сегменте, который заканчивается в конце файла:
+// так выглядит запись, прерванная сбоем. Если после сегмента есть данные, это повреждение.
+func isTornTail(err error, r *segmentReader) bool {
+	if errors.Is(err, ErrTruncated) {
+		return true
+	}
+	return errors.Is(err, ErrCorrupted) && r.atEOF()
+}
+
+// segmentReader считает прочитанные байты, чтобы знать смещение сегмента в файле
+type segmentReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *segmentReader) atEOF() bool {
+	_, err := r.r.Peek(1)
+	return errors.Is(err, io.EOF)
+}
@@ -1,16 +1,27 @@
 package wal
 
 import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"slices"
+	"time"
 
+	"inmem-db/internal/config"
 	"inmem-db/internal/domain/command"
 )
 
 type ID int64
 
 type Segment struct {
-	ID       ID
+	ID ID
+	// Time - время записи сегмента на мастере, у сегментов старого формата пусто
+	Time     time.Time
 	commands []command.Command
+	// raw - сегмент в том виде, в котором он записан в журнал
+	raw []byte
 }
 
 func newSegment(id ID, commands []command.Command) Segment {
@@ -24,7 +35,9 @@ func newSegment(id ID, commands []command.Command) Segment {
 }
 
 func (w *WAL) makeSegment(commands []command.Command) Segment {
-	return newSegment(w.genSegmentID(), commands)
+	segment := newSegment(w.genSegmentID(), commands)
+	segment.Time = time.Now()
+	return segment
 }
 
 func (w *WAL) addSegment(s Segment) {
@@ -48,18 +61,54 @@ func (w *WAL) genSegmentID() ID {
 	return w.maxID
 }
 
+// SegmentsAfter возвращает сегменты после id по возрастанию ID и без разрывов.
+// Восстановленные при запуске сегменты не хранятся в памяти и читаются с диска.
 func (w *WAL) SegmentsAfter(id int64) []Segment {
 	slog.Debug("SegmentsAfter", slog.Int64("id", id))
 
+	w.mu.RLock()
+	from, recovered := max(ID(id), w.snapshotID), w.recoveredID
+	w.mu.RUnlock()
+
 	segments := []Segment{}
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	if from < recovered {
+		var err error
+		segments, err = w.readSegments(context.Background(), from, recovered)
+		if err != nil {
+			slog.Error("read segments from disk", slog.Int64("id", id), slog.String("error", err.Error()))
+			return nil
+		}
+	}
+
+	w.mu.RLock()
 	for sID, segment := range w.segments {
-		if sID > ID(id) {
+		if sID > from {
 			segments = append(segments, segment)
 		}
 	}
-	return segments
+	w.mu.RUnlock()
+
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return contiguous(segments, from)
+}
+
+// contiguous оставляет сегменты, идущие подряд сразу после from: реплика
+// не должна применить сегмент раньше предыдущего. Повторы отбрасываются.
+func contiguous(segments []Segment, from ID) []Segment {
+	res := make([]Segment, 0, len(segments))
+	for _, segment := range segments {
+		if segment.ID <= from {
+			continue
+		}
+		if segment.ID != from+1 {
+			break
+		}
+		res = append(res, segment)
+		from = segment.ID
+	}
+	return res
 }
 
 func SegmentCommands(segment Segment) []command.Command {
@@ -67,9 +116,59 @@ func SegmentCommands(segment Segment) []command.Command {
 	return commands
 }
 
+// SaveSegment записывает сегмент, в режиме always - вместе со сбросом на диск.
+// Сегмент от мастера пишется без перекодирования, со сжатием мастера.
 func (w *WAL) SaveSegment(segment Segment) error {
+	segment, err := w.encode(segment)
+	if err != nil {
+		return err
+	}
 	w.addSegment(segment)
-	return EncodeSegment(w.store, segment)
+	err = EncodeSegment(w.store, segment)
+	if err != nil {
+		return err
+	}
+	if w.cfg.SyncMode == config.SyncModeAlways {
+		_, err = w.store.Sync()
+		if err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	w.notifyChanged()
+	return nil
+}
+
+// Changed возвращает канал, который закроется после записи следующего сегмента.
+// Канал нужно получить до SegmentsAfter, иначе записанное между вызовами можно пропустить.
+func (w *WAL) Changed() <-chan struct{} {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.changed
+}
+
+func (w *WAL) notifyChanged() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// DecodeSegment читает сегмент, зашифрованный ключами журнала
+func (w *WAL) DecodeSegment(r io.Reader) (Segment, error) {
+	return decodeSegment(r, w.keys)
+}
+
+// encode кодирует новый сегмент со сжатием и шифрованием из конфигурации
+func (w *WAL) encode(segment Segment) (Segment, error) {
+	if segment.raw != nil {
+		return segment, nil
+	}
+	raw, err := encodeFrame(segment, w.codec, w.keys)
+	if err != nil {
+		return Segment{}, err
+	}
+	segment.raw = raw
+	return segment, nil
 }
 
 func (w *WAL) LastSegmentID() int64 {
@@ -1,6 +1,7 @@
 package wal
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -16,7 +17,7 @@ func TestAfterID(t *testing.T) {
 	const totalSegments = 100
 	segments := make([]Segment, totalSegments)
 	for i := range segments {
-		segments[i] = newSegment(ID(i+1), []command.Command{})
+		segments[i] = encoded(t, newSegment(ID(i+1), []command.Command{}))
 	}
 
 	cfg := config.WAL{
@@ -36,8 +37,40 @@ func TestAfterID(t *testing.T) {
 	for i := range segments {
 		segmentsAfter := w.SegmentsAfter(int64(i))
 		assert.Len(t, segmentsAfter, totalSegments-i)
-		assert.Subset(t, segments, segmentsAfter)
+		assert.Equal(t, segments[i:], segmentsAfter)
+	}
+}
+
+func TestAfterID_gap(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.WAL{
+		BatchSize:      100,
+		BatchTimeout:   time.Millisecond * 100,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+	w, err := New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	for _, id := range []ID{4, 1, 2} {
+		require.NoError(t, w.SaveSegment(encoded(t, newSegment(id, []command.Command{}))))
 	}
+
+	// сегмент 3 еще не записан, сегмент 4 нельзя отдавать раньше него
+	ids := func(segments []Segment) []ID {
+		res := []ID{}
+		for _, s := range segments {
+			res = append(res, s.ID)
+		}
+		return res
+	}
+	assert.Equal(t, []ID{1, 2}, ids(w.SegmentsAfter(0)))
+	assert.Empty(t, w.SegmentsAfter(2))
+
+	require.NoError(t, w.SaveSegment(encoded(t, newSegment(3, []command.Command{}))))
+	assert.Equal(t, []ID{3, 4}, ids(w.SegmentsAfter(2)))
 }
 
 func TestLastSegmentID(t *testing.T) {
@@ -64,3 +97,55 @@ func TestLastSegmentID(t *testing.T) {
 	id := w.LastSegmentID()
 	assert.EqualValues(t, totalSegments, id)
 }
+
+func TestAfterID_recovered(t *testing.T) {
+	t.Parallel()
+	const totalSegments = 50
+	segments := make([]Segment, totalSegments+1)
+	for i := range segments {
+		segments[i] = encoded(t, newSegment(ID(i+1), []command.Command{{Type: command.CommandDEL, Name: "key"}}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      100,
+		BatchTimeout:   time.Millisecond * 100,
+		MaxSegmentSize: "100B",
+		DataDir:        t.TempDir(),
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	for _, s := range segments[:totalSegments] {
+		require.NoError(t, w.SaveSegment(s))
+	}
+	w.Close()
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	recovered := 0
+	err = w.Recover(ctx, func(cmd command.Command) error {
+		recovered++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, totalSegments, recovered)
+
+	// восстановленные сегменты читаются с диска, новые - из памяти
+	require.NoError(t, w.SaveSegment(segments[totalSegments]))
+	for _, i := range []int{0, 10, totalSegments - 1, totalSegments} {
+		segmentsAfter := w.SegmentsAfter(int64(i))
+		assert.Len(t, segmentsAfter, len(segments)-i)
+		assert.Subset(t, segments, segmentsAfter)
+	}
+}
+
+// encoded возвращает сегмент с закодированным видом, как после записи в журнал
+func encoded(t *testing.T, s Segment) Segment {
+	raw, err := encodeFrame(s, codecNone, nil)
+	require.NoError(t, err)
+	s.raw = raw
+	return s
+}
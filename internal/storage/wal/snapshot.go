@@ -0,0 +1,475 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/decode"
+	"inmem-db/internal/storage/wal/encode"
+	"inmem-db/internal/storage/wal/fstore"
+)
+
+var (
+	ErrSnapshotCorrupted = errors.New("snapshot is corrupted")
+	ErrNoSnapshot        = errors.New("no snapshot")
+	ErrInstallPending    = errors.New("snapshot install is not finished")
+)
+
+const (
+	snapshotMagic = "INMEMSNP"
+	// snapshotVersion 2 добавил ID ключа шифрования после версии, 0 - без шифрования,
+	// версия 3 пишет длины строк в uvarint
+	snapshotVersion = 3
+
+	snapshotPattern = "snapshot_[0-9]*.bin"
+	snapshotFormat  = "snapshot_%020d.bin"
+	// installSuffix - снимок от мастера, который еще не заменил журнал. Файл появляется
+	// атомарно, с этого момента журнал и прежние снимки считаются замененными.
+	installSuffix = ".install"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checkpoint - место в журнале, на котором сделан снимок
+type Checkpoint struct {
+	// ID - последний сегмент, вошедший в снимок
+	ID ID
+	// file - первый файл журнала, в котором могут быть сегменты после ID
+	file uint
+}
+
+// Checkpoint начинает новый файл журнала и возвращает последний записанный сегмент.
+// Вызывается, когда все примененные изменения записаны и новые не поступают.
+func (w *WAL) Checkpoint() (Checkpoint, error) {
+	file, err := w.store.Rotate()
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("rotate: %w", err)
+	}
+	return Checkpoint{
+		ID:   ID(w.LastSegmentID()),
+		file: file,
+	}, nil
+}
+
+// SinceCheckpoint возвращает объем журнала после последнего Checkpoint
+func (w *WAL) SinceCheckpoint() uint64 {
+	return w.store.SinceRotate()
+}
+
+// WriteSnapshot атомарно записывает снимок состояния на момент cp
+// и удаляет файлы журнала и старые снимки, которые он заменяет
+func (w *WAL) WriteSnapshot(ctx context.Context, cp Checkpoint, cmds []command.Command) error {
+	name := path.Join(w.cfg.DataDir, fmt.Sprintf(snapshotFormat, cp.ID))
+	err := fstore.WriteFileAtomic(name, func(f io.Writer) error {
+		return encodeSnapshot(f, w.keys, cp.ID, cmds)
+	})
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	slog.InfoContext(ctx, "snapshot saved",
+		slog.String("name", name),
+		slog.Int64("segment_id", int64(cp.ID)),
+		slog.Int("keys", len(cmds)))
+
+	err = w.store.RemoveBefore(cp.file)
+	if err != nil {
+		return fmt.Errorf("remove wal files: %w", err)
+	}
+
+	names, err := snapshotFiles(w.cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	for _, old := range names {
+		if snapshotID(old) >= cp.ID {
+			continue
+		}
+		err = os.Remove(path.Join(w.cfg.DataDir, old))
+		if err != nil {
+			return fmt.Errorf("remove old snapshot: %w", err)
+		}
+	}
+
+	w.forget(cp.ID)
+	return nil
+}
+
+// InstallSnapshot заменяет журнал снимком на сегменте id, полученным от мастера.
+// Команды снимка, size штук, по одной возвращает next, их не нужно держать в памяти.
+// Снимок сначала целиком записывается рядом с журналом, затем удаляются все файлы журнала
+// и прежние снимки. Прерванную установку завершает FinishInstall. Следующий сегмент - id+1.
+func (w *WAL) InstallSnapshot(ctx context.Context, id ID, size uint64, next func() (command.Command, error)) error {
+	file, err := w.store.Rotate()
+	if err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+
+	pending := path.Join(w.cfg.DataDir, fmt.Sprintf(snapshotFormat, id)+installSuffix)
+	err = fstore.WriteFileAtomic(pending, func(f io.Writer) error {
+		return writeSnapshot(f, w.keys, id, size, next)
+	})
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	name, err := w.completeInstall(pending, file)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.segments = make(map[ID]Segment, 10)
+	w.maxID = id
+	w.snapshotID = id
+	w.recoveredID = id
+	w.mu.Unlock()
+
+	slog.InfoContext(ctx, "snapshot installed",
+		slog.String("name", name),
+		slog.Int64("segment_id", int64(id)),
+		slog.Uint64("keys", size))
+	return nil
+}
+
+// FinishInstall завершает установку снимка, прерванную сбоем. Записанный целиком снимок
+// установки заменяет журнал, после него сегменты не пишутся, поэтому удаляются все файлы журнала.
+// Вызывается при запуске сервера до LoadSnapshot. Инструменты только для чтения
+// вызывают PendingInstall и каталог не меняют.
+func (w *WAL) FinishInstall(ctx context.Context) error {
+	pending, err := w.PendingInstall()
+	if err != nil {
+		return err
+	}
+	for _, name := range pending {
+		slog.WarnContext(ctx, "finish interrupted snapshot install", slog.String("name", name))
+		_, err = w.completeInstall(name, math.MaxUint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PendingInstall возвращает пути снимков установки, которые еще не заменили журнал.
+// Пока они есть, файлы журнала и прежние снимки устарели.
+func (w *WAL) PendingInstall() ([]string, error) {
+	entries, err := os.ReadDir(w.cfg.DataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+
+	pending := []string{}
+	for _, e := range entries {
+		ok, err := path.Match(snapshotPattern+installSuffix, e.Name())
+		if e.IsDir() || err != nil || !ok {
+			continue
+		}
+		pending = append(pending, path.Join(w.cfg.DataDir, e.Name()))
+	}
+	return pending, nil
+}
+
+// completeInstall удаляет файлы журнала до file и все снимки, затем переименовывает
+// снимок установки pending в обычный снимок. Повторный вызов после сбоя безопасен.
+func (w *WAL) completeInstall(pending string, file uint) (string, error) {
+	err := w.store.RemoveBefore(file)
+	if err != nil {
+		return "", fmt.Errorf("remove wal files: %w", err)
+	}
+
+	names, err := snapshotFiles(w.cfg.DataDir)
+	if err != nil {
+		return "", err
+	}
+	for _, old := range names {
+		err = os.Remove(path.Join(w.cfg.DataDir, old))
+		if err != nil {
+			return "", fmt.Errorf("remove old snapshot: %w", err)
+		}
+	}
+
+	name := strings.TrimSuffix(pending, installSuffix)
+	err = os.Rename(pending, name)
+	if err != nil {
+		return "", fmt.Errorf("rename snapshot: %w", err)
+	}
+	return name, fstore.SyncDir(w.cfg.DataDir)
+}
+
+// SnapshotID возвращает последний сегмент, вошедший в снимок: сегменты до него
+// есть только в снимке
+func (w *WAL) SnapshotID() int64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return int64(w.snapshotID)
+}
+
+// LoadSnapshot передает в fn команды самого нового целого снимка и возвращает его сегмент.
+// Поврежденные снимки пропускаются. Load после этого читает только сегменты после снимка.
+func (w *WAL) LoadSnapshot(ctx context.Context, fn func(cmd command.Command) error) (ID, error) {
+	name, id, err := w.latestSnapshot(ctx)
+	if err != nil || name == "" {
+		return 0, err
+	}
+
+	err = readSnapshot(name, w.keys, fn)
+	if err != nil {
+		return 0, fmt.Errorf("load snapshot %s: %w", name, err)
+	}
+
+	w.mu.Lock()
+	w.snapshotID = id
+	w.maxID = max(w.maxID, id)
+	w.mu.Unlock()
+
+	slog.InfoContext(ctx, "snapshot loaded", slog.String("name", name), slog.Int64("segment_id", int64(id)))
+	return id, nil
+}
+
+// StreamSnapshot передает в start сегмент и количество команд снимка на сегменте SnapshotID,
+// затем его команды в fn. Снимок проверяется целиком до start. Если снимка нет
+// или он поврежден, возвращает ErrNoSnapshot.
+func (w *WAL) StreamSnapshot(start func(id ID, size uint64) error, fn func(cmd command.Command) error) error {
+	name := path.Join(w.cfg.DataDir, fmt.Sprintf(snapshotFormat, w.SnapshotID()))
+	err := readSnapshot(name, w.keys, func(command.Command) error { return nil })
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrNoSnapshot, name, err)
+	}
+	return scanSnapshot(name, w.keys, start, fn)
+}
+
+// latestSnapshot возвращает путь и сегмент самого нового целого снимка, пустой путь - снимков нет
+func (w *WAL) latestSnapshot(ctx context.Context) (string, ID, error) {
+	names, err := snapshotFiles(w.cfg.DataDir)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for _, name := range slices.Backward(names) {
+		id := snapshotID(name)
+		name = path.Join(w.cfg.DataDir, name)
+
+		err := readSnapshot(name, w.keys, func(command.Command) error { return nil })
+		if err != nil {
+			slog.ErrorContext(ctx, "skip snapshot", slog.String("name", name), slog.String("error", err.Error()))
+			continue
+		}
+		return name, id, nil
+	}
+	return "", 0, nil
+}
+
+// forget удаляет из памяти сегменты, вошедшие в снимок
+func (w *WAL) forget(id ID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.snapshotID = max(w.snapshotID, id)
+	for sID := range w.segments {
+		if sID <= id {
+			delete(w.segments, sID)
+		}
+	}
+}
+
+// encodeSnapshot пишет снимок команд cmds
+func encodeSnapshot(w io.Writer, keys *Keyring, id ID, cmds []command.Command) error {
+	i := 0
+	return writeSnapshot(w, keys, id, uint64(len(cmds)), func() (command.Command, error) {
+		i++
+		return cmds[i-1], nil
+	})
+}
+
+// writeSnapshot пишет снимок: magic, версия, ID ключа, затем ID сегмента, количество
+// команд, size команд из next и CRC32C. Все после ID ключа шифруется, если ключ задан.
+func writeSnapshot(w io.Writer, keys *Keyring, id ID, size uint64, next func() (command.Command, error)) error {
+	crc := crc32.New(castagnoli)
+
+	keyID := keys.activeID()
+	header := append([]byte(snapshotMagic), snapshotVersion)
+	header = binary.BigEndian.AppendUint32(header, keyID)
+	_, err := io.MultiWriter(w, crc).Write(header)
+	if err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	body := w
+	var sealed *sealWriter
+	if keyID != 0 {
+		sealed = keys.newSealWriter(w)
+		body = sealed
+	}
+	mw := io.MultiWriter(body, crc)
+
+	err = encode.WriteID(mw, int64(id))
+	if err != nil {
+		return fmt.Errorf("write segment id: %w", err)
+	}
+	err = binary.Write(mw, binary.BigEndian, size)
+	if err != nil {
+		return fmt.Errorf("write size: %w", err)
+	}
+
+	for range size {
+		cmd, err := next()
+		if err != nil {
+			return err
+		}
+		err = encode.Write(mw, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = binary.Write(body, binary.BigEndian, crc.Sum32())
+	if err != nil {
+		return fmt.Errorf("write checksum: %w", err)
+	}
+	if sealed != nil {
+		return sealed.Close()
+	}
+	return nil
+}
+
+func readSnapshot(name string, keys *Keyring, fn func(cmd command.Command) error) error {
+	return scanSnapshot(name, keys, nil, fn)
+}
+
+// scanSnapshot передает в start сегмент и количество команд снимка, если start задан,
+// затем команды в fn
+func scanSnapshot(name string, keys *Keyring, start func(id ID, size uint64) error, fn func(cmd command.Command) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	raw := bufio.NewReader(f)
+	crc := crc32.New(castagnoli)
+	header := io.TeeReader(raw, crc)
+
+	magic := make([]byte, len(snapshotMagic)+1)
+	_, err = io.ReadFull(header, magic)
+	if err != nil || string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: bad header", ErrSnapshotCorrupted)
+	}
+	version := magic[len(snapshotMagic)]
+	if version < 1 || version > snapshotVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrSnapshotCorrupted, version)
+	}
+
+	keyID := uint32(0)
+	if version >= 2 {
+		err = binary.Read(header, binary.BigEndian, &keyID)
+		if err != nil {
+			return fmt.Errorf("%w: read key id: %w", ErrSnapshotCorrupted, err)
+		}
+	}
+	body := io.Reader(raw)
+	if keyID != 0 {
+		body, err = keys.newOpenReader(raw, keyID)
+		if err != nil {
+			return err
+		}
+	}
+	r := io.TeeReader(body, crc)
+
+	id, err := decode.ReadID(r)
+	if err != nil {
+		return fmt.Errorf("%w: read segment id: %w", ErrSnapshotCorrupted, err)
+	}
+	size := uint64(0)
+	err = binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return fmt.Errorf("%w: read size: %w", ErrSnapshotCorrupted, err)
+	}
+	if start != nil {
+		err = start(ID(id), size)
+		if err != nil {
+			return err
+		}
+	}
+
+	read := decode.Read
+	if version < 3 {
+		read = decode.ReadLegacy
+	}
+	for range size {
+		cmd, err := read(r)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrSnapshotCorrupted, err)
+		}
+		err = fn(cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	sum := crc.Sum32()
+	want := uint32(0)
+	err = binary.Read(body, binary.BigEndian, &want)
+	if err != nil {
+		return fmt.Errorf("%w: read checksum: %w", ErrSnapshotCorrupted, err)
+	}
+	if sum != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupted)
+	}
+	if keyID != 0 {
+		// без последней части зашифрованный снимок оборван
+		n, err := body.Read(make([]byte, 1))
+		if n > 0 || !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: no final chunk after checksum", ErrSnapshotCorrupted)
+		}
+	}
+	return nil
+}
+
+// snapshotFiles возвращает имена снимков по возрастанию сегмента
+func snapshotFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		ok, err := path.Match(snapshotPattern, e.Name())
+		if e.IsDir() || err != nil || !ok {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	// номер дополнен нулями до 20 цифр, поэтому порядок имен совпадает с порядком сегментов
+	slices.Sort(names)
+	return names, nil
+}
+
+// newestSnapshotID возвращает сегмент самого нового снимка, в том числе поврежденного
+func newestSnapshotID(dir string) (ID, error) {
+	names, err := snapshotFiles(dir)
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+	return snapshotID(names[len(names)-1]), nil
+}
+
+func snapshotID(name string) ID {
+	id := int64(0)
+	_, _ = fmt.Sscanf(name, snapshotFormat, &id)
+	return ID(id)
+}
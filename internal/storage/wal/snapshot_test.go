@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"inmem-db/internal/config"
+	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/fstore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10B",
+		DataDir:        t.TempDir(),
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "v"}}
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	_, err = w.Load(ctx)
+	require.NoError(t, err)
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, w.Save(ctx, set(name)))
+	}
+	require.NoError(t, w.Flush(ctx))
+	cp, err := w.Checkpoint()
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, cp.ID)
+
+	state := []command.Command{set("a"), set("b"), set("c")}
+	require.NoError(t, w.WriteSnapshot(ctx, cp, state))
+	assert.Empty(t, w.SegmentsAfter(0))
+
+	require.NoError(t, w.Save(ctx, set("d")))
+	w.Close()
+
+	// снимок новее, но поврежден - должен загрузиться предыдущий, сегменты покрывают поврежденный
+	broken := path.Join(cfg.DataDir, "snapshot_00000000000000000004.bin")
+	require.NoError(t, os.WriteFile(broken, []byte("INMEMSNP"), 0o644))
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	loaded := []command.Command{}
+	id, err := w.LoadSnapshot(ctx, func(cmd command.Command) error {
+		loaded = append(loaded, cmd)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, id)
+	assert.Equal(t, state, loaded)
+
+	after, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []command.Command{set("d")}, after)
+	assert.EqualValues(t, 4, w.LastSegmentID())
+}
+
+func TestInstallSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10B",
+		DataDir:        t.TempDir(),
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "v"}}
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	_, err = w.Load(ctx)
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, w.Save(ctx, set(name)))
+	}
+	require.NoError(t, w.Flush(ctx))
+	cp, err := w.Checkpoint()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSnapshot(ctx, cp, []command.Command{set("a"), set("b"), set("c")}))
+	require.NoError(t, w.Save(ctx, set("d")))
+
+	// состояние мастера старше снимка реплики, прежняя история удаляется целиком
+	state := []command.Command{set("x")}
+	i := 0
+	require.NoError(t, w.InstallSnapshot(ctx, 2, uint64(len(state)), func() (command.Command, error) {
+		i++
+		return state[i-1], nil
+	}))
+	assert.EqualValues(t, 2, w.LastSegmentID())
+	assert.EqualValues(t, 2, w.SnapshotID())
+	assert.Empty(t, w.SegmentsAfter(0))
+
+	require.NoError(t, w.SaveSegment(encoded(t, newSegment(3, []command.Command{set("y")}))))
+	w.Close()
+
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+
+	loaded := []command.Command{}
+	id, err := w.LoadSnapshot(ctx, func(cmd command.Command) error {
+		loaded = append(loaded, cmd)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, id)
+	assert.Equal(t, state, loaded)
+
+	after, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []command.Command{set("y")}, after)
+	assert.EqualValues(t, 3, w.LastSegmentID())
+}
+
+func TestInstallSnapshot_interrupted(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10B",
+		DataDir:        t.TempDir(),
+	}
+
+	set := func(name string) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: "v"}}
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	_, err = w.Load(ctx)
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, w.Save(ctx, set(name)))
+	}
+	require.NoError(t, w.Flush(ctx))
+	cp, err := w.Checkpoint()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSnapshot(ctx, cp, []command.Command{set("a"), set("b"), set("c")}))
+	require.NoError(t, w.Save(ctx, set("d")))
+	w.Close()
+
+	// сбой после записи снимка установки: прежние снимки и журнал еще на месте
+	state := []command.Command{set("x")}
+	pending := path.Join(cfg.DataDir, fmt.Sprintf(snapshotFormat, 2)+installSuffix)
+	require.NoError(t, fstore.WriteFileAtomic(pending, func(f io.Writer) error {
+		return encodeSnapshot(f, nil, 2, state)
+	}))
+
+	// открытие журнала только сообщает о незавершенной установке
+	w, err = New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	found, err := w.PendingInstall()
+	require.NoError(t, err)
+	assert.Equal(t, []string{pending}, found)
+	_, err = w.RestoreTo(ctx, path.Join(t.TempDir(), "restored"), Target{ID: 4})
+	require.ErrorIs(t, err, ErrInstallPending)
+	assert.FileExists(t, path.Join(cfg.DataDir, fmt.Sprintf(snapshotFormat, 3)))
+
+	require.NoError(t, w.FinishInstall(ctx))
+	loaded := []command.Command{}
+	id, err := w.LoadSnapshot(ctx, func(cmd command.Command) error {
+		loaded = append(loaded, cmd)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, id)
+	assert.Equal(t, state, loaded)
+
+	after, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, after)
+	assert.EqualValues(t, 2, w.LastSegmentID())
+	assert.NoFileExists(t, pending)
+}
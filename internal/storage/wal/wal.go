@@ -1,11 +1,9 @@
 package wal
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"sync"
 
@@ -15,18 +13,54 @@ import (
 	"inmem-db/pkg/concurrent"
 )
 
+var ErrGap = errors.New("wal has a gap")
+
 type WAL struct {
 	cfg config.WAL
 
 	mu       sync.RWMutex
 	segments map[ID]Segment
 	maxID    ID
+	// snapshotID - последний сегмент, вошедший в снимок
+	snapshotID ID
+	// recoveredID - последний сегмент, прочитанный при восстановлении,
+	// сегменты до него не хранятся в памяти и читаются с диска
+	recoveredID ID
+	// changed закрывается и заменяется новым после записи каждого сегмента
+	changed chan struct{}
 
 	store *fstore.FStore
-	batch *concurrent.Batch[command.Command]
+	batch *concurrent.Batch[*entry]
+	// segmentPayload - объем команд, после которого пачка продолжается в новом сегменте
+	segmentPayload int
+	codec          codec
+	keys           *Keyring
+
+	durable  *durability
+	closed   chan struct{}
+	syncDone chan struct{}
+}
+
+// entry - команды одного Push, pos - конец пачки с ними в журнале, id - их сегмент
+type entry struct {
+	cmds []command.Command
+	pos  uint64
+	id   ID
 }
 
 func New(cfg config.WAL) (*WAL, error) {
+	cfg, err := validateSync(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseCodec(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := LoadKeyring(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("load encryption keys: %w", err)
+	}
 	store, err := fstore.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("new store: %w", err)
@@ -36,70 +70,135 @@ func New(cfg config.WAL) (*WAL, error) {
 		cfg:      cfg,
 		store:    store,
 		segments: make(map[ID]Segment, 10),
+		changed:  make(chan struct{}),
+		codec:    c,
+		keys:     keys,
+		durable:  newDurability(),
+		closed:   make(chan struct{}),
+		syncDone: make(chan struct{}),
+
+		segmentPayload: maxSegmentPayload,
 	}
 	w.batch = concurrent.NewBatch(
 		int(cfg.BatchSize),
 		cfg.BatchTimeout,
 		w.writeBatch)
 
+	if cfg.SyncMode == config.SyncModeInterval {
+		go w.syncLoop()
+	} else {
+		close(w.syncDone)
+	}
+
 	return &w, nil
 }
 
 func (w *WAL) Save(ctx context.Context, cmd command.Command) error {
-	f := w.batch.Add(ctx, cmd)
-	return f.Get()
+	_, err := w.Push(ctx, []command.Command{cmd}).Get()
+	return err
 }
 
-func (w *WAL) writeBatch(batch []command.Command) error {
-	if len(batch) == 0 {
+// Push ставит команды в очередь на запись одним блоком и сразу возвращается.
+// Порядок вызовов Push сохраняется в журнале. Future завершается сегментом с командами, когда
+// запись сохранена так, как обещает SyncMode: в режиме interval - после ближайшего сброса на диск.
+func (w *WAL) Push(ctx context.Context, cmds []command.Command) *concurrent.ValueFuture[ID] {
+	e := &entry{cmds: cmds}
+	written := w.batch.Add(ctx, e)
+	if written == nil {
 		return nil
 	}
 
-	segment := w.makeSegment(batch)
-	err := w.SaveSegment(segment)
-	if err != nil {
-		return fmt.Errorf("save segment: %w", err)
+	return concurrent.NewValueFuture(func() (ID, error) {
+		err := written.Get()
+		if err != nil {
+			return 0, err
+		}
+		if w.cfg.SyncMode == config.SyncModeInterval {
+			err = w.durable.wait(e.pos)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return e.id, nil
+	})
+}
+
+// Flush дожидается записи всех команд, переданных в Push до вызова
+func (w *WAL) Flush(ctx context.Context) error {
+	_, err := w.Push(context.WithoutCancel(ctx), nil).Get()
+	return err
+}
+
+// WaitSynced дожидается, пока все записанное в журнал, в том числе сегменты от мастера,
+// будет сохранено так, как обещает SyncMode
+func (w *WAL) WaitSynced() error {
+	if w.cfg.SyncMode != config.SyncModeInterval {
+		return nil
 	}
-	return nil
+	return w.durable.wait(w.store.Written())
 }
 
-func (w *WAL) Load(ctx context.Context) ([]command.Command, error) {
-	data, err := w.store.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("load files: %w", err)
+// writeBatch пишет пачку одним сегментом. Большая пачка делится на несколько сегментов
+// по границам Push, чтобы каждый помещался в кадр журнала.
+func (w *WAL) writeBatch(batch []*entry) error {
+	cmds := make([]command.Command, 0, len(batch))
+	size := 0
+	// entries - Push с командами из cmds, им достанется ID сегмента
+	entries := []*entry{}
+	for _, e := range batch {
+		entrySize := 0
+		for _, cmd := range e.cmds {
+			entrySize += cmdSize(cmd)
+		}
+		if len(cmds) > 0 && size+entrySize > w.segmentPayload {
+			err := w.writeSegment(cmds, entries)
+			if err != nil {
+				return err
+			}
+			cmds, size, entries = nil, 0, nil
+		}
+		cmds = append(cmds, e.cmds...)
+		size += entrySize
+		if len(e.cmds) > 0 {
+			entries = append(entries, e)
+		}
 	}
 
-	segments, err := decodeSegments(data)
-	if err != nil {
-		return nil, fmt.Errorf("decode: %w", err)
+	// пачка из одних Flush ничего не пишет, но ждет сброса предыдущих
+	if len(cmds) > 0 {
+		err := w.writeSegment(cmds, entries)
+		if err != nil {
+			return err
+		}
 	}
 
-	commands := make([]command.Command, 0, len(segments)*10)
-	for _, segment := range segments {
-		commands = append(commands, segment.commands...)
-		w.addSegment(segment)
+	pos := w.store.Written()
+	for _, e := range batch {
+		e.pos = pos
 	}
+	return nil
+}
 
-	slog.Debug("wal loaded")
-	return commands, nil
+func (w *WAL) writeSegment(cmds []command.Command, entries []*entry) error {
+	segment := w.makeSegment(cmds)
+	err := w.SaveSegment(segment)
+	if err != nil {
+		return fmt.Errorf("save segment: %w", err)
+	}
+	for _, e := range entries {
+		e.id = segment.ID
+	}
+	return nil
 }
 
+// Close останавливает запись и сбрасывает журнал на диск
 func (w *WAL) Close() {
 	w.batch.Close()
-}
+	close(w.closed)
+	<-w.syncDone
 
-func decodeSegments(data []byte) ([]Segment, error) {
-	buf := bytes.NewBuffer(data)
-
-	segments := make([]Segment, 0, 100)
-	for {
-		segment, err := DecodeSegment(buf)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return segments, nil
-			}
-			return nil, fmt.Errorf("decode cmd: %w", err)
-		}
-		segments = append(segments, segment)
+	err := w.store.Close()
+	if err != nil {
+		slog.Error("close wal", slog.String("error", err.Error()))
 	}
 }
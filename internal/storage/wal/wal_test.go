@@ -2,7 +2,12 @@ package wal
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -10,6 +15,8 @@ import (
 	"inmem-db/internal/compute/parser"
 	"inmem-db/internal/config"
 	"inmem-db/internal/domain/command"
+	"inmem-db/internal/storage/wal/fstore"
+	"inmem-db/pkg/concurrent"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -66,3 +73,374 @@ func TestWAL_WithManyWorkers(t *testing.T) {
 
 	assert.ElementsMatch(t, wantCommands, gotCommands)
 }
+
+func TestWAL_largeBatch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      3,
+		BatchTimeout:   time.Second,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+	w, err := New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	w.segmentPayload = 100
+
+	set := func(name string, size int) command.Command {
+		return command.Command{Type: command.CommandSET, Name: name, Set: command.SetArgs{Value: strings.Repeat("v", size)}}
+	}
+	// первый Push не влезает в сегмент вместе со вторым, третий дописывается ко второму
+	pushes := [][]command.Command{
+		{set("a", 60)},
+		{set("b", 10)},
+		{set("c", 10)},
+	}
+	futures := make([]*concurrent.ValueFuture[ID], 0, len(pushes))
+	for _, cmds := range pushes {
+		futures = append(futures, w.Push(ctx, cmds))
+	}
+	ids := []ID{}
+	for _, f := range futures {
+		id, err := f.Get()
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []ID{1, 2, 2}, ids)
+
+	segments := w.SegmentsAfter(0)
+	require.Len(t, segments, 2)
+	assert.Equal(t, pushes[0], segments[0].commands)
+	assert.Equal(t, append(pushes[1], pushes[2]...), segments[1].commands)
+}
+
+func TestLoad_damaged(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		// damage портит файлы журнала, files - их имена по порядку
+		damage func(t *testing.T, dir string, files []string)
+
+		want int
+		err  error
+		// errFile - индекс файла, названного в ошибке, -1 - файл не называется
+		errFile int
+	}
+
+	tests := map[string]test{
+		"torn tail": {
+			damage: func(t *testing.T, dir string, files []string) {
+				last := lastWithData(t, dir, files)
+				stat, err := os.Stat(last)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(last, stat.Size()-3))
+			},
+			want: 3,
+		},
+		"corrupted tail": {
+			damage: func(t *testing.T, dir string, files []string) {
+				flipByte(t, lastWithData(t, dir, files), -1)
+			},
+			want: 3,
+		},
+		"corrupted middle": {
+			damage: func(t *testing.T, dir string, files []string) {
+				flipByte(t, path.Join(dir, files[0]), -1)
+			},
+			err: ErrCorrupted,
+		},
+		"first file removed": {
+			damage: func(t *testing.T, dir string, files []string) {
+				require.NoError(t, os.Remove(path.Join(dir, files[0])))
+			},
+			err:     ErrGap,
+			errFile: 1,
+		},
+		"corrupted only snapshot": {
+			damage: func(t *testing.T, dir string, files []string) {
+				// файлы журнала до снимка удалены при его записи
+				require.NoError(t, os.Remove(path.Join(dir, files[0])))
+				broken := path.Join(dir, "snapshot_00000000000000000001.bin")
+				require.NoError(t, os.WriteFile(broken, []byte("INMEMSNP"), 0o644))
+			},
+			err:     ErrGap,
+			errFile: -1,
+		},
+		"corrupted snapshot after wal": {
+			damage: func(t *testing.T, dir string, files []string) {
+				broken := path.Join(dir, "snapshot_00000000000000000010.bin")
+				require.NoError(t, os.WriteFile(broken, []byte("INMEMSNP"), 0o644))
+			},
+			err:     ErrGap,
+			errFile: -1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			cfg := config.WAL{
+				BatchSize:      1,
+				BatchTimeout:   time.Millisecond,
+				MaxSegmentSize: "30B",
+				DataDir:        t.TempDir(),
+			}
+
+			w, err := New(cfg)
+			require.NoError(t, err)
+			for i := range 4 {
+				require.NoError(t, w.Save(ctx, command.Command{
+					Type: command.CommandSET,
+					Name: fmt.Sprintf("name%d", i),
+					Set:  command.SetArgs{Value: "value"},
+				}))
+			}
+			w.Close()
+
+			entries, err := os.ReadDir(cfg.DataDir)
+			require.NoError(t, err)
+			files := []string{}
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			require.Greater(t, len(files), 1)
+			tc.damage(t, cfg.DataDir, files)
+
+			w, err = New(cfg)
+			require.NoError(t, err)
+			_, err = w.LoadSnapshot(ctx, func(command.Command) error { return nil })
+			require.NoError(t, err)
+			cmds, err := w.Load(ctx)
+			if tc.err != nil {
+				w.Close()
+				require.ErrorIs(t, err, tc.err)
+				if tc.errFile >= 0 {
+					assert.Contains(t, err.Error(), files[tc.errFile])
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, cmds, tc.want)
+
+			// после обрезки журнал продолжает писаться и читаться
+			require.NoError(t, w.Save(ctx, command.Command{Type: command.CommandDEL, Name: "name0"}))
+			w.Close()
+			w, err = New(cfg)
+			require.NoError(t, err)
+			defer w.Close()
+			cmds, err = w.Load(ctx)
+			require.NoError(t, err)
+			assert.Len(t, cmds, tc.want+1)
+		})
+	}
+}
+
+func TestLoad_damagedLastFile(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		// damage портит файл с сегментами, infos - их места в файле
+		damage func(t *testing.T, name string, infos []SegmentInfo)
+
+		want int
+		err  error
+	}
+
+	tests := map[string]test{
+		"torn tail": {
+			damage: func(t *testing.T, name string, infos []SegmentInfo) {
+				last := infos[len(infos)-1]
+				require.NoError(t, os.Truncate(name, last.Offset+last.Size-3))
+			},
+			want: 2,
+		},
+		"corrupted length before valid segment": {
+			damage: func(t *testing.T, name string, infos []SegmentInfo) {
+				// длина второго сегмента указывает за конец файла
+				data, err := os.ReadFile(name)
+				require.NoError(t, err)
+				binary.BigEndian.PutUint32(data[infos[1].Offset:], 1<<20)
+				require.NoError(t, os.WriteFile(name, data, 0o644))
+			},
+			err: ErrTruncated,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			cfg := config.WAL{
+				BatchSize:      1,
+				BatchTimeout:   time.Millisecond,
+				MaxSegmentSize: "10MB",
+				DataDir:        t.TempDir(),
+			}
+			writeSegments(t, cfg, 3)
+
+			w, err := New(cfg)
+			require.NoError(t, err)
+			infos := []SegmentInfo{}
+			_, err = w.Inspect(ctx, func(info SegmentInfo) error {
+				infos = append(infos, info)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Len(t, infos, 3)
+			tc.damage(t, path.Join(cfg.DataDir, infos[0].File), infos)
+
+			cmds, err := w.Load(ctx)
+			w.Close()
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				assert.Contains(t, err.Error(), fmt.Sprintf("offset %d", infos[1].Offset))
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, cmds, tc.want)
+		})
+	}
+}
+
+// flipByte портит байт файла, отрицательный offset отсчитывается от конца
+func flipByte(t *testing.T, name string, offset int) {
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	if offset < 0 {
+		offset += len(data)
+	}
+	data[offset] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+}
+
+// lastWithData возвращает последний файл журнала с сегментами
+func lastWithData(t *testing.T, dir string, files []string) string {
+	for _, name := range slices.Backward(files) {
+		stat, err := os.Stat(path.Join(dir, name))
+		require.NoError(t, err)
+		if stat.Size() > int64(fstore.HeaderSize) {
+			return path.Join(dir, name)
+		}
+	}
+	t.Fatal("wal is empty")
+	return ""
+}
+
+func TestWAL_mixedCompression(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := config.WAL{
+		BatchSize:      1,
+		BatchTimeout:   time.Millisecond,
+		MaxSegmentSize: "10MB",
+		DataDir:        t.TempDir(),
+	}
+
+	value := strings.Repeat("compressible ", 100)
+	want := []command.Command{}
+	// сегменты с разным сжатием лежат в одном файле
+	for _, c := range []config.Compression{config.CompressionZstd, config.CompressionSnappy, config.CompressionNone} {
+		cfg.Compression = c
+		w, err := New(cfg)
+		require.NoError(t, err)
+		_, err = w.Load(ctx)
+		require.NoError(t, err)
+
+		cmd := command.Command{Type: command.CommandSET, Name: string(c), Set: command.SetArgs{Value: value}}
+		require.NoError(t, w.Save(ctx, cmd))
+		want = append(want, cmd)
+		w.Close()
+	}
+
+	w, err := New(cfg)
+	require.NoError(t, err)
+	defer w.Close()
+	cmds, err := w.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, want, cmds)
+
+	entries, err := os.ReadDir(cfg.DataDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = New(config.WAL{MaxSegmentSize: "1MB", Compression: "lz4"})
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+}
+
+func TestLoad_tornHeader(t *testing.T) {
+	t.Parallel()
+
+	header := []byte("INMEMWAL")
+
+	type test struct {
+		// data - начало файла, созданного после файла с сегментами
+		data []byte
+		// next - за файлом с заголовком есть файл с сегментами
+		next bool
+
+		err error
+	}
+
+	tests := map[string]test{
+		"partial magic": {data: header[:5]},
+		"short header":  {data: append(append([]byte{}, header...), 0, byte(fstore.Version))},
+		"not last file": {data: header[:5], next: true, err: fstore.ErrTornHeader},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			cfg := config.WAL{
+				BatchSize:      1,
+				BatchTimeout:   time.Millisecond,
+				MaxSegmentSize: "10MB",
+				DataDir:        t.TempDir(),
+			}
+			files := writeSegments(t, cfg, 3)
+			last := fstore.FileNum(files[len(files)-1])
+			torn := path.Join(cfg.DataDir, fmt.Sprintf("wal_%04d.bin", last+1))
+			require.NoError(t, os.WriteFile(torn, tc.data, 0o644))
+			if tc.next {
+				data, err := os.ReadFile(path.Join(cfg.DataDir, files[len(files)-1]))
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(path.Join(cfg.DataDir, fmt.Sprintf("wal_%04d.bin", last+2)), data, 0o644))
+			}
+
+			w, err := New(cfg)
+			require.NoError(t, err)
+			cmds, err := w.Load(ctx)
+			if tc.err != nil {
+				w.Close()
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, cmds, 3)
+
+			// файл с оборванным заголовком записывается заново
+			require.NoError(t, w.Save(ctx, command.Command{Type: command.CommandDEL, Name: "name0"}))
+			w.Close()
+
+			w, err = New(cfg)
+			require.NoError(t, err)
+			defer w.Close()
+			cmds, err = w.Load(ctx)
+			require.NoError(t, err)
+			assert.Len(t, cmds, 4)
+			assert.EqualValues(t, 4, w.LastSegmentID())
+		})
+	}
+}
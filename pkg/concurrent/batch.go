@@ -2,14 +2,21 @@ package concurrent
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var ErrClosed = errors.New("batch is closed")
+
+type item[T any] struct {
+	v   T
+	err chan error
+}
+
 type Batch[T any] struct {
 	isClosed chan struct{}
 
-	queue chan T
-	errs  chan error
+	queue chan item[T]
 
 	handleBatch func([]T) error
 	maxSize     int
@@ -19,8 +26,7 @@ type Batch[T any] struct {
 func NewBatch[T any](size int, timeout time.Duration, handleBatch func([]T) error) *Batch[T] {
 	b := Batch[T]{
 		isClosed: make(chan struct{}),
-		queue:    make(chan T),
-		errs:     make(chan error),
+		queue:    make(chan item[T]),
 
 		handleBatch: handleBatch,
 		maxSize:     size,
@@ -31,62 +37,71 @@ func NewBatch[T any](size int, timeout time.Duration, handleBatch func([]T) erro
 	return &b
 }
 
+// Add ставит значение в очередь, Future завершается результатом обработки пачки с этим значением
 func (b *Batch[T]) Add(ctx context.Context, v T) *Future {
+	it := item[T]{
+		v:   v,
+		err: make(chan error, 1),
+	}
+
 	select {
 	case <-ctx.Done():
 		return nil
-	case b.queue <- v:
+	case <-b.isClosed:
+		f := NewFuture()
+		f.Set(func() error { return ErrClosed })
+		return f
+	case b.queue <- it:
 	}
 
-	recvErr := func() error { return <-b.errs }
 	f := NewFuture()
-	f.Set(recvErr)
+	f.Set(func() error { return <-it.err })
 
 	return f
 }
 
 func (b *Batch[T]) serve() {
+	items := make([]item[T], 0, b.maxSize)
 	values := make([]T, 0, b.maxSize)
 
 	go func() {
 		for {
 			select {
 			case <-b.isClosed:
-				close(b.errs)
 				return
 			default:
 			}
 
-			values = b.waitBatch(values)
+			items = b.waitBatch(items)
+			for _, it := range items {
+				values = append(values, it.v)
+			}
 			err := b.handleBatch(values)
-			b.sendErrs(len(values), err)
+			for _, it := range items {
+				it.err <- err
+			}
 
+			items = items[:0]
 			values = values[:0]
 		}
 	}()
 }
 
-func (b *Batch[T]) sendErrs(count int, err error) {
-	for range count {
-		b.errs <- err
-	}
-}
-
-func (b *Batch[T]) waitBatch(values []T) []T {
+func (b *Batch[T]) waitBatch(items []item[T]) []item[T] {
 	t := time.NewTimer(b.timeout)
 	defer t.Stop()
 
 	for {
 		select {
 		case <-b.isClosed:
-			return values
+			return items
 		case <-t.C:
-			return values
+			return items
 
-		case v := <-b.queue:
-			values = append(values, v)
-			if len(values) == b.maxSize {
-				return values
+		case it := <-b.queue:
+			items = append(items, it)
+			if len(items) == b.maxSize {
+				return items
 			}
 
 		}
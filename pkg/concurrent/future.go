@@ -31,3 +31,29 @@ func (f *Future) Get() error {
 	defer close(f.out)
 	return <-f.out
 }
+
+// ValueFuture - Future, который вместе с ошибкой возвращает значение
+type ValueFuture[T any] struct {
+	f     *Future
+	value T
+}
+
+func NewValueFuture[T any](action func() (T, error)) *ValueFuture[T] {
+	vf := &ValueFuture[T]{f: NewFuture()}
+	vf.f.Set(func() error {
+		v, err := action()
+		vf.value = v
+		return err
+	})
+	return vf
+}
+
+func (f *ValueFuture[T]) Get() (T, error) {
+	if f == nil {
+		var zero T
+		return zero, nil
+	}
+
+	err := f.f.Get()
+	return f.value, err
+}
@@ -0,0 +1,111 @@
+package glob
+
+import "strings"
+
+// Matcher проверяет ключ на соответствие glob шаблону в стиле redis:
+// * - любая последовательность, ? - любой символ, [abc], [^a-z] - набор символов,
+// \ экранирует следующий символ. В отличие от path.Match символ / не особенный.
+type Matcher func(key string) bool
+
+// New возвращает Matcher для шаблона, пустой шаблон подходит для любого ключа
+func New(pattern string) Matcher {
+	if pattern == "" || pattern == "*" {
+		return func(string) bool { return true }
+	}
+
+	// частый случай - поиск по префиксу
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if ok && !strings.ContainsAny(prefix, `*?[\`) {
+		return func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		}
+	}
+
+	return func(key string) bool {
+		return globMatch(pattern, key)
+	}
+}
+
+// globMatch сопоставляет без рекурсии: при несовпадении возвращается только к последней *,
+// которая забирает на символ больше. Более ранние * пересматривать не нужно, поэтому
+// время O(len(pattern) * len(s)) даже для шаблонов вида *a*a*a*b.
+func globMatch(pattern string, s string) bool {
+	p, i := 0, 0
+	// starP - позиция в шаблоне после последней *, starS - с какого символа s она продолжится
+	starP, starS := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			p++
+			starP, starS = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if next, ok := matchChar(pattern[p:], s[i]); ok {
+				p, i = len(pattern)-len(next), i+1
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, i = starP, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchChar проверяет символ c по первому элементу шаблона, кроме *.
+// Возвращает остаток шаблона после элемента.
+func matchChar(pattern string, c byte) (string, bool) {
+	switch pattern[0] {
+	case '?':
+		return pattern[1:], true
+	case '[':
+		return matchClass(pattern[1:], c)
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+	return pattern[1:], pattern[0] == c
+}
+
+// matchClass проверяет символ по набору [...], pattern начинается после '['.
+// Возвращает остаток шаблона после ']'.
+func matchClass(pattern string, c byte) (string, bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		lo := pattern[0]
+		if lo == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			lo = pattern[0]
+		}
+		pattern = pattern[1:]
+
+		hi := lo
+		if len(pattern) > 1 && pattern[0] == '-' && pattern[1] != ']' {
+			hi = pattern[1]
+			pattern = pattern[2:]
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return pattern, matched != negate
+}
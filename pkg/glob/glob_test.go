@@ -0,0 +1,46 @@
+package glob
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcher(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		pattern string
+		key     string
+		want    bool
+	}
+
+	tests := map[string]test{
+		"empty pattern":       {pattern: "", key: "name", want: true},
+		"prefix":              {pattern: "user:*", key: "user:1", want: true},
+		"other prefix":        {pattern: "user:*", key: "session:1", want: false},
+		"star with slash":     {pattern: "a*c", key: "a/b/c", want: true},
+		"question":            {pattern: "k?y", key: "key", want: true},
+		"question no char":    {pattern: "key?", key: "key", want: false},
+		"class":               {pattern: "k[aeiou]y", key: "key", want: true},
+		"class range":         {pattern: "evt:[0-9]*", key: "evt:2026", want: true},
+		"negated class":       {pattern: "k[^e]y", key: "key", want: false},
+		"escaped star":        {pattern: `a\*`, key: "a*", want: true},
+		"escaped star no key": {pattern: `a\*`, key: "ab", want: false},
+		"middle star":         {pattern: "evt:*:end", key: "evt:2026-10-18:end", want: true},
+		"middle star suffix":  {pattern: "evt:*:end", key: "evt:2026-10-18:end2", want: false},
+		"star backtracks":     {pattern: "*ab*c", key: "aab-abxc", want: true},
+		"star then class":     {pattern: "*[0-9]", key: "evt:a1", want: true},
+		"trailing stars":      {pattern: "a**", key: "a", want: true},
+		"many stars no match": {pattern: "*a*a*a*a*a*a*a*a*b", key: strings.Repeat("a", 100), want: false},
+		"many stars match":    {pattern: "*a*a*a*a*a*a*a*a*b", key: strings.Repeat("a", 100) + "b", want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, New(tc.pattern)(tc.key))
+		})
+	}
+}